@@ -0,0 +1,213 @@
+package safe
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// Apps authenticate REST requests by signing them with the private key of
+// their attorney token. The signed message is built by AppRequestMessage.
+// The headers are distinct from the X-Safe-Timestamp and X-Safe-Signature
+// of the responses signed by the safe.
+const (
+	HeaderAppToken     = "X-Safe-App"
+	HeaderAppTimestamp = "X-Safe-App-Timestamp"
+	HeaderAppNonce     = "X-Safe-App-Nonce"
+	HeaderAppSignature = "X-Safe-App-Signature"
+)
+
+const (
+	// appRequestWindow is the maximum clock difference accepted between
+	// the app and the safe.
+	appRequestWindow = 5 * time.Minute
+	// maxAppRequestBody bounds the body read to check a signature.
+	maxAppRequestBody = 1 << 20
+	// nonces are between minAppNonce and maxAppNonce printable characters
+	minAppNonce = 16
+	maxAppNonce = 128
+	// maxAppNonces bounds the nonces remembered within appRequestWindow.
+	maxAppNonces = 100000
+	// nonces past the window are swept every appNonceSweep, or on every
+	// request while the store is full
+	appNonceSweep = time.Minute
+)
+
+var (
+	ErrAppToken     = fmt.Errorf("missing or invalid %v header", HeaderAppToken)
+	ErrAppTimestamp = fmt.Errorf("missing or invalid %v header", HeaderAppTimestamp)
+	ErrAppNonce     = fmt.Errorf("missing or invalid %v header", HeaderAppNonce)
+	ErrAppWindow    = errors.New("request timestamp out of window")
+	ErrAppSignature = fmt.Errorf("missing or invalid %v header", HeaderAppSignature)
+	ErrAppReplay    = errors.New("request already received")
+	ErrAppBusy      = errors.New("too many signed requests")
+)
+
+// AppRequestMessage returns the message signed by apps: method, path, raw
+// query (empty if none), timestamp, nonce and the hex sha256 of the body,
+// one per line.
+func AppRequestMessage(method, path, query, timestamp, nonce string, body []byte) []byte {
+	hash := sha256.Sum256(body)
+	return []byte(fmt.Sprintf("%v\n%v\n%v\n%v\n%v\n%v", method, path, query, timestamp, nonce, hex.EncodeToString(hash[:])))
+}
+
+// SignAppRequest adds the app authentication headers to r with a fresh
+// nonce. body must be the exact body sent with the request.
+func SignAppRequest(r *http.Request, body []byte, key crypto.PrivateKey) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := randomString()
+	signature := key.Sign(AppRequestMessage(r.Method, r.URL.Path, r.URL.RawQuery, timestamp, nonce, body))
+	r.Header.Set(HeaderAppToken, key.PublicKey().Hex())
+	r.Header.Set(HeaderAppTimestamp, timestamp)
+	r.Header.Set(HeaderAppNonce, nonce)
+	r.Header.Set(HeaderAppSignature, hex.EncodeToString(signature[:]))
+}
+
+// validNonce tells whether nonce has an accepted length and only printable
+// ASCII characters, so that it cannot add lines to the signed message.
+func validNonce(nonce string) bool {
+	if len(nonce) < minAppNonce || len(nonce) > maxAppNonce {
+		return false
+	}
+	for n := 0; n < len(nonce); n++ {
+		if nonce[n] <= ' ' || nonce[n] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyAppRequest checks the app authentication headers of r and returns
+// the app token. The body is read and put back into r.
+func VerifyAppRequest(r *http.Request) (crypto.Token, error) {
+	token, ok := attorneyToken(r.Header.Get(HeaderAppToken))
+	if !ok {
+		return crypto.ZeroToken, ErrAppToken
+	}
+	timestamp := r.Header.Get(HeaderAppTimestamp)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return crypto.ZeroToken, ErrAppTimestamp
+	}
+	if delta := time.Since(time.Unix(seconds, 0)); delta > appRequestWindow || delta < -appRequestWindow {
+		return crypto.ZeroToken, ErrAppWindow
+	}
+	nonce := r.Header.Get(HeaderAppNonce)
+	if !validNonce(nonce) {
+		return crypto.ZeroToken, ErrAppNonce
+	}
+	signatureBytes, _ := hex.DecodeString(r.Header.Get(HeaderAppSignature))
+	var signature crypto.Signature
+	if len(signatureBytes) != len(signature) {
+		return crypto.ZeroToken, ErrAppSignature
+	}
+	copy(signature[:], signatureBytes)
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(http.MaxBytesReader(nil, r.Body, maxAppRequestBody))
+		if err != nil {
+			return crypto.ZeroToken, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	if !token.Verify(AppRequestMessage(r.Method, r.URL.Path, r.URL.RawQuery, timestamp, nonce, body), signature) {
+		return crypto.ZeroToken, ErrAppSignature
+	}
+	return token, nil
+}
+
+type appNonce struct {
+	app   string
+	nonce string
+}
+
+// AppNonceStore remembers the nonces of signed requests until their
+// timestamp leaves appRequestWindow, so that a request cannot be replayed.
+type AppNonceStore struct {
+	mu    sync.Mutex
+	seen  map[appNonce]time.Time
+	swept time.Time
+}
+
+func NewAppNonceStore() *AppNonceStore {
+	return &AppNonceStore{seen: make(map[appNonce]time.Time)}
+}
+
+func (s *AppNonceStore) sweep(now time.Time) {
+	full := len(s.seen) >= maxAppNonces
+	if now.Sub(s.swept) < appNonceSweep && !full {
+		return
+	}
+	s.swept = now
+	for key, expires := range s.seen {
+		if now.After(expires) {
+			delete(s.seen, key)
+		}
+	}
+}
+
+// Use records the nonce of app for a request signed at timestamp. It
+// returns ErrAppReplay if the nonce was already used and ErrAppBusy if too
+// many nonces are remembered.
+func (s *AppNonceStore) Use(app crypto.Token, nonce string, timestamp time.Time) error {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+	key := appNonce{app: app.Hex(), nonce: nonce}
+	if _, ok := s.seen[key]; ok {
+		return ErrAppReplay
+	}
+	if len(s.seen) >= maxAppNonces {
+		return ErrAppBusy
+	}
+	s.seen[key] = timestamp.Add(appRequestWindow)
+	return nil
+}
+
+// verifyApps refuses signed requests with an invalid signature or a nonce
+// already used by the app before they reach next. Requests without
+// HeaderAppToken are passed on unchanged.
+func (rest *RestAPI) verifyApps(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(HeaderAppToken) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		language := rest.Safe.Language(r)
+		app, err := VerifyAppRequest(r)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized, translateError(language, err))
+			return
+		}
+		seconds, _ := strconv.ParseInt(r.Header.Get(HeaderAppTimestamp), 10, 64)
+		if err := rest.Safe.appNonces.Use(app, r.Header.Get(HeaderAppNonce), time.Unix(seconds, 0)); err != nil {
+			if errors.Is(err, ErrAppBusy) {
+				writeError(w, http.StatusServiceUnavailable, ErrBusy, translateError(language, err))
+			} else {
+				writeError(w, http.StatusUnauthorized, ErrRequestReplayed, translateError(language, err))
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateApp is like authorize but for requests signed by apps.
+func (rest *RestAPI) authenticateApp(w http.ResponseWriter, r *http.Request) (crypto.Token, bool) {
+	token, err := VerifyAppRequest(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, ErrUnauthorized, translateError(rest.Safe.Language(r), err))
+		return crypto.ZeroToken, false
+	}
+	return token, true
+}
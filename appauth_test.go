@@ -0,0 +1,75 @@
+package safe
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func TestAppRequestReplay(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	_, key := crypto.RandomAsymetricKey()
+	handler := (&RestAPI{Safe: s}).Handler()
+	r := httptest.NewRequest(http.MethodGet, WellKnownPath, nil)
+	SignAppRequest(r, nil, key)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("signed request = %v: %v", w.Code, w.Body)
+	}
+	replay := httptest.NewRequest(http.MethodGet, WellKnownPath, nil)
+	replay.Header = r.Header.Clone()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, replay)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed request = %v", w.Code)
+	}
+
+	tampered := httptest.NewRequest(http.MethodGet, WellKnownPath, nil)
+	SignAppRequest(tampered, nil, key)
+	tampered.Header.Set(HeaderAppNonce, strings.Repeat("a", minAppNonce))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, tampered)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("request with a changed nonce = %v", w.Code)
+	}
+}
+
+func TestAppRequestNonce(t *testing.T) {
+	_, key := crypto.RandomAsymetricKey()
+	for _, nonce := range []string{"", "short", strings.Repeat("a", maxAppNonce+1), strings.Repeat("a", minAppNonce) + "\nGET"} {
+		r := httptest.NewRequest(http.MethodGet, "/v1/apps", nil)
+		SignAppRequest(r, nil, key)
+		r.Header.Set(HeaderAppNonce, nonce)
+		if _, err := VerifyAppRequest(r); err != ErrAppNonce {
+			t.Errorf("nonce %q: err = %v, want ErrAppNonce", nonce, err)
+		}
+	}
+}
+
+func TestAppRequestBodyLimit(t *testing.T) {
+	_, key := crypto.RandomAsymetricKey()
+	body := bytes.Repeat([]byte("a"), maxAppRequestBody+1)
+	r := httptest.NewRequest(http.MethodPost, "/v1/users", bytes.NewReader(body))
+	SignAppRequest(r, body, key)
+	if _, err := VerifyAppRequest(r); err == nil {
+		t.Errorf("a body over maxAppRequestBody was accepted")
+	}
+}
+
+func TestAppRequestQuery(t *testing.T) {
+	_, key := crypto.RandomAsymetricKey()
+	r := httptest.NewRequest(http.MethodGet, "/v1/users/alice/history?limit=10", nil)
+	SignAppRequest(r, nil, key)
+	if _, err := VerifyAppRequest(r); err != nil {
+		t.Fatalf("VerifyAppRequest: %v", err)
+	}
+	r.URL.RawQuery = "limit=1000"
+	if _, err := VerifyAppRequest(r); err != ErrAppSignature {
+		t.Errorf("request with a changed query: err = %v, want ErrAppSignature", err)
+	}
+}
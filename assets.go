@@ -0,0 +1,140 @@
+package safe
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// The templates and static files are compiled into the binary. Files in
+// SafeConfig.HtmlPath, laid out the same way, take precedence over them.
+//
+//go:embed templates/*.html static
+var embeddedAssets embed.FS
+
+// overlayFS opens files from the override directory and falls back to the
+// embedded ones when they are missing there.
+type overlayFS struct {
+	override fs.FS
+	base     fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if o.override != nil {
+		file, err := o.override.Open(name)
+		if err == nil {
+			return file, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return o.base.Open(name)
+}
+
+// assetsFS returns the assets of the safe with the files in dir, if any,
+// overriding the embedded ones.
+func assetsFS(dir string) (fs.FS, error) {
+	if dir == "" {
+		return embeddedAssets, nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not open html override directory: %v", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("html override path %v is not a directory", dir)
+	}
+	return overlayFS{override: os.DirFS(dir), base: embeddedAssets}, nil
+}
+
+// Templates renders the pages of the safe with one set of templates per
+// language. In dev mode templates are parsed again on every render so that
+// edits show up without a restart.
+type Templates struct {
+	mu     sync.Mutex
+	assets fs.FS
+	dev    bool
+	parsed map[string]*template.Template
+}
+
+// templateFuncs translates the pages to language. t formats a message of
+// the catalog, th a message carrying markup, and lang is the language of
+// the page.
+func templateFuncs(language string) template.FuncMap {
+	return template.FuncMap{
+		"t": func(key string, args ...any) string {
+			return Translate(language, key, args...)
+		},
+		"th": func(key string, args ...any) template.HTML {
+			return translateHTML(language, key, args...)
+		},
+		"lang": func() string {
+			return language
+		},
+	}
+}
+
+func parseTemplates(assets fs.FS, language string) (*template.Template, error) {
+	files := make([]string, len(templateFiles))
+	for n, file := range templateFiles {
+		files[n] = fmt.Sprintf("templates/%v.html", file)
+	}
+	return template.New("root").Funcs(templateFuncs(language)).ParseFS(assets, files...)
+}
+
+func parseLanguages(assets fs.FS) (map[string]*template.Template, error) {
+	parsed := make(map[string]*template.Template)
+	for _, language := range Languages {
+		t, err := parseTemplates(assets, language)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse templates: %v", err)
+		}
+		parsed[language] = t
+	}
+	return parsed, nil
+}
+
+// NewTemplates parses the templates from assets. Parsing errors are
+// reported even in dev mode so that the safe does not start broken.
+func NewTemplates(assets fs.FS, dev bool) (*Templates, error) {
+	parsed, err := parseLanguages(assets)
+	if err != nil {
+		return nil, err
+	}
+	return &Templates{assets: assets, dev: dev, parsed: parsed}, nil
+}
+
+// Execute renders the template name in language, or in DefaultLanguage if
+// language has no catalog.
+func (t *Templates) Execute(w io.Writer, language, name string, data any) error {
+	t.mu.Lock()
+	if t.dev {
+		parsed, err := parseLanguages(t.assets)
+		if err != nil {
+			t.mu.Unlock()
+			return err
+		}
+		t.parsed = parsed
+	}
+	templates, ok := t.parsed[language]
+	if !ok {
+		templates = t.parsed[DefaultLanguage]
+	}
+	t.mu.Unlock()
+	return templates.ExecuteTemplate(w, name, data)
+}
+
+// render writes the page name in the language of r.
+func (s *Safe) render(w http.ResponseWriter, r *http.Request, name string, view any) {
+	if err := s.templates.Execute(w, s.Language(r), name, view); err != nil {
+		log.Println(err)
+	}
+}
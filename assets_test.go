@@ -0,0 +1,169 @@
+package safe
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testPages are views of every page exercising most of their branches.
+var testPages = map[string][]any{
+	"main.html": {
+		UserView{Handle: "alice", Error: "user not found"},
+		UserView{
+			Handle:    "alice",
+			Live:      true,
+			Frozen:    true,
+			TwoFactor: true,
+			CSRF:      "csrf",
+			Attorneys: []AttorneyView{
+				{Token: "aa", Info: AppInfo{Token: "aa", Name: "App", Homepage: "https://app.example.com", Verified: true}, Method: "web", Epoch: 10, Scopes: []string{ScopeEmail}, Fingerprint: "ff", Expires: "tomorrow"},
+				{Token: "bb", Short: "bb", Info: AppInfo{Token: "bb"}},
+			},
+			Awaiting: []AttorneyView{{Token: "cc", Method: "rest", Epoch: 11}},
+			Flash:    Flash{Error: "error", Fields: map[string]string{"unfreeze": "wrong password"}, Values: map[string]string{"attorney": "dd"}},
+		},
+		UserView{Handle: "alice"},
+	},
+	"grant.html":  {"csrf"},
+	"revoke.html": {"csrf"},
+	"login.html":  {LoginView{Next: "/", Flash: Flash{Notice: "notice"}}},
+	"signin.html": {SigninView{Policy: DefaultHandlePolicy, Flash: Flash{Fields: map[string]string{"handle": "taken"}}}},
+	"confirm.html": {ConsentView{
+		Handle:    "alice",
+		Secret:    "secret",
+		Attorney:  "aa",
+		App:       "App",
+		Info:      AppInfo{Token: "aa", Name: "App", Verified: true},
+		Scopes:    scopesView([]string{ScopeEmail}),
+		TwoFactor: true,
+		CSRF:      "csrf",
+	}},
+	"authorize.html": {
+		AuthorizeView{Handle: "alice", Client: "client", Attorney: "aa", Grant: true, Scopes: scopesView(nil), Action: "/authorize", TwoFactor: true, CSRF: "csrf", Info: AppInfo{Token: "aa"}},
+		AuthorizeView{Handle: "alice", Client: "client", Action: "/authorize"},
+	},
+	"challenge.html": {ChallengeView{ID: "id", Handle: "alice", App: "aa", Nonce: "nonce", CSRF: "csrf"}},
+	"twofactor.html": {
+		TwoFactorLoginView{Ticket: "ticket", TOTP: true, SecurityKey: true, Error: "invalid code"},
+		TwoFactorLoginView{Ticket: "ticket", SecurityKey: true},
+	},
+	"totp.html": {
+		TOTPView{Handle: "alice", CSRF: "csrf"},
+		TOTPView{Handle: "alice", Enrolling: true, Secret: "ABCD EFGH", URI: "otpauth://totp/safe:alice", CSRF: "csrf"},
+		TOTPView{Handle: "alice", Enabled: true, Recovery: []string{"code"}, RecoveryLeft: 9, Error: "error", CSRF: "csrf"},
+	},
+	"passkeys.html": {PasskeysView{Handle: "alice", TwoFactor: true, Credentials: []CredentialView{{ID: "id", Name: "key", SecondFactor: true, Created: "2024-01-01 10:00"}}, Error: "error"}},
+	"settings.html": {SettingsView{Handle: "alice", Email: "alice@example.com", TwoFactor: true, PasswordError: "error", Message: "saved", Language: LanguagePortuguese, Languages: Languages}},
+	"history.html": {
+		HistoryView{Handle: "alice", Entries: []HistoryEntry{{Action: "grant", Epoch: 1, Attorney: "aa", Short: "aa", Name: "App", Verified: true, Status: "confirmed", Details: "web"}}},
+		HistoryView{Handle: "alice", Error: "error"},
+	},
+}
+
+func TestTemplatesRender(t *testing.T) {
+	templates, err := NewTemplates(embeddedAssets, false)
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	for _, file := range templateFiles {
+		if _, ok := testPages[file+".html"]; !ok && file != "flash" {
+			t.Errorf("page %v is not tested", file)
+		}
+	}
+	for _, language := range Languages {
+		for page, views := range testPages {
+			for n, view := range views {
+				var out bytes.Buffer
+				if err := templates.Execute(&out, language, page, view); err != nil {
+					t.Errorf("%v view %v in %v: %v", page, n, language, err)
+					continue
+				}
+				if !strings.Contains(out.String(), `lang="`+language+`"`) {
+					t.Errorf("%v in %v is not marked as %v", page, language, language)
+				}
+			}
+		}
+	}
+}
+
+func TestTemplatesTranslate(t *testing.T) {
+	templates, err := NewTemplates(embeddedAssets, false)
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	for _, language := range Languages {
+		var out bytes.Buffer
+		if err := templates.Execute(&out, language, "main.html", testPages["main.html"][1]); err != nil {
+			t.Fatalf("Execute: %v", err)
+		}
+		if logout := Translate(language, "nav.logout"); !strings.Contains(out.String(), logout) {
+			t.Errorf("main page in %v without %q", language, logout)
+		}
+		// form values are read by the handlers, they are not translated
+		out.Reset()
+		if err := templates.Execute(&out, language, "totp.html", testPages["totp.html"][1]); err != nil {
+			t.Fatalf("Execute: %v", err)
+		}
+		if !strings.Contains(out.String(), `name="action" value="confirm"`) {
+			t.Errorf("confirm action of the totp page in %v translated", language)
+		}
+	}
+	var fallback bytes.Buffer
+	if err := templates.Execute(&fallback, "xx", "login.html", LoginView{}); err != nil || !strings.Contains(fallback.String(), `lang="`+DefaultLanguage+`"`) {
+		t.Errorf("unknown language not rendered in %v: %v", DefaultLanguage, err)
+	}
+}
+
+func TestTemplatesOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "templates"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	login := filepath.Join(dir, "templates", "login.html")
+	if err := os.WriteFile(login, []byte(`<html lang="{{lang}}">custom {{t "nav.logout"}}</html>`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assets, err := assetsFS(dir)
+	if err != nil {
+		t.Fatalf("assetsFS: %v", err)
+	}
+	templates, err := NewTemplates(assets, true)
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	var out bytes.Buffer
+	if err := templates.Execute(&out, LanguagePortuguese, "login.html", LoginView{}); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if out.String() != `<html lang="`+LanguagePortuguese+`">custom `+Translate(LanguagePortuguese, "nav.logout")+`</html>` {
+		t.Errorf("override rendered %q", out.String())
+	}
+	out.Reset()
+	if err := templates.Execute(&out, LanguagePortuguese, "signin.html", SigninView{Policy: DefaultHandlePolicy}); err != nil || !strings.Contains(out.String(), "newuser") {
+		t.Errorf("embedded page not rendered next to the override: %v", err)
+	}
+
+	// dev mode picks up edits, and broken ones are reported
+	os.WriteFile(login, []byte(`edited`), 0600)
+	out.Reset()
+	if err := templates.Execute(&out, LanguageEnglish, "login.html", LoginView{}); err != nil || out.String() != "edited" {
+		t.Errorf("edit not picked up in dev mode: %q, %v", out.String(), err)
+	}
+	os.WriteFile(login, []byte(`{{if}}`), 0600)
+	if err := templates.Execute(&out, LanguageEnglish, "login.html", LoginView{}); err == nil {
+		t.Errorf("broken override rendered in dev mode")
+	}
+	if _, err := NewTemplates(assets, false); err == nil {
+		t.Errorf("broken override parsed")
+	}
+
+	if _, err := assetsFS(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("missing override directory accepted")
+	}
+	if _, err := assetsFS(login); err == nil {
+		t.Errorf("override file accepted as a directory")
+	}
+}
@@ -0,0 +1,118 @@
+package safe
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// decodeCBOR decodes the subset of CBOR (RFC 8949) used by WebAuthn
+// attestation objects and COSE keys: integers, byte and text strings,
+// arrays, maps, booleans and null. Integers are returned as int64, maps as
+// map[any]any. It returns the bytes left after the first item.
+func decodeCBOR(data []byte) (any, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+const cborMaxDepth = 16
+
+var errCBOR = errors.New("invalid or unsupported cbor")
+
+func cborArgument(data []byte) (uint64, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, errCBOR
+	}
+	info := data[0] & 0x1f
+	data = data[1:]
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	}
+	// indefinite lengths are not used by authenticators
+	return 0, nil, errCBOR
+}
+
+func decodeCBORItem(data []byte, depth int) (any, []byte, error) {
+	if len(data) == 0 || depth > cborMaxDepth {
+		return nil, nil, errCBOR
+	}
+	major := data[0] >> 5
+	if major == 7 {
+		switch data[0] {
+		case 0xf4:
+			return false, data[1:], nil
+		case 0xf5:
+			return true, data[1:], nil
+		case 0xf6:
+			return nil, data[1:], nil
+		}
+		return nil, nil, errCBOR
+	}
+	arg, rest, err := cborArgument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return int64(arg), rest, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return -1 - int64(arg), rest, nil
+	case 2, 3:
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBOR
+		}
+		if major == 2 {
+			return rest[:arg], rest[arg:], nil
+		}
+		return string(rest[:arg]), rest[arg:], nil
+	case 4:
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBOR
+		}
+		items := make([]any, 0, arg)
+		for n := uint64(0); n < arg; n++ {
+			var item any
+			if item, rest, err = decodeCBORItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, rest, nil
+	case 5:
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBOR
+		}
+		items := make(map[any]any, arg)
+		for n := uint64(0); n < arg; n++ {
+			var key, value any
+			if key, rest, err = decodeCBORItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errCBOR
+			}
+			if value, rest, err = decodeCBORItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items[key] = value
+		}
+		return items, rest, nil
+	}
+	return nil, nil, errCBOR
+}
@@ -0,0 +1,379 @@
+// Package client is a Go client for apps that integrate with the REST API of
+// a safe. Requests are signed with the app attorney key.
+//
+// Responses are verified against the token of the safe. Production clients
+// should pin it with WithSafeToken: otherwise it is fetched from the
+// well-known endpoint on first use and whoever answers that first request,
+// a proxy or a spoofed host, is trusted from then on.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/safe"
+)
+
+// Error is returned for every error response of the safe. It can be compared
+// with the Err variables through errors.Is.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("safe: %v (%v): %v", e.Code, e.Status, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var (
+	ErrUserNotFound      = &Error{Code: safe.ErrUserNotFound}
+	ErrUserExists        = &Error{Code: safe.ErrUserExists}
+	ErrHandleUnavailable = &Error{Code: safe.ErrHandleUnavailable}
+	ErrInvalidAttorney   = &Error{Code: safe.ErrInvalidAttorney}
+	ErrPendingNotFound   = &Error{Code: safe.ErrPendingNotFound}
+	ErrChallengeNotFound = &Error{Code: safe.ErrChallengeNotFound}
+	ErrUnauthorized      = &Error{Code: safe.ErrUnauthorized}
+	ErrForbidden         = &Error{Code: safe.ErrForbidden}
+)
+
+// ErrInvalidSignature is returned when a response is not signed by the
+// expected safe token.
+var ErrInvalidSignature = errors.New("safe: invalid response signature")
+
+type Client struct {
+	base    string
+	key     crypto.PrivateKey
+	http    *http.Client
+	retries int
+	backoff time.Duration
+	safe    crypto.Token
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces the default http client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// WithRetries sets how many times requests are retried after a network
+// error or a 5xx response, waiting backoff times the attempt number. POST
+// requests carry an idempotency key so that retries are safe.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// WithSafeToken pins the token expected to sign responses. Without it the
+// token published at the well-known endpoint is trusted on first use, which
+// is only suitable for development or for a safe reached over a trusted
+// channel.
+func WithSafeToken(token crypto.Token) Option {
+	return func(c *Client) {
+		c.safe = token
+	}
+}
+
+// New returns a client for the REST API at base (e.g. http://localhost:8090)
+// acting as the app with the given attorney key.
+func New(base string, key crypto.PrivateKey, options ...Option) *Client {
+	c := &Client{
+		base:    strings.TrimSuffix(base, "/"),
+		key:     key,
+		http:    &http.Client{Timeout: 10 * time.Second},
+		retries: 3,
+		backoff: 500 * time.Millisecond,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// SafeToken returns the token that signs the responses of the safe,
+// fetching it from the well-known endpoint if it was not pinned. A fetched
+// token only proves that the response was signed by the key it announces;
+// pin the token with WithSafeToken to authenticate the safe itself.
+func (c *Client) SafeToken(ctx context.Context) (crypto.Token, error) {
+	if !c.safe.Equal(crypto.ZeroToken) {
+		return c.safe, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+safe.WellKnownPath, nil)
+	if err != nil {
+		return crypto.ZeroToken, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return crypto.ZeroToken, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return crypto.ZeroToken, err
+	}
+	var wellKnown safe.WellKnownResponse
+	if err := json.Unmarshal(data, &wellKnown); err != nil {
+		return crypto.ZeroToken, fmt.Errorf("safe: invalid well-known response: %w", err)
+	}
+	token := crypto.TokenFromString(wellKnown.Token)
+	if token.Equal(crypto.ZeroToken) || safe.VerifyResponse(req, resp, data, token) != nil {
+		return crypto.ZeroToken, ErrInvalidSignature
+	}
+	c.safe = token
+	return token, nil
+}
+
+// Token is the attorney token of the app.
+func (c *Client) Token() crypto.Token {
+	return c.key.PublicKey()
+}
+
+// idempotencyKey returns a random key so that POST requests can be retried
+// without repeating their effect.
+func idempotencyKey() string {
+	seed := make([]byte, 16)
+	rand.Read(seed)
+	return hex.EncodeToString(seed)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	key := ""
+	if method == http.MethodPost {
+		key = idempotencyKey()
+	}
+	var err error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * c.backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		var retry bool
+		retry, err = c.once(ctx, method, path, key, body, out)
+		if !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// once performs a single request and tells if it is worth retrying.
+func (c *Client) once(ctx context.Context, method, path, key string, body []byte, out any) (bool, error) {
+	token, err := c.SafeToken(ctx)
+	if err != nil {
+		return true, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set(safe.HeaderIdempotencyKey, key)
+	}
+	safe.SignAppRequest(req, body, c.key)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return true, err
+	}
+	if err := safe.VerifyResponse(req, resp, data, token); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if resp.StatusCode >= 400 {
+		apiErr := &Error{Status: resp.StatusCode, Code: "unknown", Message: http.StatusText(resp.StatusCode)}
+		var response safe.ErrorResponse
+		if json.Unmarshal(data, &response) == nil && response.Error.Code != "" {
+			apiErr.Code = response.Error.Code
+			apiErr.Message = response.Error.Message
+		}
+		// the transport may resend a request as is, which the safe refuses
+		// as a replay: it is retried with a fresh nonce
+		return resp.StatusCode >= 500 || apiErr.Code == safe.ErrRequestReplayed, apiErr
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return false, fmt.Errorf("safe: invalid response: %w", err)
+		}
+	}
+	return false, nil
+}
+
+// CreateUser creates a user and asks it for power of attorney to the app
+// with the given scopes. Like RequestGrant, the user must open the Verify
+// url of the response to consent.
+func (c *Client) CreateUser(ctx context.Context, handle, email, password string, scopes []string) (*safe.UserResponse, error) {
+	req := safe.UserRequest{
+		Handle:        handle,
+		Email:         email,
+		Password:      password,
+		AttorneyToken: c.Token().Hex(),
+		Scopes:        scopes,
+	}
+	var resp safe.UserResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/users", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RequestGrant asks an existing user for power of attorney. The user must
+// open the Verify url of the response to consent. Apps unknown to the safe,
+// neither in its directory nor granted by any user, are not told whether
+// the user exists: requests to unknown users are answered alike.
+func (c *Client) RequestGrant(ctx context.Context, handle, app string, scopes []string) (*safe.PendingResponse, error) {
+	req := safe.PendingRequest{
+		AttorneyToken: c.Token().Hex(),
+		App:           app,
+		Scopes:        scopes,
+	}
+	var resp safe.PendingResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/users/"+url.PathEscape(handle)+"/pending", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Pending returns a pending grant request. It fails with ErrPendingNotFound
+// once the user answered it.
+func (c *Client) Pending(ctx context.Context, id string) (*safe.PendingResponse, error) {
+	var resp safe.PendingResponse
+	if err := c.do(ctx, http.MethodGet, "/v1/pending/"+url.PathEscape(id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CheckAttorney tells if handle granted power of attorney to the app.
+func (c *Client) CheckAttorney(ctx context.Context, handle string) (*safe.AttorneyResponse, error) {
+	var resp safe.AttorneyResponse
+	path := "/v1/users/" + url.PathEscape(handle) + "/attorneys/" + c.Token().Hex()
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// WaitConfirmation polls CheckAttorney every interval until the grant is
+// incorporated from the network or ctx is done.
+func (c *Client) WaitConfirmation(ctx context.Context, handle string, interval time.Duration) (*safe.AttorneyResponse, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		resp, err := c.CheckAttorney(ctx, handle)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Granted {
+			return resp, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Challenge asks handle to prove control of the handle for nonce.
+func (c *Client) Challenge(ctx context.Context, handle, nonce string) (*safe.ChallengeResponse, error) {
+	var resp safe.ChallengeResponse
+	req := safe.ChallengeRequest{Handle: handle, Nonce: nonce}
+	if err := c.do(ctx, http.MethodPost, "/v1/challenges", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ChallengeStatement returns the status of a challenge and, once approved,
+// verifies the statement against the user token.
+func (c *Client) ChallengeStatement(ctx context.Context, id string, user crypto.Token) (*safe.ChallengeResponse, error) {
+	var resp safe.ChallengeResponse
+	if err := c.do(ctx, http.MethodGet, "/v1/challenges/"+url.PathEscape(id), nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Statement != nil {
+		statement, err := resp.Statement.Statement()
+		if err != nil {
+			return nil, err
+		}
+		if err := safe.VerifyOwnership(statement, user, c.Token(), resp.Nonce); err != nil {
+			return nil, err
+		}
+	}
+	return &resp, nil
+}
+
+// Users lists the users that granted power of attorney to the app with
+// grant epoch in [from, to] (to zero for no bound).
+func (c *Client) Users(ctx context.Context, from, to uint64, offset, limit int) (*safe.GrantorListResponse, error) {
+	query := url.Values{}
+	query.Set("offset", strconv.Itoa(offset))
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("from_epoch", strconv.FormatUint(from, 10))
+	query.Set("to_epoch", strconv.FormatUint(to, 10))
+	var resp safe.GrantorListResponse
+	path := "/v1/attorneys/" + c.Token().Hex() + "/users?" + query.Encode()
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RegisterWebhook registers target to receive the events involving the app.
+func (c *Client) RegisterWebhook(ctx context.Context, target string) (*safe.WebhookResponse, error) {
+	var resp safe.WebhookResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/webhooks", safe.WebhookRequest{URL: target}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) Webhooks(ctx context.Context) ([]safe.WebhookResponse, error) {
+	var resp safe.WebhookListResponse
+	if err := c.do(ctx, http.MethodGet, "/v1/webhooks", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Webhooks, nil
+}
+
+func (c *Client) RemoveWebhook(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/webhooks/"+url.PathEscape(id), nil, nil)
+}
@@ -0,0 +1,266 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/safe"
+)
+
+// gateway collects the actions the safe sends to the network.
+type gateway struct {
+	mu      sync.Mutex
+	actions [][]byte
+}
+
+func (g *gateway) Send(data []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.actions = append(g.actions, data)
+	return nil
+}
+
+// testSafe runs the REST API of a local safe in process and returns its url
+// and the token that signs its responses.
+func testSafe(t *testing.T, wrap func(http.Handler) http.Handler) (string, crypto.Token) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	token, credentials := crypto.RandomAsymetricKey()
+	config := safe.SafeConfig{
+		Credentials: credentials,
+		Path:        t.TempDir(),
+		Address:     "localhost",
+	}
+	finalize, s := safe.NewLocalServer(ctx, config, "test password", &gateway{}, make(chan []byte))
+	if s == nil {
+		t.Fatalf("could not start safe: %v", <-finalize)
+	}
+	rest := safe.RestAPI{Safe: s}
+	handler := rest.Handler()
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server.URL, token
+}
+
+func testClient(t *testing.T, base string, options ...Option) *Client {
+	t.Helper()
+	_, key := crypto.RandomAsymetricKey()
+	options = append([]Option{WithRetries(2, time.Millisecond)}, options...)
+	return New(base, key, options...)
+}
+
+func TestSafeToken(t *testing.T) {
+	base, token := testSafe(t, nil)
+	c := testClient(t, base)
+	got, err := c.SafeToken(context.Background())
+	if err != nil {
+		t.Fatalf("SafeToken: %v", err)
+	}
+	if !got.Equal(token) {
+		t.Errorf("SafeToken = %v, want %v", got, token)
+	}
+}
+
+func TestPinnedSafeToken(t *testing.T) {
+	base, token := testSafe(t, nil)
+	ctx := context.Background()
+	if _, err := testClient(t, base, WithSafeToken(token)).Webhooks(ctx); err != nil {
+		t.Errorf("pinned safe token rejected: %v", err)
+	}
+	other, _ := crypto.RandomAsymetricKey()
+	if _, err := testClient(t, base, WithSafeToken(other)).Webhooks(ctx); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("response of another safe accepted: %v", err)
+	}
+}
+
+func TestTamperedResponse(t *testing.T) {
+	tamper := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == safe.WellKnownPath {
+				next.ServeHTTP(w, r)
+				return
+			}
+			recorder := httptest.NewRecorder()
+			next.ServeHTTP(recorder, r)
+			for key, values := range recorder.Header() {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(recorder.Code)
+			w.Write(append(recorder.Body.Bytes(), ' '))
+		})
+	}
+	base, _ := testSafe(t, tamper)
+	if _, err := testClient(t, base).Webhooks(context.Background()); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered response accepted: %v", err)
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	base, _ := testSafe(t, nil)
+	c := testClient(t, base)
+	ctx := context.Background()
+	user, err := c.CreateUser(ctx, "alice", "alice@example.com", "correct horse battery", []string{safe.ScopeProfile})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if user.Handle != "alice" || user.Pending == "" || user.Verify == "" {
+		t.Errorf("CreateUser = %+v", user)
+	}
+	// the requested scopes wait for the user consent
+	pending, err := c.Pending(ctx, user.Pending)
+	if err != nil {
+		t.Fatalf("Pending: %v", err)
+	}
+	if pending.Status != "pending" || len(pending.Scopes) != 1 || pending.Scopes[0] != safe.ScopeProfile {
+		t.Errorf("Pending = %+v", pending)
+	}
+	attorney, err := c.CheckAttorney(ctx, "alice")
+	if err != nil {
+		t.Fatalf("CheckAttorney: %v", err)
+	}
+	if attorney.Granted || attorney.Token != "" {
+		t.Errorf("CheckAttorney before consent = %+v", attorney)
+	}
+	// apps unknown to the safe are not told that the user exists
+	if _, err := c.CreateUser(ctx, "alice", "alice@example.com", "correct horse battery", nil); !errors.Is(err, ErrHandleUnavailable) {
+		t.Errorf("second CreateUser = %v, want ErrHandleUnavailable", err)
+	}
+}
+
+func TestCheckAttorney(t *testing.T) {
+	base, _ := testSafe(t, nil)
+	c := testClient(t, base)
+	ctx := context.Background()
+	if _, err := c.CheckAttorney(ctx, "nobody"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("CheckAttorney of unknown user = %v, want ErrUserNotFound", err)
+	}
+	if _, err := c.CreateUser(ctx, "alice", "alice@example.com", "correct horse battery", nil); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	// the grant waits for the user consent
+	attorney, err := c.CheckAttorney(ctx, "alice")
+	if err != nil {
+		t.Fatalf("CheckAttorney: %v", err)
+	}
+	if attorney.Granted {
+		t.Error("grant reported before it was incorporated")
+	}
+}
+
+func TestRequestGrant(t *testing.T) {
+	base, _ := testSafe(t, nil)
+	owner := testClient(t, base)
+	ctx := context.Background()
+	if _, err := owner.CreateUser(ctx, "alice", "alice@example.com", "correct horse battery", nil); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	c := testClient(t, base)
+	pending, err := c.RequestGrant(ctx, "alice", "test app", []string{"email"})
+	if err != nil {
+		t.Fatalf("RequestGrant: %v", err)
+	}
+	if pending.ID == "" || pending.Verify == "" {
+		t.Errorf("RequestGrant = %+v", pending)
+	}
+	if _, err := c.Pending(ctx, pending.ID); err != nil {
+		t.Errorf("Pending: %v", err)
+	}
+	if _, err := c.Pending(ctx, "unknown"); !errors.Is(err, ErrPendingNotFound) {
+		t.Errorf("Pending of unknown id = %v, want ErrPendingNotFound", err)
+	}
+	// apps unknown to the safe are answered alike for unknown users
+	decoy, err := c.RequestGrant(ctx, "nobody", "test app", []string{"email"})
+	if err != nil {
+		t.Fatalf("RequestGrant to unknown user: %v", err)
+	}
+	if _, err := c.Pending(ctx, decoy.ID); err != nil {
+		t.Errorf("Pending of a request to an unknown user: %v", err)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	base, _ := testSafe(t, nil)
+	c := testClient(t, base)
+	ctx := context.Background()
+	if _, err := c.RegisterWebhook(ctx, "http://127.0.0.1/hook"); err == nil {
+		t.Error("webhook to loopback address registered")
+	}
+	hook, err := c.RegisterWebhook(ctx, "https://93.184.215.14/hook")
+	if err != nil {
+		t.Fatalf("RegisterWebhook: %v", err)
+	}
+	hooks, err := c.Webhooks(ctx)
+	if err != nil {
+		t.Fatalf("Webhooks: %v", err)
+	}
+	if len(hooks) != 1 || hooks[0].ID != hook.ID {
+		t.Errorf("Webhooks = %+v, want %v", hooks, hook.ID)
+	}
+	if err := c.RemoveWebhook(ctx, hook.ID); err != nil {
+		t.Fatalf("RemoveWebhook: %v", err)
+	}
+	if hooks, _ := c.Webhooks(ctx); len(hooks) != 0 {
+		t.Errorf("Webhooks after removal = %+v", hooks)
+	}
+	// webhooks of other apps are not visible
+	if err := testClient(t, base).RemoveWebhook(ctx, hook.ID); err == nil {
+		t.Error("webhook removed by another app")
+	}
+}
+
+func TestRetryIsIdempotent(t *testing.T) {
+	var mu sync.Mutex
+	keys := make([]string, 0)
+	drop := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+			mu.Lock()
+			keys = append(keys, r.Header.Get(safe.HeaderIdempotencyKey))
+			first := len(keys) == 1
+			mu.Unlock()
+			if first {
+				// the answer is lost after the safe handled the request
+				next.ServeHTTP(httptest.NewRecorder(), r)
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					conn.Close()
+				}
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	base, _ := testSafe(t, drop)
+	c := testClient(t, base)
+	user, err := c.CreateUser(context.Background(), "alice", "alice@example.com", "correct horse battery", nil)
+	if err != nil {
+		t.Fatalf("CreateUser after a lost response: %v", err)
+	}
+	if user.Handle != "alice" {
+		t.Errorf("CreateUser = %+v", user)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// the transport may resend the first attempt before the client retries
+	if len(keys) < 2 || keys[0] == "" {
+		t.Fatalf("idempotency keys = %v, want a retry with the same key", keys)
+	}
+	for _, key := range keys[1:] {
+		if key != keys[0] {
+			t.Errorf("idempotency keys = %v, want the same key", keys)
+		}
+	}
+}
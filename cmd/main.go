@@ -30,6 +30,22 @@ type Config struct {
 	ServerName      string                // json:"serverName"
 	SimpleProvider  *SimpleProviderConfig // json:"simpleProvider"
 	Address         string                // json:"adress"
+	Issuer          string                // json:"issuer"
+	OIDCClients     []safe.OIDCClient     // json:"oidcClients"
+	Admins          []string              // json:"admins"
+	DirectoryPath   string                // json:"directoryPath"
+	SMTP            *SMTPConfig           // json:"smtp"
+	HandlePolicy    *safe.HandlePolicy    // json:"handlePolicy"
+	HtmlPath        string                // json:"htmlPath"
+	DevMode         bool                  // json:"devMode"
+}
+
+// SMTPConfig is the server used to mail account notifications.
+type SMTPConfig struct {
+	Address  string // json:"address"
+	From     string // json:"from"
+	Username string // json:"username"
+	Password string // json:"password"
 }
 
 func (c Config) Check() error {
@@ -64,14 +80,29 @@ func ConfigToGatewayonfig(c Config, pk crypto.PrivateKey) safe.GatewayConfig {
 }
 
 func ConfigToSafeConfig(c Config, pk crypto.PrivateKey) safe.SafeConfig {
+	admins := make([]crypto.Token, 0, len(c.Admins))
+	for _, admin := range c.Admins {
+		admins = append(admins, crypto.TokenFromString(admin))
+	}
+	var mailer safe.Mailer
+	if c.SMTP != nil {
+		mailer = safe.SMTPMailer{Addr: c.SMTP.Address, From: c.SMTP.From, Username: c.SMTP.Username, Password: c.SMTP.Password}
+	}
 	return safe.SafeConfig{
-		Credentials: pk,
-		Port:        c.Port,
-		Path:        c.DataPath,
-		HtmlPath:    "../",
-		ServerName:  c.ServerName,
-		RestAPIPort: c.RestAPIPort,
-		Address:     c.Address,
+		Credentials:   pk,
+		Port:          c.Port,
+		Path:          c.DataPath,
+		HtmlPath:      c.HtmlPath,
+		DevMode:       c.DevMode,
+		ServerName:    c.ServerName,
+		RestAPIPort:   c.RestAPIPort,
+		Address:       c.Address,
+		Issuer:        c.Issuer,
+		OIDCClients:   c.OIDCClients,
+		Admins:        admins,
+		DirectoryPath: c.DirectoryPath,
+		Mailer:        mailer,
+		HandlePolicy:  c.HandlePolicy,
 	}
 }
 
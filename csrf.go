@@ -0,0 +1,32 @@
+package safe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// csrfField is the hidden form field carrying the CSRF token.
+const csrfField = "csrf"
+
+// csrfToken returns the CSRF token of the session of r, empty without a
+// session. It is bound to the session cookie and keyed by the secret of the
+// safe, so other sites can neither read nor forge it.
+func (s *Safe) csrfToken(r *http.Request) string {
+	cookie, err := r.Cookie(cookieName)
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, s.credentials[:])
+	mac.Write([]byte("csrf:" + cookie.Value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkCSRF tells whether the form posted in r carries the CSRF token of its
+// session. Forms that approve something on behalf of the user must check it
+// since the app asking for the approval knows the url of the form.
+func (s *Safe) checkCSRF(r *http.Request) bool {
+	expected := s.csrfToken(r)
+	return expected != "" && hmac.Equal([]byte(expected), []byte(r.FormValue(csrfField)))
+}
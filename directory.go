@@ -0,0 +1,233 @@
+package safe
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// AppInfo is what the directory knows about an attorney token. Tokens not
+// in the directory are reported with Verified false and no name.
+type AppInfo struct {
+	Token       string `json:"token"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Homepage    string `json:"homepage,omitempty"`
+	Icon        string `json:"icon,omitempty"`
+	Verified    bool   `json:"verified"`
+}
+
+// DirectoryFile is the format of the signed directory file. Signature is
+// the hex signature by Signer of Entries in compact JSON, so the file can be
+// indented. Signer must be one of the admins of the safe.
+type DirectoryFile struct {
+	Signer    string          `json:"signer"`
+	Signature string          `json:"signature"`
+	Entries   json.RawMessage `json:"entries"`
+}
+
+// AttorneyDirectory names known attorney tokens. Entries come from a
+// signed file loaded on start and from admins; admin entries take
+// precedence and are persisted in the vault.
+type AttorneyDirectory struct {
+	mu    sync.RWMutex
+	vault *Vault
+	file  map[crypto.Token]AppInfo
+	admin map[crypto.Token]AppInfo
+}
+
+func NewAttorneyDirectory(vault *Vault) *AttorneyDirectory {
+	directory := &AttorneyDirectory{
+		vault: vault,
+		file:  make(map[crypto.Token]AppInfo),
+		admin: make(map[crypto.Token]AppInfo),
+	}
+	for _, entry := range vault.DirectoryEntries() {
+		directory.admin[entry.Token] = entryToInfo(entry)
+	}
+	return directory
+}
+
+func entryToInfo(entry DirectoryEntry) AppInfo {
+	return AppInfo{
+		Token:       entry.Token.Hex(),
+		Name:        entry.Name,
+		Description: entry.Description,
+		Homepage:    entry.Homepage,
+		Icon:        entry.Icon,
+		Verified:    true,
+	}
+}
+
+func validateAppInfo(info AppInfo) (crypto.Token, error) {
+	token, ok := attorneyToken(info.Token)
+	if !ok {
+		return token, errors.New("invalid token")
+	}
+	if info.Name == "" {
+		return token, errors.New("name is required")
+	}
+	for _, link := range []string{info.Homepage, info.Icon} {
+		if link == "" {
+			continue
+		}
+		if parsed, err := url.Parse(link); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+			return token, fmt.Errorf("invalid url %v", link)
+		}
+	}
+	return token, nil
+}
+
+// LoadFile reads the signed directory file at path, replacing the entries
+// previously loaded from file.
+func (d *AttorneyDirectory) LoadFile(path string, admins []crypto.Token) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file DirectoryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	signer := crypto.TokenFromString(file.Signer)
+	trusted := false
+	for _, admin := range admins {
+		if admin.Equal(signer) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return errors.New("directory file not signed by an admin")
+	}
+	signatureBytes, _ := hex.DecodeString(file.Signature)
+	var signature crypto.Signature
+	if len(signatureBytes) != len(signature) {
+		return errors.New("invalid directory file signature")
+	}
+	copy(signature[:], signatureBytes)
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, file.Entries); err != nil {
+		return err
+	}
+	if !signer.Verify(compact.Bytes(), signature) {
+		return errors.New("invalid directory file signature")
+	}
+	var entries []AppInfo
+	if err := json.Unmarshal(file.Entries, &entries); err != nil {
+		return err
+	}
+	loaded := make(map[crypto.Token]AppInfo)
+	for _, info := range entries {
+		token, err := validateAppInfo(info)
+		if err != nil {
+			return fmt.Errorf("invalid directory entry %v: %v", info.Token, err)
+		}
+		info.Token = token.Hex()
+		info.Verified = true
+		loaded[token] = info
+	}
+	d.mu.Lock()
+	d.file = loaded
+	d.mu.Unlock()
+	return nil
+}
+
+// SignDirectory signs entries with the secret of an admin, producing the
+// contents of a directory file.
+func SignDirectory(entries []AppInfo, secret crypto.PrivateKey) ([]byte, error) {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+	signature := secret.Sign(data)
+	file := DirectoryFile{
+		Signer:    secret.PublicKey().Hex(),
+		Signature: hex.EncodeToString(signature[:]),
+		Entries:   data,
+	}
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// Lookup returns the directory information about token.
+func (d *AttorneyDirectory) Lookup(token crypto.Token) AppInfo {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if info, ok := d.admin[token]; ok {
+		return info
+	}
+	if info, ok := d.file[token]; ok {
+		return info
+	}
+	return AppInfo{Token: token.Hex()}
+}
+
+// Set adds or replaces an admin entry.
+func (d *AttorneyDirectory) Set(info AppInfo) (AppInfo, error) {
+	token, err := validateAppInfo(info)
+	if err != nil {
+		return info, err
+	}
+	entry := DirectoryEntry{
+		Token:       token,
+		Name:        info.Name,
+		Description: info.Description,
+		Homepage:    info.Homepage,
+		Icon:        info.Icon,
+		Active:      true,
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.vault.SaveDirectoryEntry(entry); err != nil {
+		return info, err
+	}
+	d.admin[token] = entryToInfo(entry)
+	return d.admin[token], nil
+}
+
+// Remove deletes an admin entry. Entries loaded from file are kept. It
+// returns false if there is no admin entry for token.
+func (d *AttorneyDirectory) Remove(token crypto.Token) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.admin[token]; !ok {
+		return false, nil
+	}
+	if err := d.vault.SaveDirectoryEntry(DirectoryEntry{Token: token}); err != nil {
+		return true, err
+	}
+	delete(d.admin, token)
+	return true, nil
+}
+
+// Entries lists every known attorney, sorted by name.
+func (d *AttorneyDirectory) Entries() []AppInfo {
+	d.mu.RLock()
+	merged := make(map[crypto.Token]AppInfo)
+	for token, info := range d.file {
+		merged[token] = info
+	}
+	for token, info := range d.admin {
+		merged[token] = info
+	}
+	d.mu.RUnlock()
+	entries := make([]AppInfo, 0, len(merged))
+	for _, info := range merged {
+		entries = append(entries, info)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Token < entries[j].Token
+	})
+	return entries
+}
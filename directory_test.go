@@ -0,0 +1,95 @@
+package safe
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// writeDirectory writes data to a directory file in a temporary directory.
+func writeDirectory(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "directory.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("could not write directory file: %v", err)
+	}
+	return path
+}
+
+func TestLoadDirectoryFile(t *testing.T) {
+	admin, secret := crypto.RandomAsymetricKey()
+	app, _ := crypto.RandomAsymetricKey()
+	data, err := SignDirectory([]AppInfo{{Token: app.Hex(), Name: "App", Homepage: "https://app.example.com"}}, secret)
+	if err != nil {
+		t.Fatalf("SignDirectory: %v", err)
+	}
+	directory := NewAttorneyDirectory(testVault(t))
+	if err := directory.LoadFile(writeDirectory(t, data), []crypto.Token{admin}); err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if info := directory.Lookup(app); !info.Verified || info.Name != "App" {
+		t.Errorf("Lookup = %+v", info)
+	}
+}
+
+func TestLoadDirectoryFileUntrustedSigner(t *testing.T) {
+	admin, _ := crypto.RandomAsymetricKey()
+	_, secret := crypto.RandomAsymetricKey()
+	app, _ := crypto.RandomAsymetricKey()
+	data, _ := SignDirectory([]AppInfo{{Token: app.Hex(), Name: "App"}}, secret)
+	directory := NewAttorneyDirectory(testVault(t))
+	if err := directory.LoadFile(writeDirectory(t, data), []crypto.Token{admin}); err == nil {
+		t.Errorf("directory signed by another key loaded")
+	}
+	if directory.Lookup(app).Verified {
+		t.Errorf("entry of an untrusted directory verified")
+	}
+}
+
+func TestLoadDirectoryFileBadSignature(t *testing.T) {
+	admin, secret := crypto.RandomAsymetricKey()
+	app, _ := crypto.RandomAsymetricKey()
+	data, _ := SignDirectory([]AppInfo{{Token: app.Hex(), Name: "App"}}, secret)
+	var file DirectoryFile
+	json.Unmarshal(data, &file)
+	tampered, _ := json.Marshal([]AppInfo{{Token: app.Hex(), Name: "Bank"}})
+	for _, changed := range []DirectoryFile{
+		{Signer: file.Signer, Signature: file.Signature, Entries: tampered},
+		{Signer: file.Signer, Signature: hex.EncodeToString([]byte("short")), Entries: file.Entries},
+		{Signer: file.Signer, Signature: "not hex", Entries: file.Entries},
+	} {
+		data, _ := json.Marshal(changed)
+		directory := NewAttorneyDirectory(testVault(t))
+		if err := directory.LoadFile(writeDirectory(t, data), []crypto.Token{admin}); err == nil {
+			t.Errorf("directory with signature %q loaded", changed.Signature)
+		}
+		if directory.Lookup(app).Verified {
+			t.Errorf("entry with a bad signature verified")
+		}
+	}
+}
+
+func TestLoadDirectoryFileInvalidEntry(t *testing.T) {
+	admin, secret := crypto.RandomAsymetricKey()
+	app, _ := crypto.RandomAsymetricKey()
+	for _, entry := range []AppInfo{
+		{Token: "not a token", Name: "App"},
+		{Token: app.Hex()},
+		{Token: app.Hex(), Name: "App", Homepage: "javascript:alert(1)"},
+		{Token: app.Hex(), Name: "App", Icon: "data:image/png;base64,AAAA"},
+	} {
+		valid := AppInfo{Token: app.Hex(), Name: "App"}
+		data, _ := SignDirectory([]AppInfo{valid, entry}, secret)
+		directory := NewAttorneyDirectory(testVault(t))
+		if err := directory.LoadFile(writeDirectory(t, data), []crypto.Token{admin}); err == nil {
+			t.Errorf("directory with entry %+v loaded", entry)
+		}
+		if directory.Lookup(app).Verified {
+			t.Errorf("valid entries of a directory with an invalid one loaded")
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package safe
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// Account events streamed to the user and to authenticated apps.
+const (
+	EventJoinConfirmed   = "join_confirmed"
+	EventGrantSent       = "grant_sent"
+	EventGrantConfirmed  = "grant_confirmed"
+	EventRevokeSent      = "revoke_sent"
+	EventRevokeConfirmed = "revoke_confirmed"
+	EventNewSession      = "new_session"
+	EventAccountLocked   = "account_locked"
+)
+
+const (
+	eventBuffer    = 16
+	eventKeepAlive = 15 * time.Second
+)
+
+type AccountEvent struct {
+	Type     string    `json:"type"`
+	Handle   string    `json:"handle"`
+	Attorney string    `json:"attorney,omitempty"`
+	Epoch    uint64    `json:"epoch,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// EventHub fans out account events to the subscribers of each handle. Slow
+// subscribers lose events instead of blocking ingestion.
+type EventHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan AccountEvent]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[string]map[chan AccountEvent]struct{})}
+}
+
+func (h *EventHub) Subscribe(handle string) (chan AccountEvent, func()) {
+	events := make(chan AccountEvent, eventBuffer)
+	h.mu.Lock()
+	if _, ok := h.subscribers[handle]; !ok {
+		h.subscribers[handle] = make(map[chan AccountEvent]struct{})
+	}
+	h.subscribers[handle][events] = struct{}{}
+	h.mu.Unlock()
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subscribers[handle], events)
+		if len(h.subscribers[handle]) == 0 {
+			delete(h.subscribers, handle)
+		}
+	}
+	return events, cancel
+}
+
+func (h *EventHub) Publish(event AccountEvent) {
+	event.Time = time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for events := range h.subscribers[event.Handle] {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// streamEvents writes the events of handle accepted by allow as server-sent
+// events until the client goes away or authorized turns false. authorized is
+// checked after every event and keep-alive. nil allows everything.
+func (s *Safe) streamEvents(w http.ResponseWriter, r *http.Request, handle string, allow func(AccountEvent) bool, authorized func() bool) {
+	controller := http.NewResponseController(w)
+	// streams outlive the write timeout of the servers
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("could not clear write deadline for event stream: %v", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	controller.Flush()
+	events, cancel := s.events.Subscribe(handle)
+	defer cancel()
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event := <-events:
+			if allow != nil && !allow(event) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %v\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+		if authorized != nil && !authorized() {
+			return
+		}
+	}
+}
+
+func (s *Safe) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	handle := s.Handle(r)
+	if handle == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	// the stream ends with the session: signout, a password change or a
+	// freeze all end it
+	s.streamEvents(w, r, handle, nil, func() bool { return s.Handle(r) == handle })
+}
+
+// appEvents returns the filter of the events streamed to app on behalf of
+// handle and the check that keeps the stream open. Apps only see their own
+// grants and revokes, and lose the stream with the power of attorney or when
+// the account is frozen.
+func (s *Safe) appEvents(handle string, app crypto.Token) (func(AccountEvent) bool, func() bool) {
+	attorney := app.Hex()
+	revoked := false
+	allow := func(event AccountEvent) bool {
+		if event.Attorney != attorney {
+			return false
+		}
+		if event.Type == EventRevokeSent || event.Type == EventRevokeConfirmed {
+			revoked = true
+		}
+		return true
+	}
+	authorized := func() bool {
+		return !revoked && !s.Frozen(handle) && s.hasAttorney(handle, app)
+	}
+	return allow, authorized
+}
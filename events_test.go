@@ -0,0 +1,112 @@
+package safe
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func TestEventHub(t *testing.T) {
+	hub := NewEventHub()
+	alice, cancel := hub.Subscribe("alice")
+	defer cancel()
+	bob, cancelBob := hub.Subscribe("bob")
+	// slow subscribers lose events instead of blocking
+	for n := 0; n <= eventBuffer; n++ {
+		hub.Publish(AccountEvent{Type: EventNewSession, Handle: "alice"})
+	}
+	if len(alice) != eventBuffer {
+		t.Errorf("%v events buffered, want %v", len(alice), eventBuffer)
+	}
+	if len(bob) != 0 {
+		t.Errorf("events of alice sent to bob")
+	}
+	cancelBob()
+	hub.mu.Lock()
+	_, ok := hub.subscribers["bob"]
+	hub.mu.Unlock()
+	if ok {
+		t.Errorf("subscribers of bob kept after cancel")
+	}
+}
+
+func TestAppEvents(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	testUser(t, s, "alice")
+	app, _ := crypto.RandomAsymetricKey()
+	other, _ := crypto.RandomAsymetricKey()
+	allow, authorized := s.appEvents("alice", app)
+	if allow(AccountEvent{Type: EventGrantSent, Attorney: other.Hex()}) {
+		t.Errorf("grant to another app streamed")
+	}
+	if !allow(AccountEvent{Type: EventGrantSent, Attorney: app.Hex()}) {
+		t.Errorf("grant to the app not streamed")
+	}
+	allow(AccountEvent{Type: EventRevokeSent, Attorney: app.Hex()})
+	if authorized() {
+		t.Errorf("stream kept after the revoke of the app")
+	}
+}
+
+// waitSubscriber waits until handle has a subscriber.
+func waitSubscriber(t *testing.T, hub *EventHub, handle string) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		hub.mu.Lock()
+		subscribed := len(hub.subscribers[handle]) > 0
+		hub.mu.Unlock()
+		if subscribed {
+			return
+		}
+	}
+	t.Fatalf("no subscriber for %v", handle)
+}
+
+func TestEventsEndWithSession(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	session := testUser(t, s, "alice")
+	server := httptest.NewServer(http.HandlerFunc(s.EventsHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET events: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("events without session = %v", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.AddCookie(&http.Cookie{Name: cookieName, Value: session})
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("GET events: %v", err)
+	}
+	defer resp.Body.Close()
+	waitSubscriber(t, s.events, "alice")
+	s.events.Publish(AccountEvent{Type: EventNewSession, Handle: "alice"})
+	reader := bufio.NewReader(resp.Body)
+	if line, _ := reader.ReadString('\n'); line != "event: "+EventNewSession+"\n" {
+		t.Fatalf("first line %q, want the new session event", line)
+	}
+
+	if err := s.EndSessions("alice", ""); err != nil {
+		t.Fatalf("EndSessions: %v", err)
+	}
+	s.events.Publish(AccountEvent{Type: EventNewSession, Handle: "alice"})
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("stream not closed after the session ended: %v", err)
+	}
+	if strings.Count(string(rest), "event: ") > 1 {
+		t.Errorf("events streamed after the session ended: %q", rest)
+	}
+}
@@ -0,0 +1,153 @@
+package safe
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+var (
+	ErrExpiryPast   = errors.New("expiry must be in the future")
+	ErrExpiryEpochs = errors.New("invalid number of epochs")
+	ErrExpiryDate   = errors.New("invalid expiry date")
+)
+
+// Expiry bounds a power of attorney by epoch, by wall time or both. The
+// power is revoked as soon as any of the bounds is passed. Zero values mean
+// no bound.
+type Expiry struct {
+	Epoch uint64
+	Time  time.Time
+}
+
+func (e Expiry) IsZero() bool {
+	return e.Epoch == 0 && e.Time.IsZero()
+}
+
+func (e Expiry) Expired(epoch uint64, now time.Time) bool {
+	return (e.Epoch != 0 && epoch >= e.Epoch) || (!e.Time.IsZero() && !now.Before(e.Time))
+}
+
+func (e Expiry) String() string {
+	return e.Describe(DefaultLanguage)
+}
+
+// Describe tells when the power of attorney expires in language.
+func (e Expiry) Describe(language string) string {
+	switch {
+	case e.IsZero():
+		return ""
+	case e.Epoch == 0:
+		return Translate(language, "expiry.time", e.Time.Format("2006-01-02 15:04"))
+	case e.Time.IsZero():
+		return Translate(language, "expiry.epoch", e.Epoch)
+	default:
+		return Translate(language, "expiry.both", e.Epoch, e.Time.Format("2006-01-02 15:04"))
+	}
+}
+
+// NewExpiry builds an expiry from a number of epochs after the current
+// epoch and a wall time, both optional.
+func (s *Safe) NewExpiry(epochs uint64, at time.Time) (Expiry, error) {
+	expiry := Expiry{Time: at}
+	if epochs > 0 {
+		expiry.Epoch = s.Epoch() + epochs
+	}
+	if !at.IsZero() && !at.After(time.Now()) {
+		return expiry, ErrExpiryPast
+	}
+	return expiry, nil
+}
+
+// parseExpiryForm reads the expiry fields of the grant forms: expires_in
+// (epochs) and expires_at (local date and time).
+func (s *Safe) parseExpiryForm(r *http.Request) (Expiry, error) {
+	var epochs uint64
+	if value := r.FormValue("expires_in"); value != "" {
+		var err error
+		if epochs, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return Expiry{}, ErrExpiryEpochs
+		}
+	}
+	var at time.Time
+	if value := r.FormValue("expires_at"); value != "" {
+		var err error
+		if at, err = time.ParseInLocation("2006-01-02T15:04", value, time.Local); err != nil {
+			return Expiry{}, ErrExpiryDate
+		}
+	}
+	return s.NewExpiry(epochs, at)
+}
+
+// SetExpiry records when the power of attorney from handle to attorney must
+// be revoked. A zero expiry removes the bound.
+func (s *Safe) SetExpiry(handle string, attorney crypto.Token, expiry Expiry) error {
+	if err := s.vault.SetExpiry(handle, attorney, expiry); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[handle]; ok {
+		if expiry.IsZero() {
+			delete(user.Expiry, attorney)
+		} else {
+			user.Expiry[attorney] = expiry
+		}
+	}
+	return nil
+}
+
+// GrantPowerUntil is like GrantPower but the power is revoked once expiry
+// is passed.
+func (s *Safe) GrantPowerUntil(handle, grantee, fingerprint string, scopes []string, expiry Expiry, origin GrantOrigin) error {
+	if s.Frozen(handle) {
+		return ErrAccountFrozen
+	}
+	token, ok := attorneyToken(grantee)
+	if !ok {
+		return ErrAttorneyToken
+	}
+	if err := s.SetExpiry(handle, token, expiry); err != nil {
+		return err
+	}
+	return s.GrantPower(handle, grantee, fingerprint, scopes, origin)
+}
+
+// expiryInterval is how often powers are expired by wall time when there
+// are no blocks to drive ExpirePowers.
+const expiryInterval = time.Minute
+
+// ExpirePowers signs and sends the revocation of every power of attorney
+// past its expiry. It is called on every new block and every expiryInterval
+// by local servers. The expiry is only cleared once the revocation is sent,
+// so that failed revocations are tried again.
+func (s *Safe) ExpirePowers() {
+	type power struct {
+		handle   string
+		attorney crypto.Token
+	}
+	now, epoch := time.Now(), s.Epoch()
+	expired := make([]power, 0)
+	s.mu.RLock()
+	for handle, user := range s.users {
+		for attorney, expiry := range user.Expiry {
+			if expiry.Expired(epoch, now) && user.IsAttorney(attorney) {
+				expired = append(expired, power{handle: handle, attorney: attorney})
+			}
+		}
+	}
+	s.mu.RUnlock()
+	for _, power := range expired {
+		if err := s.RevokePower(power.handle, power.attorney.Hex()); err != nil {
+			log.Printf("could not revoke expired power of attorney: %v", err)
+			continue
+		}
+		if err := s.SetExpiry(power.handle, power.attorney, Expiry{}); err != nil {
+			log.Printf("could not clear expiry: %v", err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package safe
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// TestExpirePowersConcurrently runs the expiry of powers, driven by blocks
+// and a ticker, while HTTP handlers open sessions and bound new grants. Run
+// with -race.
+func TestExpirePowersConcurrently(t *testing.T) {
+	gateway := &testGateway{}
+	s := testSafe(t, gateway)
+	testUser(t, s, "alice")
+	attorney, _ := crypto.RandomAsymetricKey()
+	action, err := s.GrantAction("alice", attorney.Hex())
+	if err != nil {
+		t.Fatalf("GrantAction: %v", err)
+	}
+	s.IncorporateGrant(action)
+	if err := s.SetExpiry("alice", attorney, Expiry{Epoch: 5}); err != nil {
+		t.Fatalf("SetExpiry: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for epoch := uint64(2); epoch <= 10; epoch++ {
+			s.epoch.Store(epoch)
+			s.ExpirePowers()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 10; n++ {
+			if session := s.CreateSession("alice"); s.Handle(sessionRequest(session)) != "alice" {
+				t.Error("session not valid")
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 10; n++ {
+			if _, err := s.NewExpiry(3, time.Time{}); err != nil {
+				t.Errorf("NewExpiry: %v", err)
+			}
+			s.UserHandleView("alice", DefaultLanguage)
+		}
+	}()
+	wg.Wait()
+
+	s.ExpirePowers()
+	if expiry := s.vault.HandleExpiry("alice"); len(expiry) != 0 {
+		t.Errorf("expiry kept after the revoke was sent: %v", expiry)
+	}
+}
@@ -0,0 +1,129 @@
+package safe
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	flashCookieName = "safeFlashCookie"
+	flashLifetime   = 10 * time.Minute
+)
+
+// Flash carries the outcome of a form across the redirect that follows it.
+// Fields holds the errors of each form field by name and Values the
+// submitted values to refill the form with. Passwords and codes are never
+// kept in Values.
+type Flash struct {
+	Error  string
+	Notice string
+	Fields map[string]string
+	Values map[string]string
+}
+
+// Invalid records the error of a form field.
+func (f *Flash) Invalid(field, message string) {
+	if f.Fields == nil {
+		f.Fields = make(map[string]string)
+	}
+	f.Fields[field] = message
+}
+
+// Keep records a submitted value to refill the form with.
+func (f *Flash) Keep(field, value string) {
+	if f.Values == nil {
+		f.Values = make(map[string]string)
+	}
+	f.Values[field] = value
+}
+
+// Failed tells whether any error was recorded.
+func (f Flash) Failed() bool {
+	return f.Error != "" || len(f.Fields) > 0
+}
+
+type flashEntry struct {
+	flash   Flash
+	expires time.Time
+}
+
+// FlashStore keeps one flash per browser until it is shown.
+type FlashStore struct {
+	mu      sync.Mutex
+	flashes map[string]flashEntry
+}
+
+func NewFlashStore() *FlashStore {
+	return &FlashStore{flashes: make(map[string]flashEntry)}
+}
+
+func (f *FlashStore) Put(key string, flash Flash) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for other, entry := range f.flashes {
+		if now.After(entry.expires) {
+			delete(f.flashes, other)
+		}
+	}
+	f.flashes[key] = flashEntry{flash: flash, expires: now.Add(flashLifetime)}
+}
+
+func (f *FlashStore) Take(key string) Flash {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry, ok := f.flashes[key]
+	delete(f.flashes, key)
+	if !ok || time.Now().After(entry.expires) {
+		return Flash{}
+	}
+	return entry.flash
+}
+
+// flashKey ties flashes to the session of the user. Visitors without a
+// session get a flash cookie if create is set.
+func (s *Safe) flashKey(w http.ResponseWriter, r *http.Request, create bool) string {
+	if cookie, err := r.Cookie(cookieName); err == nil {
+		if s.sessionHandle(cookie.Value) != "" {
+			return "session:" + cookie.Value
+		}
+	}
+	if cookie, err := r.Cookie(flashCookieName); err == nil && cookie.Value != "" {
+		return "visitor:" + cookie.Value
+	}
+	if !create {
+		return ""
+	}
+	seed := make([]byte, 16)
+	if _, err := rand.Read(seed); err != nil {
+		return ""
+	}
+	id := hex.EncodeToString(seed)
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookieName,
+		Value:    id,
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return "visitor:" + id
+}
+
+// SetFlash keeps flash to be shown by the page the user is redirected to.
+func (s *Safe) SetFlash(w http.ResponseWriter, r *http.Request, flash Flash) {
+	if key := s.flashKey(w, r, true); key != "" {
+		s.flashes.Put(key, flash)
+	}
+}
+
+// TakeFlash returns the flash kept for the user, if any, and forgets it.
+func (s *Safe) TakeFlash(w http.ResponseWriter, r *http.Request) Flash {
+	if key := s.flashKey(w, r, false); key != "" {
+		return s.flashes.Take(key)
+	}
+	return Flash{}
+}
@@ -0,0 +1,98 @@
+package safe
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlashRoundTrip(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	alice := testUser(t, s, "alice")
+	bob := testUser(t, s, "bob")
+	var flash Flash
+	flash.Invalid("attorney", "invalid attorney")
+	flash.Keep("attorney", "abc")
+	if !flash.Failed() {
+		t.Fatalf("flash with an invalid field not failed")
+	}
+	s.SetFlash(httptest.NewRecorder(), sessionRequest(alice), flash)
+
+	if other := s.TakeFlash(httptest.NewRecorder(), sessionRequest(bob)); other.Failed() || other.Values != nil {
+		t.Errorf("flash of alice taken by bob: %+v", other)
+	}
+	taken := s.TakeFlash(httptest.NewRecorder(), sessionRequest(alice))
+	if taken.Fields["attorney"] != "invalid attorney" || taken.Values["attorney"] != "abc" {
+		t.Errorf("TakeFlash = %+v", taken)
+	}
+	if again := s.TakeFlash(httptest.NewRecorder(), sessionRequest(alice)); again.Failed() || again.Values != nil {
+		t.Errorf("flash taken twice: %+v", again)
+	}
+}
+
+func TestFlashVisitor(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	w := httptest.NewRecorder()
+	s.SetFlash(w, httptest.NewRequest(http.MethodPost, "/credentials", nil), Flash{Error: "invalid handle or password"})
+	var cookie *http.Cookie
+	for _, set := range w.Result().Cookies() {
+		if set.Name == flashCookieName {
+			cookie = set
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("no http only flash cookie set: %v", w.Result().Cookies())
+	}
+	if other := s.TakeFlash(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/login", nil)); other.Error != "" {
+		t.Errorf("flash taken without the cookie")
+	}
+	r := httptest.NewRequest(http.MethodGet, "/login", nil)
+	r.AddCookie(cookie)
+	if taken := s.TakeFlash(httptest.NewRecorder(), r); taken.Error != "invalid handle or password" {
+		t.Errorf("TakeFlash = %+v", taken)
+	}
+}
+
+func TestFlashExpires(t *testing.T) {
+	store := NewFlashStore()
+	store.Put("old", Flash{Notice: "old"})
+	store.flashes["old"] = flashEntry{flash: Flash{Notice: "old"}, expires: time.Now().Add(-time.Second)}
+	if taken := store.Take("old"); taken.Notice != "" {
+		t.Errorf("expired flash taken")
+	}
+	store.flashes["old"] = flashEntry{flash: Flash{Notice: "old"}, expires: time.Now().Add(-time.Second)}
+	store.Put("new", Flash{Notice: "new"})
+	if _, ok := store.flashes["old"]; ok {
+		t.Errorf("expired flash kept by Put")
+	}
+}
+
+// TestFlashAcrossRedirect posts an invalid grant and checks that the main
+// page shown after the redirect reports the error and refills the form.
+func TestFlashAcrossRedirect(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	session := testUser(t, s, "alice")
+	form := url.Values{
+		csrfField:  {s.csrfToken(sessionRequest(session))},
+		"poa":      {"grant"},
+		"attorney": {"not-a-token"},
+	}
+	if w := formPost(s.PoAHandler, "/poa", session, form); w.Code != http.StatusSeeOther {
+		t.Fatalf("POST /poa = %v", w.Code)
+	}
+	w := httptest.NewRecorder()
+	s.UserHandler(w, sessionRequest(session))
+	page := html.UnescapeString(w.Body.String())
+	if !strings.Contains(page, Translate(DefaultLanguage, "flash.attorney_invalid")) || !strings.Contains(page, `value="not-a-token"`) {
+		t.Errorf("main page after the redirect does not show the flash:\n%v", page)
+	}
+	w = httptest.NewRecorder()
+	s.UserHandler(w, sessionRequest(session))
+	if strings.Contains(html.UnescapeString(w.Body.String()), "not-a-token") {
+		t.Errorf("flash shown twice")
+	}
+}
@@ -0,0 +1,133 @@
+package safe
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+var ErrAccountFrozen = errors.New("account frozen")
+
+// Frozen tells if handle cannot sign new grants.
+func (s *Safe) Frozen(handle string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[handle]
+	return ok && user.Frozen
+}
+
+func (s *Safe) setFrozen(handle string, frozen bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[handle]
+	if !ok {
+		return errors.New("invalid user")
+	}
+	if err := s.vault.SetFrozen(handle, frozen); err != nil {
+		return err
+	}
+	user.Frozen = frozen
+	return nil
+}
+
+// RevokeAll is the emergency action for a compromised account: it freezes
+// the account, drops the pending grants, signs and sends revokes for every
+// attorney, including grants sent but not yet on chain and pending ones,
+// and ends every session. It goes as far as it can and returns the first
+// error.
+func (s *Safe) RevokeAll(handle string) error {
+	if err := s.setFrozen(handle, true); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	user := s.users[handle]
+	attorneys := make(map[crypto.Token]struct{})
+	for _, attorney := range user.Attorneys {
+		attorneys[attorney] = struct{}{}
+	}
+	s.mu.RUnlock()
+	// grants already sent may still be incorporated
+	for _, record := range s.vault.HandleRecords(handle) {
+		if !record.Confirmed {
+			attorneys[record.Attorney] = struct{}{}
+		}
+	}
+	s.mu.Lock()
+	for secret, pending := range s.pending {
+		if pending.Grant != nil && pending.Grant.Author.Equal(user.Token) {
+			attorneys[pending.Grant.Attorney] = struct{}{}
+			delete(s.pending, secret)
+		}
+	}
+	s.mu.Unlock()
+	var failed error
+	for attorney := range attorneys {
+		if err := s.RevokePower(handle, attorney.Hex()); err != nil && failed == nil {
+			failed = fmt.Errorf("could not revoke %v: %w", attorney.Hex(), err)
+		}
+	}
+	if err := s.EndSessions(handle, ""); err != nil && failed == nil {
+		failed = err
+	}
+	return failed
+}
+
+// Unfreeze allows handle to sign grants again after checking the password
+// of a request from ip.
+func (s *Safe) Unfreeze(handle, password, ip string) error {
+	if err := s.Authenticate(handle, password, ip); err != nil {
+		return err
+	}
+	return s.setFrozen(handle, false)
+}
+
+func (s *Safe) FreezeHandler(w http.ResponseWriter, r *http.Request) {
+	handle := s.Handle(r)
+	if handle == "" || r.Method != http.MethodPost {
+		http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
+		return
+	}
+	if !s.checkCSRF(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	// the language of the user is lost with the sessions
+	language := s.Language(r)
+	flash := Flash{Notice: Translate(language, "flash.frozen")}
+	if err := s.RevokeAll(handle); err != nil {
+		log.Printf("error revoking all powers: %v", err)
+		flash = Flash{Error: Translate(language, "flash.freeze_failed", translateError(language, err))}
+	}
+	// sessions are over, the flash goes to the login page
+	s.SetFlash(w, r, flash)
+	http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
+}
+
+func (s *Safe) UnfreezeHandler(w http.ResponseWriter, r *http.Request) {
+	handle := s.Handle(r)
+	if handle == "" || r.Method != http.MethodPost {
+		http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		return
+	}
+	if !s.checkCSRF(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var flash Flash
+	language := s.Language(r)
+	if err := s.SecondFactor(handle, r.FormValue("totp"), clientIP(r)); err != nil {
+		flash.Invalid("unfreeze", translateError(language, err))
+	} else if err := s.Unfreeze(handle, r.FormValue("password"), clientIP(r)); err != nil {
+		flash.Invalid("unfreeze", translateError(language, err))
+	} else {
+		flash.Notice = Translate(language, "flash.unfrozen")
+	}
+	s.SetFlash(w, r, flash)
+	http.Redirect(w, r, fmt.Sprintf("%v/", s.serverName), http.StatusSeeOther)
+}
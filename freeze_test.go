@@ -0,0 +1,128 @@
+package safe
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func TestRevokeAll(t *testing.T) {
+	gateway := &testGateway{}
+	s := testSafe(t, gateway)
+	session := testUser(t, s, "alice")
+	sent, _ := crypto.RandomAsymetricKey()
+	if err := s.GrantPower("alice", sent.Hex(), "", nil, GrantOrigin{Method: GrantWeb}); err != nil {
+		t.Fatalf("GrantPower: %v", err)
+	}
+	pending, _ := crypto.RandomAsymetricKey()
+	grant, err := s.GrantAction("alice", pending.Hex())
+	if err != nil {
+		t.Fatalf("GrantAction: %v", err)
+	}
+	s.pending["secret"] = &PendingGrant{Grant: grant}
+	events, cancel := s.events.Subscribe("alice")
+	defer cancel()
+
+	if err := s.RevokeAll("alice"); err != nil {
+		t.Fatalf("RevokeAll: %v", err)
+	}
+	revoked := make(map[string]bool)
+	for len(events) > 0 {
+		if event := <-events; event.Type == EventRevokeSent {
+			revoked[event.Attorney] = true
+		}
+	}
+	if !revoked[sent.Hex()] {
+		t.Error("grant sent but not on chain was not revoked")
+	}
+	if !revoked[pending.Hex()] {
+		t.Error("pending grant was not revoked")
+	}
+	if _, ok := s.pending["secret"]; ok {
+		t.Error("pending grant was kept")
+	}
+	if !s.Frozen("alice") {
+		t.Error("account was not frozen")
+	}
+	if s.Handle(sessionRequest(session)) != "" {
+		t.Error("session survived RevokeAll")
+	}
+}
+
+func TestRevokeAllReportsUnsentRevokes(t *testing.T) {
+	gateway := &testGateway{}
+	s := testSafe(t, gateway)
+	testUser(t, s, "alice")
+	attorney, _ := crypto.RandomAsymetricKey()
+	if err := s.GrantPower("alice", attorney.Hex(), "", nil, GrantOrigin{Method: GrantWeb}); err != nil {
+		t.Fatalf("GrantPower: %v", err)
+	}
+	gateway.setDown(true)
+	if err := s.RevokeAll("alice"); !errors.Is(err, ErrNotSent) {
+		t.Errorf("RevokeAll = %v, want ErrNotSent", err)
+	}
+}
+
+// formPost posts form to handler with the session cookie.
+func formPost(handler http.HandlerFunc, path, session string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: cookieName, Value: session})
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestFreezeChecksCSRF(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	session := testUser(t, s, "alice")
+	if w := formPost(s.FreezeHandler, "/freeze", session, url.Values{}); w.Code != http.StatusForbidden || s.Frozen("alice") {
+		t.Fatalf("freeze without CSRF token = %v, frozen %v", w.Code, s.Frozen("alice"))
+	}
+	csrf := s.csrfToken(sessionRequest(session))
+	formPost(s.FreezeHandler, "/freeze", session, url.Values{csrfField: {csrf}})
+	if !s.Frozen("alice") {
+		t.Fatalf("account not frozen")
+	}
+
+	session = s.CreateSession("alice")
+	csrf = s.csrfToken(sessionRequest(session))
+	form := url.Values{"password": {"correct horse battery"}}
+	if w := formPost(s.UnfreezeHandler, "/unfreeze", session, form); w.Code != http.StatusForbidden || !s.Frozen("alice") {
+		t.Errorf("unfreeze without CSRF token = %v, frozen %v", w.Code, s.Frozen("alice"))
+	}
+	form.Set(csrfField, csrf)
+	formPost(s.UnfreezeHandler, "/unfreeze", session, form)
+	if s.Frozen("alice") {
+		t.Errorf("account still frozen")
+	}
+}
+
+func TestUnfreezeSecondFactor(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	testUser(t, s, "alice")
+	secret := testTOTP(t, s, "alice")
+	if err := s.RevokeAll("alice"); err != nil {
+		t.Fatalf("RevokeAll: %v", err)
+	}
+	session := s.CreateSession("alice")
+	form := url.Values{"password": {"correct horse battery"}, csrfField: {s.csrfToken(sessionRequest(session))}}
+	formPost(s.UnfreezeHandler, "/unfreeze", session, form)
+	if !s.Frozen("alice") {
+		t.Fatalf("account unfrozen without the second factor")
+	}
+	if w := restCall(t, s, http.MethodPost, "/v1/users/alice/unfreeze", session, UnfreezeRequest{Password: "correct horse battery"}); w.Code != http.StatusForbidden || !s.Frozen("alice") {
+		t.Fatalf("REST unfreeze without the second factor = %v", w.Code)
+	}
+	form.Set("totp", totpCode(secret, totpStep(time.Now())+1))
+	formPost(s.UnfreezeHandler, "/unfreeze", session, form)
+	if s.Frozen("alice") {
+		t.Errorf("account still frozen with the second factor")
+	}
+}
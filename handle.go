@@ -0,0 +1,167 @@
+package safe
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Handle policy violations. They are also the codes reported by the REST
+// API.
+const (
+	HandleEmpty      = "handle_required"
+	HandleTooShort   = "handle_too_short"
+	HandleTooLong    = "handle_too_long"
+	HandleCharacters = "handle_invalid_characters"
+	HandleReserved   = "handle_reserved"
+	HandleBlocked    = "handle_blocked"
+)
+
+// HandlePolicy restricts the handles of new users. Handles are trimmed and,
+// with FoldCase, lower cased before being checked and stored. Only the
+// characters in Charset are accepted, which keeps out whitespace, control
+// characters and unicode confusables. Handles must start and end with a
+// letter or digit. Lengths are counted in characters. Reserved handles are
+// matched regardless of case and blocked words must not appear anywhere in
+// the handle.
+type HandlePolicy struct {
+	MinLength int
+	MaxLength int
+	Charset   string
+	FoldCase  bool
+	Reserved  []string
+	Blocked   []string
+}
+
+const handleAlphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// DefaultHandlePolicy is used when SafeConfig.HandlePolicy is nil.
+var DefaultHandlePolicy = HandlePolicy{
+	MinLength: 3,
+	MaxLength: 32,
+	Charset:   "abcdefghijklmnopqrstuvwxyz0123456789_.-",
+	FoldCase:  true,
+	Reserved: []string{
+		"admin", "administrator", "root", "system", "safe", "support", "help",
+		"security", "abuse", "postmaster", "webmaster", "hostmaster", "info",
+		"api", "www", "mail", "official", "staff", "moderator", "null", "anonymous",
+	},
+}
+
+// HandleError describes why a handle was rejected.
+type HandleError struct {
+	Code    string
+	Message string
+	// key and args of the message in the catalogs
+	key  string
+	args []any
+}
+
+func (e *HandleError) Error() string {
+	return e.Message
+}
+
+// Normalize returns the handle as it would be stored, without checking it.
+func (p HandlePolicy) Normalize(handle string) string {
+	handle = strings.TrimSpace(handle)
+	if p.FoldCase {
+		handle = strings.ToLower(handle)
+	}
+	return handle
+}
+
+// Describe tells users which handles are accepted.
+func (p HandlePolicy) Describe() string {
+	return p.DescribeIn(DefaultLanguage)
+}
+
+// DescribeIn is Describe in language.
+func (p HandlePolicy) DescribeIn(language string) string {
+	switch {
+	case p.MinLength > 0 && p.MaxLength > 0:
+		return Translate(language, "handle.policy", Translate(language, "handle.length_range", p.MinLength, p.MaxLength), p.Charset)
+	case p.MaxLength > 0:
+		return Translate(language, "handle.policy", Translate(language, "handle.length_max", p.MaxLength), p.Charset)
+	case p.MinLength > 0:
+		return Translate(language, "handle.policy", Translate(language, "handle.length_min", p.MinLength), p.Charset)
+	}
+	return Translate(language, "handle.policy_unbounded", p.Charset)
+}
+
+// Check normalizes handle and returns a *HandleError if it violates the
+// policy.
+func (p HandlePolicy) Check(handle string) (string, error) {
+	handle = p.Normalize(handle)
+	if handle == "" {
+		return "", &HandleError{Code: HandleEmpty, Message: "Handle is required", key: "handle.required"}
+	}
+	for _, c := range handle {
+		if !strings.ContainsRune(p.Charset, c) {
+			return handle, &HandleError{Code: HandleCharacters, Message: fmt.Sprintf("Handle may only contain the characters %v", p.Charset), key: "handle.characters", args: []any{p.Charset}}
+		}
+	}
+	first, _ := utf8.DecodeRuneInString(handle)
+	last, _ := utf8.DecodeLastRuneInString(handle)
+	if !strings.ContainsRune(handleAlphanumeric, first) || !strings.ContainsRune(handleAlphanumeric, last) {
+		return handle, &HandleError{Code: HandleCharacters, Message: "Handle must start and end with a letter or digit", key: "handle.edges"}
+	}
+	length := utf8.RuneCountInString(handle)
+	if p.MinLength > 0 && length < p.MinLength {
+		return handle, &HandleError{Code: HandleTooShort, Message: fmt.Sprintf("Handle must have at least %v characters", p.MinLength), key: "handle.too_short", args: []any{p.MinLength}}
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return handle, &HandleError{Code: HandleTooLong, Message: fmt.Sprintf("Handle must have at most %v characters", p.MaxLength), key: "handle.too_long", args: []any{p.MaxLength}}
+	}
+	for _, reserved := range p.Reserved {
+		if strings.EqualFold(handle, strings.TrimSpace(reserved)) {
+			return handle, &HandleError{Code: HandleReserved, Message: "Handle is reserved", key: "handle.reserved"}
+		}
+	}
+	lowered := strings.ToLower(handle)
+	for _, blocked := range p.Blocked {
+		if blocked = strings.ToLower(strings.TrimSpace(blocked)); blocked != "" && strings.Contains(lowered, blocked) {
+			return handle, &HandleError{Code: HandleBlocked, Message: "Handle is not allowed", key: "handle.blocked"}
+		}
+	}
+	return handle, nil
+}
+
+// CheckHandle applies the handle policy of the safe to a new handle and
+// returns it normalized.
+func (s *Safe) CheckHandle(handle string) (string, error) {
+	return s.handles.Check(handle)
+}
+
+// HandleTaken tells whether handle is in use by a local user or joined the
+// network from elsewhere. With case folding handles created before the
+// policy differing only in case are taken as well.
+func (s *Safe) HandleTaken(handle string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.users[handle]; ok {
+		return true
+	}
+	if s.network.Taken(handle, s.handles.FoldCase) {
+		return true
+	}
+	if !s.handles.FoldCase {
+		return false
+	}
+	for existing := range s.users {
+		if strings.EqualFold(existing, handle) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoginHandle maps the handle typed at login to the stored one. Handles
+// created before the policy are matched exactly.
+func (s *Safe) LoginHandle(handle string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.users[handle]; ok {
+		return handle
+	}
+	return s.handles.Normalize(handle)
+}
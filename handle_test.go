@@ -0,0 +1,79 @@
+package safe
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHandlePolicy(t *testing.T) {
+	policy := DefaultHandlePolicy
+	policy.Blocked = []string{"Scam"}
+	cases := []struct {
+		handle string
+		want   string
+		code   string
+	}{
+		{"alice", "alice", ""},
+		{"  Alice.B ", "alice.b", ""},
+		{"a-1", "a-1", ""},
+		{"", "", HandleEmpty},
+		{"   ", "", HandleEmpty},
+		{"al", "al", HandleTooShort},
+		{strings.Repeat("a", 33), strings.Repeat("a", 33), HandleTooLong},
+		{"ali ce", "ali ce", HandleCharacters},
+		{"alice\n", "alice", ""},
+		{"alice\x00", "alice\x00", HandleCharacters},
+		{"аlice", "аlice", HandleCharacters}, // cyrillic a
+		{"_alice", "_alice", HandleCharacters},
+		{"alice.", "alice.", HandleCharacters},
+		{"ADMIN", "admin", HandleReserved},
+		{"admins", "admins", ""},
+		{"notascammer", "notascammer", HandleBlocked},
+	}
+	for _, c := range cases {
+		got, err := policy.Check(c.handle)
+		if got != c.want {
+			t.Errorf("Check(%q) = %q, want %q", c.handle, got, c.want)
+		}
+		code := ""
+		if violation, ok := err.(*HandleError); ok {
+			code = violation.Code
+		} else if err != nil {
+			t.Errorf("Check(%q) = %v, want a *HandleError", c.handle, err)
+		}
+		if code != c.code {
+			t.Errorf("Check(%q) code = %q, want %q", c.handle, code, c.code)
+		}
+	}
+}
+
+func TestHandlePolicyWithoutFolding(t *testing.T) {
+	policy := HandlePolicy{MinLength: 1, Charset: handleAlphanumeric, Reserved: []string{"Root"}}
+	if _, err := policy.Check("ROOT"); err == nil {
+		t.Errorf("reserved handle accepted in another case")
+	}
+	if got, err := policy.Check("Alice"); err != nil || got != "Alice" {
+		t.Errorf("Check(Alice) = %q, %v", got, err)
+	}
+}
+
+func TestHandlePolicyRunes(t *testing.T) {
+	policy := HandlePolicy{MinLength: 3, MaxLength: 3, Charset: handleAlphanumeric + "éü"}
+	if _, err := policy.Check("aéb"); err != nil {
+		t.Errorf("three character handle with a two byte rune: %v", err)
+	}
+	for _, handle := range []string{"éab", "abü"} {
+		if err, ok := check(policy, handle); !ok || err.Code != HandleCharacters {
+			t.Errorf("Check(%q) = %v, want an edge violation", handle, err)
+		}
+	}
+	if err, ok := check(policy, "aéüb"); !ok || err.Code != HandleTooLong {
+		t.Errorf("four character handle = %v, want too long", err)
+	}
+}
+
+func check(policy HandlePolicy, handle string) (*HandleError, bool) {
+	_, err := policy.Check(handle)
+	violation, ok := err.(*HandleError)
+	return violation, ok
+}
@@ -2,11 +2,12 @@ package safe
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/freehandle/breeze/crypto"
 	"github.com/freehandle/handles/attorney"
@@ -14,41 +15,164 @@ import (
 
 const cookieName = "safeSessionCookie"
 
+// Pending grants wait pendingTTL for the user consent. At most maxPending
+// are kept so that apps cannot grow them without bound, expired ones are
+// swept at most every pendingSweep.
+const (
+	pendingTTL   = 24 * time.Hour
+	maxPending   = 100000
+	pendingSweep = time.Minute
+)
+
+var ErrTooManyPending = errors.New("too many pending requests")
+
+type AttorneyView struct {
+	Token       string
+	Short       string
+	App         string
+	Info        AppInfo
+	Method      string
+	Epoch       uint64
+	Fingerprint string
+	Scopes      []string
+	Confirmed   bool
+	Expires     string
+}
+
 type UserView struct {
 	Handle    string
-	Attorneys []string
+	Attorneys []AttorneyView
+	Awaiting  []AttorneyView
 	Error     string
 	Live      bool
+	Frozen    bool
+	TwoFactor bool
+	Flash     Flash
+	CSRF      string
 }
 
 func (s *Safe) UserAttorneys(handle string) []crypto.Token {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	user, ok := s.users[handle]
 	if !ok {
 		return nil
 	}
-	return user.Attorneys
+	return append([]crypto.Token{}, user.Attorneys...)
+}
+
+// hasAttorney tells whether app holds power of attorney from handle.
+func (s *Safe) hasAttorney(handle string, app crypto.Token) bool {
+	for _, attorney := range s.UserAttorneys(handle) {
+		if attorney.Equal(app) {
+			return true
+		}
+	}
+	return false
 }
 
-func (s *Safe) UserHandleView(handle string) UserView {
+func (s *Safe) UserHandleView(handle, language string) UserView {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	user, ok := s.users[handle]
 	if !ok {
 		return UserView{
-			Error: "user not found",
+			Error: Translate(language, "error.user_not_found"),
 		}
 	}
 	view := UserView{
 		Handle:    handle,
-		Attorneys: make([]string, len(user.Attorneys)),
+		Attorneys: make([]AttorneyView, len(user.Attorneys)),
 		Live:      user.Confirmed,
+		Frozen:    user.Frozen,
+		TwoFactor: s.TwoFactorEnabled(handle),
 	}
 	for n, grantee := range user.Attorneys {
-		view.Attorneys[n] = hex.EncodeToString(grantee[:])
+		record, ok := user.Records[grantee]
+		if !ok {
+			record = AttorneyRecord{Attorney: grantee, Method: GrantNetwork, Confirmed: true}
+		}
+		view.Attorneys[n] = s.attorneyView(user, record, language)
 	}
+	view.Awaiting = s.awaitingViews(handle, user, language)
 	return view
 }
 
-func (s *Safe) NewPending(uniqueURL string, grant *attorney.GrantPowerOfAttorney) {
-	s.pending[uniqueURL] = grant
+// PendingGrant is a signed grant waiting for the user consent on the
+// confirmation page together with the scopes requested by the app. Decoys
+// kept in place of grants that could not be signed have no Grant.
+type PendingGrant struct {
+	Grant    *attorney.GrantPowerOfAttorney
+	Handle   string
+	Attorney crypto.Token
+	Scopes   []string
+	App      string
+	expires  time.Time
+}
+
+type ScopeView struct {
+	Name    string
+	Checked bool
+}
+
+type ConsentView struct {
+	Handle    string
+	Secret    string
+	Attorney  string
+	App       string
+	Info      AppInfo
+	Scopes    []ScopeView
+	TwoFactor bool
+	Flash     Flash
+	CSRF      string
+}
+
+// NewPending keeps pending under uniqueURL for pendingTTL. It returns
+// ErrTooManyPending if maxPending requests are waiting.
+func (s *Safe) NewPending(uniqueURL string, pending PendingGrant) error {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) >= maxPending || now.Sub(s.pendingSwept) >= pendingSweep {
+		s.pendingSwept = now
+		for secret, old := range s.pending {
+			if now.After(old.expires) {
+				delete(s.pending, secret)
+			}
+		}
+	}
+	if len(s.pending) >= maxPending {
+		return ErrTooManyPending
+	}
+	pending.expires = now.Add(pendingTTL)
+	s.pending[uniqueURL] = &pending
+	return nil
+}
+
+// Pending returns the request kept under uniqueURL, if not expired.
+func (s *Safe) Pending(uniqueURL string) (*PendingGrant, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pending, ok := s.pending[uniqueURL]
+	if !ok || time.Now().After(pending.expires) {
+		return nil, false
+	}
+	return pending, true
+}
+
+// EndPending forgets the request kept under uniqueURL once answered.
+func (s *Safe) EndPending(uniqueURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, uniqueURL)
+}
+
+func scopesView(checked []string) []ScopeView {
+	view := make([]ScopeView, len(KnownScopes))
+	for n, scope := range KnownScopes {
+		view[n] = ScopeView{Name: scope, Checked: HasScope(checked, scope)}
+	}
+	return view
 }
 
 func (s *Safe) ConfirmHandler(w http.ResponseWriter, r *http.Request) {
@@ -57,26 +181,82 @@ func (s *Safe) ConfirmHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	grant, ok := s.pending[parts[1]]
+	secret := parts[1]
+	pending, ok := s.Pending(secret)
 	if !ok {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	token := grant.Author
-	handle := ""
-	for h, user := range s.users {
-		if user.Token.Equal(token) {
-			handle = h
-			break
-		}
+	// only the author of the grant can consent to it, decoys are answered
+	// alike until then
+	handle := pending.Handle
+	if s.Handle(r) != handle {
+		next := url.QueryEscape(fmt.Sprintf("/confirm/%v", secret))
+		http.Redirect(w, r, fmt.Sprintf("%v/login?next=%v", s.serverName, next), http.StatusSeeOther)
+		return
 	}
-	if handle == "" {
+	grant := pending.Grant
+	if grant == nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	s.Send(grant.Serialize())
-	delete(s.pending, r.URL.Path)
-	http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
+	if r.Method != http.MethodPost {
+		view := ConsentView{
+			Handle:    handle,
+			Secret:    secret,
+			Attorney:  hex.EncodeToString(grant.Attorney[:]),
+			App:       pending.App,
+			Info:      s.directory.Lookup(grant.Attorney),
+			Scopes:    scopesView(pending.Scopes),
+			TwoFactor: s.TwoFactorEnabled(handle),
+			Flash:     s.TakeFlash(w, r),
+			CSRF:      s.csrfToken(r),
+		}
+		s.render(w, r, "confirm.html", view)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		return
+	}
+	if !s.checkCSRF(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.FormValue("consent") != "grant" {
+		s.EndPending(secret)
+		http.Redirect(w, r, fmt.Sprintf("%v/", s.serverName), http.StatusSeeOther)
+		return
+	}
+	language := s.Language(r)
+	var flash Flash
+	flash.Keep("expires_in", r.FormValue("expires_in"))
+	flash.Keep("expires_at", r.FormValue("expires_at"))
+	expiry, err := s.parseExpiryForm(r)
+	if err != nil {
+		flash.Invalid("expires", translateError(language, err))
+	}
+	if err := s.SecondFactor(handle, r.FormValue("totp"), clientIP(r)); err != nil {
+		flash.Invalid("totp", translateError(language, err))
+	}
+	if flash.Failed() {
+		s.SetFlash(w, r, flash)
+		http.Redirect(w, r, fmt.Sprintf("%v/confirm/%v", s.serverName, secret), http.StatusSeeOther)
+		return
+	}
+	s.EndPending(secret)
+	flash = Flash{Notice: Translate(language, "flash.grant_sent")}
+	if err := s.SetScopes(handle, grant.Attorney, r.Form["scope"]); err != nil {
+		flash.Error = Translate(language, "flash.scopes_failed", err)
+	} else if err := s.SetExpiry(handle, grant.Attorney, expiry); err != nil {
+		flash.Error = Translate(language, "flash.expiry_failed", err)
+	} else if err := s.SendGrant(handle, grant, GrantOrigin{Method: GrantConfirm, App: pending.App}); err != nil {
+		flash.Error = Translate(language, "flash.grant_failed", err)
+	}
+	if flash.Error != "" {
+		flash.Notice = ""
+	}
+	s.SetFlash(w, r, flash)
+	http.Redirect(w, r, fmt.Sprintf("%v/", s.serverName), http.StatusSeeOther)
 }
 
 func (s *Safe) RevokePOAHandler(w http.ResponseWriter, r *http.Request) {
@@ -85,12 +265,29 @@ func (s *Safe) RevokePOAHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
 		return
 	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		return
+	}
+	if !s.checkCSRF(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
 	revoking := r.URL.Path
 	revoking = strings.Replace(revoking, "/revoke/", "", 1)
-	err := s.RevokePower(handle, revoking)
-	if err != nil {
-		log.Printf("error granting/revoking power: %v", err)
+	language := s.Language(r)
+	var flash Flash
+	if err := s.SecondFactor(handle, r.FormValue("totp"), clientIP(r)); err != nil {
+		flash.Error = translateError(language, err)
+	} else if err := s.RevokePower(handle, revoking); err != nil {
+		flash.Error = Translate(language, "flash.revoke_failed", err)
+	} else {
+		flash.Notice = Translate(language, "flash.revoke_sent")
 	}
+	s.SetFlash(w, r, flash)
 	http.Redirect(w, r, fmt.Sprintf("%v/", s.serverName), http.StatusSeeOther)
 }
 
@@ -103,18 +300,56 @@ func (s *Safe) PoAHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		return
 	}
-	attorney := r.FormValue("attorney")
-	fingerprint := r.FormValue("fingerprint")
+	if !s.checkCSRF(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	attorney := strings.TrimSpace(r.FormValue("attorney"))
+	fingerprint := strings.TrimSpace(r.FormValue("fingerprint"))
 	poa := r.FormValue("poa")
-	var err error
+	language := s.Language(r)
+	var flash Flash
 	if poa == "grant" {
-		err = s.GrantPower(handle, attorney, fingerprint)
-	} else {
-		err = s.RevokePower(handle, attorney)
+		flash.Keep("attorney", attorney)
+		flash.Keep("fingerprint", fingerprint)
+		flash.Keep("expires_in", r.FormValue("expires_in"))
+		flash.Keep("expires_at", r.FormValue("expires_at"))
 	}
-	if err != nil {
-		log.Printf("error granting/revoking power: %v", err)
+	var expiry Expiry
+	if poa == "grant" {
+		if _, ok := attorneyToken(attorney); !ok {
+			flash.Invalid("attorney", Translate(language, "flash.attorney_invalid"))
+		}
+		var err error
+		if expiry, err = s.parseExpiryForm(r); err != nil {
+			flash.Invalid("expires", translateError(language, err))
+		}
+		if err := s.SecondFactor(handle, r.FormValue("totp"), clientIP(r)); err != nil {
+			flash.Invalid("totp", translateError(language, err))
+		}
+	} else if err := s.SecondFactor(handle, r.FormValue("totp"), clientIP(r)); err != nil {
+		// revocations come from the attorney list, not from the grant form
+		flash.Error = translateError(language, err)
+	}
+	if !flash.Failed() {
+		var err error
+		if poa == "grant" {
+			err = s.GrantPowerUntil(handle, attorney, fingerprint, r.Form["scope"], expiry, GrantOrigin{Method: GrantWeb})
+		} else {
+			err = s.RevokePower(handle, attorney)
+		}
+		switch {
+		case err != nil && poa == "grant":
+			flash.Error = Translate(language, "flash.grant_failed", err)
+		case err != nil:
+			flash.Error = Translate(language, "flash.revoke_failed", err)
+		case poa == "grant":
+			flash = Flash{Notice: Translate(language, "flash.grant_sent")}
+		default:
+			flash = Flash{Notice: Translate(language, "flash.revoke_sent")}
+		}
 	}
+	s.SetFlash(w, r, flash)
 	http.Redirect(w, r, fmt.Sprintf("%v/", s.serverName), http.StatusSeeOther)
 }
 
@@ -124,9 +359,7 @@ func (s *Safe) GrantHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
 		return
 	}
-	if err := s.templates.ExecuteTemplate(w, "grant.html", ""); err != nil {
-		log.Println(err)
-	}
+	s.render(w, r, "grant.html", s.csrfToken(r))
 }
 
 func (s *Safe) RevokeHandler(w http.ResponseWriter, r *http.Request) {
@@ -135,26 +368,41 @@ func (s *Safe) RevokeHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
 		return
 	}
-	if err := s.templates.ExecuteTemplate(w, "revoke.html", ""); err != nil {
-		log.Println(err)
+	s.render(w, r, "revoke.html", s.csrfToken(r))
+}
+
+type LoginView struct {
+	Next  string
+	Flash Flash
+}
+
+// nextPath returns the local path the user should be sent to after login, or
+// the root path if next is not a local path.
+func nextPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.Contains(next, "\\") {
+		return "/"
 	}
+	return next
 }
 
 func (s *Safe) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	next := nextPath(r.URL.Query().Get("next"))
 	handle := s.Handle(r)
 	if handle != "" {
-		http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
+		http.Redirect(w, r, fmt.Sprintf("%v%v", s.serverName, next), http.StatusSeeOther)
 		return
 	}
-	if err := s.templates.ExecuteTemplate(w, "login.html", ""); err != nil {
-		log.Println(err)
-	}
+	s.render(w, r, "login.html", LoginView{Next: next, Flash: s.TakeFlash(w, r)})
+}
+
+// SigninView refills the sign in form after a rejected attempt.
+type SigninView struct {
+	Flash  Flash
+	Policy HandlePolicy
 }
 
 func (s *Safe) SigninHandler(w http.ResponseWriter, r *http.Request) {
-	if err := s.templates.ExecuteTemplate(w, "signin.html", ""); err != nil {
-		log.Println(err)
-	}
+	s.render(w, r, "signin.html", SigninView{Policy: s.handles, Flash: s.TakeFlash(w, r)})
 }
 
 func (s *Safe) UserHandler(w http.ResponseWriter, r *http.Request) {
@@ -163,27 +411,65 @@ func (s *Safe) UserHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
 		return
 	}
-	view := s.UserHandleView(handle)
-	if err := s.templates.ExecuteTemplate(w, "main.html", view); err != nil {
-		log.Println(err)
-	}
+	view := s.UserHandleView(handle, s.Language(r))
+	view.Flash = s.TakeFlash(w, r)
+	view.CSRF = s.csrfToken(r)
+	s.render(w, r, "main.html", view)
 }
 
 func (s *Safe) CredentialsHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		return
 	}
-	handle := r.FormValue("handle")
+	handle := s.LoginHandle(r.FormValue("handle"))
 	password := r.FormValue("password")
-	if !s.CheckCredentials(handle, password) {
+	next := r.FormValue("next")
+	language := s.Language(r)
+	view := LoginView{Next: nextPath(next)}
+	view.Flash.Keep("handle", r.FormValue("handle"))
+	if handle == "" {
+		view.Flash.Invalid("handle", Translate(language, "flash.handle_missing"))
+	}
+	if password == "" {
+		view.Flash.Invalid("password", Translate(language, "flash.password_missing"))
+	}
+	if !view.Flash.Failed() {
+		if err := s.Authenticate(handle, password, clientIP(r)); err != nil {
+			view.Flash.Error = translateError(language, err)
+		}
+	}
+	if view.Flash.Failed() {
+		w.WriteHeader(http.StatusUnauthorized)
+		s.render(w, r, "login.html", view)
+		return
+	}
+	if s.TwoFactorEnabled(handle) || s.HasSecurityKey(handle) {
+		view := TwoFactorLoginView{
+			Ticket:      s.twoFactor.NewTicket(handle, next),
+			TOTP:        s.TwoFactorEnabled(handle),
+			SecurityKey: s.HasSecurityKey(handle),
+		}
+		s.render(w, r, "twofactor.html", view)
+		return
+	}
+	s.startSession(w, r, handle, next)
+}
+
+// startSession sets the session cookie of handle and redirects to next.
+func (s *Safe) startSession(w http.ResponseWriter, r *http.Request, handle, next string) {
+	if !s.setSessionCookie(w, handle) {
 		http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
 		return
 	}
+	http.Redirect(w, r, fmt.Sprintf("%v%v", s.serverName, nextPath(next)), http.StatusSeeOther)
+}
+
+// setSessionCookie creates a session for handle and sets its cookie.
+func (s *Safe) setSessionCookie(w http.ResponseWriter, handle string) bool {
 	cookie := s.CreateSession(handle)
 	//fmt.Println("cookie", url.QueryEscape(cookie))
 	if cookie == "" {
-		http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
-		return
+		return false
 	}
 
 	httpCookie := &http.Cookie{
@@ -192,38 +478,52 @@ func (s *Safe) CredentialsHandler(w http.ResponseWriter, r *http.Request) {
 		MaxAge:   60 * 60 * 24 * 7,
 		Secure:   true,
 		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	}
 
 	http.SetCookie(w, httpCookie)
-	http.Redirect(w, r, fmt.Sprintf("%v/", s.serverName), http.StatusSeeOther)
+	return true
 }
 
 func (s *Safe) NewUserHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		return
 	}
-	handle := r.FormValue("handle")
 	password := r.FormValue("password")
-	email := r.FormValue("email")
-
-	_, ok := s.users[handle]
-	if ok {
-		http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
-		return
+	language := s.Language(r)
+	view := SigninView{Policy: s.handles}
+	view.Flash.Keep("handle", r.FormValue("handle"))
+	view.Flash.Keep("email", r.FormValue("email"))
+	handle, err := s.CheckHandle(r.FormValue("handle"))
+	if err != nil {
+		view.Flash.Invalid("handle", translateError(language, err))
+	} else if s.HandleTaken(handle) {
+		view.Flash.Invalid("handle", Translate(language, "flash.handle_taken"))
 	}
-	s.Signin(handle, password, email)
-	http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
+	email, err := validEmail(r.FormValue("email"))
+	if err != nil {
+		view.Flash.Invalid("email", translateError(language, err))
+	}
+	if len(password) < minPasswordLength {
+		view.Flash.Invalid("password", translateError(language, ErrPasswordTooShort))
+	} else if password != r.FormValue("repassword") {
+		view.Flash.Invalid("repassword", translateError(language, ErrPasswordMismatch))
+	}
+	if !view.Flash.Failed() {
+		if s.Signin(handle, password, email) {
+			s.SetFlash(w, r, Flash{Notice: Translate(language, "flash.user_created", handle)})
+			http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
+			return
+		}
+		view.Flash.Error = Translate(language, "flash.create_failed")
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	s.render(w, r, "signin.html", view)
 }
 
 func (s *Safe) SignoutHandlewr(w http.ResponseWriter, r *http.Request) {
 	if cookie, err := r.Cookie(cookieName); err == nil {
-		if token, ok := s.Session.Get(cookie.Value); ok {
-			for _, user := range s.users {
-				if user.Token.Equal(token) {
-					s.Session.Unset(user.Token, cookie.Value)
-				}
-			}
-		}
+		s.EndSession(cookie.Value)
 	}
 	http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
 }
@@ -0,0 +1,191 @@
+package safe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/handles/attorney"
+)
+
+// Actions of the account history.
+const (
+	HistoryJoin   = "join"
+	HistoryGrant  = "grant"
+	HistoryRevoke = "revoke"
+)
+
+// Status of the entries of the account history.
+const (
+	HistoryConfirmed = "confirmed"
+	HistoryActive    = "active"
+	HistoryRevoked   = "revoked"
+	HistoryRenewed   = "renewed"
+	HistoryAwaiting  = "awaiting"
+)
+
+// HistoryEntry is an action of the user seen on chain, or sent and not yet
+// seen for grants awaiting confirmation. Name is the directory name of the
+// attorney if verified, otherwise the name claimed by the app if any.
+type HistoryEntry struct {
+	Action   string `json:"action"`
+	Epoch    uint64 `json:"epoch"`
+	Attorney string `json:"attorney,omitempty"`
+	Short    string `json:"-"`
+	Name     string `json:"name,omitempty"`
+	Verified bool   `json:"verified"`
+	Status   string `json:"status"`
+	Details  string `json:"details,omitempty"`
+}
+
+type HistoryResponse struct {
+	Handle  string         `json:"handle"`
+	Entries []HistoryEntry `json:"entries"`
+}
+
+// saveAction stores an action of a user of the safe seen on chain.
+func (s *Safe) saveAction(action []byte) {
+	if err := s.actions.SaveAction(action); err != nil {
+		log.Printf("could not store action: %v", err)
+	}
+}
+
+func (s *Safe) attorneyName(handle string, token crypto.Token) (string, bool) {
+	if info := s.directory.Lookup(token); info.Verified {
+		return info.Name, true
+	}
+	if record, ok := s.vault.Record(handle, token); ok {
+		return record.App, false
+	}
+	return "", false
+}
+
+// History returns the joins, grants and revokes of handle indexed in the
+// safe database, newest first.
+func (s *Safe) History(handle string) ([]HistoryEntry, error) {
+	s.mu.RLock()
+	user, ok := s.users[handle]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	actions, err := s.actions.LoadIndexedActions(crypto.HashToken(user.Token))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]HistoryEntry, 0, len(actions))
+	for _, action := range actions {
+		entry := HistoryEntry{Status: HistoryConfirmed, Details: attorney.ToString(action)}
+		var author, grantee crypto.Token
+		switch attorney.Kind(action) {
+		case attorney.JoinNetworkType:
+			join := attorney.ParseJoinNetwork(action)
+			if join == nil {
+				continue
+			}
+			entry.Action, entry.Epoch, author = HistoryJoin, join.Epoch, join.Author
+		case attorney.GrantPowerOfAttorneyType:
+			grant := attorney.ParseGrantPowerOfAttorney(action)
+			if grant == nil {
+				continue
+			}
+			entry.Action, entry.Epoch, author, grantee = HistoryGrant, grant.Epoch, grant.Author, grant.Attorney
+		case attorney.RevokePowerOfAttorneyType:
+			revoke := attorney.ParseRevokePowerOfAttorney(action)
+			if revoke == nil {
+				continue
+			}
+			entry.Action, entry.Epoch, author, grantee = HistoryRevoke, revoke.Epoch, revoke.Author, revoke.Attorney
+		default:
+			continue
+		}
+		// the index also holds actions naming the user as attorney
+		if !author.Equal(user.Token) {
+			continue
+		}
+		if entry.Action != HistoryJoin {
+			entry.Attorney = grantee.Hex()
+			entry.Short = shortToken(grantee)
+			entry.Name, entry.Verified = s.attorneyName(handle, grantee)
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Epoch < entries[j].Epoch })
+	// a grant is active until a later grant or revoke for the same attorney
+	latest := make(map[string]int)
+	for n, entry := range entries {
+		if entry.Action == HistoryJoin {
+			continue
+		}
+		if previous, ok := latest[entry.Attorney]; ok && entries[previous].Action == HistoryGrant {
+			if entry.Action == HistoryGrant {
+				entries[previous].Status = HistoryRenewed
+			} else {
+				entries[previous].Status = HistoryRevoked
+			}
+		}
+		latest[entry.Attorney] = n
+	}
+	for _, n := range latest {
+		if entries[n].Action == HistoryGrant {
+			entries[n].Status = HistoryActive
+		}
+	}
+	for _, record := range s.vault.HandleRecords(handle) {
+		if record.Confirmed || user.IsAttorney(record.Attorney) {
+			continue
+		}
+		name, verified := s.attorneyName(handle, record.Attorney)
+		entries = append(entries, HistoryEntry{
+			Action:   HistoryGrant,
+			Epoch:    record.Epoch,
+			Attorney: record.Attorney.Hex(),
+			Short:    shortToken(record.Attorney),
+			Name:     name,
+			Verified: verified,
+			Status:   HistoryAwaiting,
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Epoch > entries[j].Epoch })
+	return entries, nil
+}
+
+type HistoryView struct {
+	Handle  string
+	Entries []HistoryEntry
+	Error   string
+}
+
+// HistoryHandler shows the history of the user at /history and exports it
+// as JSON at /history.json.
+func (s *Safe) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	handle := s.Handle(r)
+	if handle == "" {
+		http.Redirect(w, r, fmt.Sprintf("%v/login?next=%v", s.serverName, r.URL.Path), http.StatusSeeOther)
+		return
+	}
+	entries, err := s.History(handle)
+	if r.URL.Path == "/history.json" {
+		if err != nil {
+			log.Printf("could not export history of %v: %v", handle, err)
+			http.Error(w, Translate(s.Language(r), "history.failed"), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", handle+"-history.json"))
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(HistoryResponse{Handle: handle, Entries: entries})
+		return
+	}
+	view := HistoryView{Handle: handle, Entries: entries}
+	if err != nil {
+		log.Printf("could not load history of %v: %v", handle, err)
+		view.Error = Translate(s.Language(r), "history.failed")
+	}
+	s.render(w, r, "history.html", view)
+}
@@ -0,0 +1,290 @@
+package safe
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template/parse"
+	"time"
+)
+
+// Languages of the web pages and of the messages of the REST API.
+const (
+	LanguageEnglish    = "en"
+	LanguagePortuguese = "pt-BR"
+)
+
+// DefaultLanguage is used when neither the user nor the browser ask for a
+// known language. Its catalog is the reference: every key has a message.
+const DefaultLanguage = LanguageEnglish
+
+// Languages lists the languages with a catalog in order of preference.
+var Languages = []string{LanguageEnglish, LanguagePortuguese}
+
+// catalogs holds the messages of each language by key. Messages are fmt
+// formats of their arguments.
+var catalogs = map[string]map[string]string{
+	LanguageEnglish:    messagesEnglish,
+	LanguagePortuguese: messagesPortuguese,
+}
+
+// Translate returns the message of key in language formatted with args.
+// Messages missing in language fall back to English and then to the key.
+func Translate(language, key string, args ...any) string {
+	message, ok := catalogs[language][key]
+	if !ok {
+		message, ok = catalogs[DefaultLanguage][key]
+	}
+	if !ok {
+		message = key
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// translateHTML is Translate for messages carrying markup. The arguments are
+// escaped, the message itself is trusted.
+func translateHTML(language, key string, args ...any) template.HTML {
+	escaped := make([]any, len(args))
+	for n, arg := range args {
+		escaped[n] = template.HTMLEscapeString(fmt.Sprint(arg))
+	}
+	return template.HTML(Translate(language, key, escaped...))
+}
+
+// translateError returns the message of the errors shown to users in
+// language, or the error itself if it has no translation.
+func translateError(language string, err error) string {
+	var handleErr *HandleError
+	var throttleErr *ThrottleError
+	switch {
+	case errors.As(err, &handleErr):
+		return Translate(language, handleErr.key, handleErr.args...)
+	case errors.As(err, &throttleErr):
+		if throttleErr.Locked {
+			return Translate(language, "error.locked", throttleErr.RetryAfter.Round(time.Second))
+		}
+		return Translate(language, "error.throttled", throttleErr.RetryAfter.Round(time.Second))
+	case errors.Is(err, ErrInvalidCredentials):
+		return Translate(language, "error.credentials")
+	case errors.Is(err, ErrSecondFactor):
+		return Translate(language, "error.second_factor")
+	case errors.Is(err, ErrPasswordTooShort):
+		return Translate(language, "error.password_too_short", minPasswordLength)
+	case errors.Is(err, ErrPasswordMismatch):
+		return Translate(language, "error.password_mismatch")
+	case errors.Is(err, ErrPasswordUnchanged):
+		return Translate(language, "error.password_unchanged")
+	case errors.Is(err, ErrEmailInvalid):
+		return Translate(language, "error.email_invalid")
+	case errors.Is(err, ErrCurrentPassword):
+		return Translate(language, "error.current_password")
+	case errors.Is(err, ErrSessionsNotEnded):
+		return Translate(language, "error.sessions_not_ended")
+	case errors.Is(err, ErrExpiryPast):
+		return Translate(language, "error.expiry_past")
+	case errors.Is(err, ErrExpiryEpochs):
+		return Translate(language, "error.expiry_epochs")
+	case errors.Is(err, ErrExpiryDate):
+		return Translate(language, "error.expiry_date")
+	case errors.Is(err, ErrAttorneyToken):
+		return Translate(language, "error.attorney_token")
+	case errors.Is(err, ErrAccountFrozen):
+		return Translate(language, "error.account_frozen")
+	case errors.Is(err, ErrNotSent):
+		return Translate(language, "error.not_sent")
+	case errors.Is(err, ErrTwoFactorEnabled):
+		return Translate(language, "error.totp_enabled")
+	case errors.Is(err, ErrTwoFactorDisabled):
+		return Translate(language, "error.totp_disabled")
+	case errors.Is(err, ErrNoEnrollment):
+		return Translate(language, "error.totp_no_enrollment")
+	case errors.Is(err, ErrPasskeyInvalid):
+		return Translate(language, "error.passkey_invalid")
+	case errors.Is(err, ErrPasskeyExpired):
+		return Translate(language, "error.passkey_expired")
+	case errors.Is(err, ErrPasskeyRegistered):
+		return Translate(language, "error.passkey_registered")
+	case errors.Is(err, ErrPasskeyUnknown):
+		return Translate(language, "error.passkey_unknown")
+	case errors.Is(err, ErrAppToken):
+		return Translate(language, "error.app_header", HeaderAppToken)
+	case errors.Is(err, ErrAppTimestamp):
+		return Translate(language, "error.app_header", HeaderAppTimestamp)
+	case errors.Is(err, ErrAppNonce):
+		return Translate(language, "error.app_header", HeaderAppNonce)
+	case errors.Is(err, ErrAppSignature):
+		return Translate(language, "error.app_header", HeaderAppSignature)
+	case errors.Is(err, ErrAppReplay):
+		return Translate(language, "error.app_replay")
+	case errors.Is(err, ErrAppBusy):
+		return Translate(language, "error.app_busy")
+	case errors.Is(err, ErrAppWindow):
+		return Translate(language, "error.app_window")
+	case errors.Is(err, ErrWebhookURL):
+		return Translate(language, "error.webhook_url")
+	case errors.Is(err, ErrWebhookHost):
+		return Translate(language, "error.webhook_host")
+	case errors.Is(err, ErrWebhookAddress):
+		return Translate(language, "error.webhook_address")
+	}
+	return err.Error()
+}
+
+// matchLanguage returns the language with a catalog for a language tag,
+// matching only the primary subtag if no region matches.
+func matchLanguage(tag string) string {
+	tag = strings.TrimSpace(tag)
+	for _, language := range Languages {
+		if strings.EqualFold(tag, language) {
+			return language
+		}
+	}
+	primary, _, _ := strings.Cut(tag, "-")
+	for _, language := range Languages {
+		if other, _, _ := strings.Cut(language, "-"); strings.EqualFold(primary, other) {
+			return language
+		}
+	}
+	return ""
+}
+
+// acceptLanguage returns the language with a catalog preferred by an
+// Accept-Language header, if any.
+func acceptLanguage(header string) string {
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if language := matchLanguage(tag); language != "" && quality > bestQuality {
+			best, bestQuality = language, quality
+		}
+	}
+	return best
+}
+
+// Language returns the language of the response to r: the one chosen by the
+// logged in user, otherwise the one asked by the Accept-Language header.
+func (s *Safe) Language(r *http.Request) string {
+	if handle := s.Handle(r); handle != "" {
+		if language := s.vault.Language(handle); language != "" {
+			return language
+		}
+	}
+	if language := acceptLanguage(r.Header.Get("Accept-Language")); language != "" {
+		return language
+	}
+	return DefaultLanguage
+}
+
+// SetLanguage records the language chosen by handle. An empty language
+// follows the browser again.
+func (s *Safe) SetLanguage(handle, language string) error {
+	if language != "" {
+		if language = matchLanguage(language); language == "" {
+			return errors.New("unknown language")
+		}
+	}
+	return s.vault.SetLanguage(handle, language)
+}
+
+// MissingTranslations lists the keys of the English catalog missing in the
+// other catalogs and the keys used by the templates in assets that are not
+// in the English catalog. Keys built at run time are not seen.
+func MissingTranslations(assets fs.FS) ([]string, error) {
+	missing := make([]string, 0)
+	for _, language := range Languages {
+		for key := range catalogs[DefaultLanguage] {
+			if _, ok := catalogs[language][key]; !ok {
+				missing = append(missing, fmt.Sprintf("%v: %v", language, key))
+			}
+		}
+	}
+	templates, err := parseTemplates(assets, DefaultLanguage)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range templateKeys(templates) {
+		if _, ok := catalogs[DefaultLanguage][key]; !ok {
+			missing = append(missing, fmt.Sprintf("%v: %v", DefaultLanguage, key))
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// templateKeys returns the literal keys given to t and th in templates.
+func templateKeys(templates *template.Template) []string {
+	found := make(map[string]struct{})
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, command := range n.Cmds {
+				walk(command)
+			}
+		case *parse.CommandNode:
+			if len(n.Args) > 1 {
+				if function, ok := n.Args[0].(*parse.IdentifierNode); ok && (function.Ident == "t" || function.Ident == "th") {
+					if key, ok := n.Args[1].(*parse.StringNode); ok {
+						found[key.Text] = struct{}{}
+					}
+				}
+			}
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		}
+	}
+	for _, t := range templates.Templates() {
+		if t.Tree != nil {
+			walk(t.Tree.Root)
+		}
+	}
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,53 @@
+package safe
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMissingTranslations(t *testing.T) {
+	missing, err := MissingTranslations(embeddedAssets)
+	if err != nil {
+		t.Fatalf("MissingTranslations: %v", err)
+	}
+	for _, key := range missing {
+		t.Errorf("missing translation %v", key)
+	}
+}
+
+// TestSourceKeys checks the literal keys given to Translate and
+// translateHTML by the code, which MissingTranslations does not see.
+func TestSourceKeys(t *testing.T) {
+	files := token.NewFileSet()
+	packages, err := parser.ParseDir(files, ".", func(info fs.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatalf("could not parse package: %v", err)
+	}
+	for _, file := range packages["safe"].Files {
+		ast.Inspect(file, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok || len(call.Args) < 2 {
+				return true
+			}
+			if name, ok := call.Fun.(*ast.Ident); !ok || (name.Name != "Translate" && name.Name != "translateHTML") {
+				return true
+			}
+			literal, ok := call.Args[1].(*ast.BasicLit)
+			if !ok || literal.Kind != token.STRING {
+				return true
+			}
+			key, _ := strconv.Unquote(literal.Value)
+			if _, ok := catalogs[DefaultLanguage][key]; !ok {
+				t.Errorf("%v: key %v is not in the catalog", files.Position(literal.Pos()), key)
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package safe
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+	HeaderReplayed       = "Idempotent-Replayed"
+)
+
+const (
+	idempotencyWindow = 24 * time.Hour
+	maxIdempotencyKey = 255
+	// maxIdempotentOutcomes bounds the outcomes kept per app. The oldest
+	// are forgotten first.
+	maxIdempotentOutcomes = 1000
+	// maxIdempotentTotal bounds the outcomes kept for all apps. Requests
+	// with new keys are refused while the store is full.
+	maxIdempotentTotal = 100000
+	// idempotencySweep is the interval between sweeps of expired outcomes.
+	idempotencySweep = time.Minute
+)
+
+type idempotentOutcome struct {
+	request [sha256.Size]byte
+	done    bool
+	status  int
+	header  http.Header
+	body    []byte
+	created time.Time
+	expires time.Time
+}
+
+type idempotencyKey struct {
+	app string
+	key string
+}
+
+// IdempotencyStore keeps the first outcome of requests carrying an
+// idempotency key, per app and key, for idempotencyWindow and up to
+// maxIdempotentOutcomes per app and maxIdempotentTotal overall.
+type IdempotencyStore struct {
+	mu       sync.Mutex
+	outcomes map[idempotencyKey]*idempotentOutcome
+	count    map[string]int
+}
+
+func NewIdempotencyStore() *IdempotencyStore {
+	return &IdempotencyStore{
+		outcomes: make(map[idempotencyKey]*idempotentOutcome),
+		count:    make(map[string]int),
+	}
+}
+
+func (s *IdempotencyStore) remove(id idempotencyKey) {
+	if _, ok := s.outcomes[id]; !ok {
+		return
+	}
+	delete(s.outcomes, id)
+	if s.count[id.app]--; s.count[id.app] <= 0 {
+		delete(s.count, id.app)
+	}
+}
+
+// Sweep forgets the outcomes expired at now. Requests still in progress are
+// kept.
+func (s *IdempotencyStore) Sweep(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, outcome := range s.outcomes {
+		if outcome.done && now.After(outcome.expires) {
+			s.remove(id)
+		}
+	}
+}
+
+// SweepEvery sweeps the store every interval until ctx is done.
+func (s *IdempotencyStore) SweepEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.Sweep(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// idempotentRequest hashes what must not change between the requests using
+// the same key: method, path, query and body.
+func idempotentRequest(r *http.Request, body []byte) [sha256.Size]byte {
+	return sha256.Sum256(append([]byte(fmt.Sprintf("%v %v?%v\n", r.Method, r.URL.Path, r.URL.RawQuery)), body...))
+}
+
+// add stores outcome for id, forgetting the oldest outcome of the app if it
+// already has maxIdempotentOutcomes.
+func (s *IdempotencyStore) add(id idempotencyKey, outcome *idempotentOutcome) {
+	if s.count[id.app] >= maxIdempotentOutcomes {
+		var oldest idempotencyKey
+		var oldestTime time.Time
+		for other, stored := range s.outcomes {
+			if other.app == id.app && (oldestTime.IsZero() || stored.created.Before(oldestTime)) {
+				oldest, oldestTime = other, stored.created
+			}
+		}
+		s.remove(oldest)
+	}
+	s.outcomes[id] = outcome
+	s.count[id.app]++
+}
+
+// idempotent replays the stored outcome of next for requests with an
+// idempotency key already seen for the same app. Keys are only honored on
+// requests signed by an app, which is authenticated before anything is
+// replayed. Server errors are not stored so that they can be retried.
+func (rest *RestAPI) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderIdempotencyKey)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		language := rest.Safe.Language(r)
+		if len(key) > maxIdempotencyKey {
+			writeError(w, http.StatusBadRequest, ErrInvalidIdempotency, Translate(language, "api.idempotency_too_long", maxIdempotencyKey))
+			return
+		}
+		app, ok := rest.authenticateApp(w, r)
+		if !ok {
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrInvalidJSON, Translate(language, "api.unreadable_body"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		id := idempotencyKey{app: app.Hex(), key: key}
+		request := idempotentRequest(r, body)
+
+		store := rest.Safe.idempotency
+		store.mu.Lock()
+		outcome, ok := store.outcomes[id]
+		if ok {
+			store.mu.Unlock()
+			switch {
+			case outcome.request != request:
+				writeError(w, http.StatusConflict, ErrIdempotencyConflict, Translate(language, "api.idempotency_conflict"))
+			case !outcome.done:
+				writeError(w, http.StatusConflict, ErrIdempotencyInProgress, Translate(language, "api.idempotency_in_progress"))
+			default:
+				for header, values := range outcome.header {
+					w.Header()[header] = values
+				}
+				w.Header().Set(HeaderReplayed, "true")
+				w.WriteHeader(outcome.status)
+				w.Write(outcome.body)
+			}
+			return
+		}
+		if len(store.outcomes) >= maxIdempotentTotal && store.count[id.app] < maxIdempotentOutcomes {
+			store.mu.Unlock()
+			writeError(w, http.StatusServiceUnavailable, ErrBusy, Translate(language, "api.busy"))
+			return
+		}
+		outcome = &idempotentOutcome{request: request, created: time.Now()}
+		store.add(id, outcome)
+		store.mu.Unlock()
+
+		buffered := newBufferedResponse()
+		next(buffered, r)
+		if buffered.status == 0 {
+			buffered.status = http.StatusOK
+		}
+		store.mu.Lock()
+		if buffered.status >= 500 {
+			if store.outcomes[id] == outcome {
+				store.remove(id)
+			}
+		} else {
+			outcome.done = true
+			outcome.status = buffered.status
+			outcome.header = buffered.header.Clone()
+			outcome.body = buffered.body.Bytes()
+			outcome.expires = time.Now().Add(idempotencyWindow)
+		}
+		store.mu.Unlock()
+		buffered.writeTo(w)
+	}
+}
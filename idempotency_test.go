@@ -0,0 +1,146 @@
+package safe
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// idempotentCall sends a request signed by key with an idempotency key to
+// handler.
+func idempotentCall(handler http.HandlerFunc, key crypto.PrivateKey, path, idempotency string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	r.Header.Set(HeaderIdempotencyKey, idempotency)
+	SignAppRequest(r, body, key)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func errorCode(w *httptest.ResponseRecorder) string {
+	var response ErrorResponse
+	json.NewDecoder(bytes.NewReader(w.Body.Bytes())).Decode(&response)
+	return response.Error.Code
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	rest := RestAPI{Safe: s}
+	var calls atomic.Int32
+	handler := rest.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusCreated, map[string]int32{"call": calls.Add(1)})
+	})
+	_, key := crypto.RandomAsymetricKey()
+	first := idempotentCall(handler, key, "/v1/users", "key", []byte(`{"handle":"alice"}`))
+	second := idempotentCall(handler, key, "/v1/users", "key", []byte(`{"handle":"alice"}`))
+	if calls.Load() != 1 {
+		t.Fatalf("handler called %v times, want once", calls.Load())
+	}
+	if second.Code != first.Code || second.Body.String() != first.Body.String() || second.Header().Get(HeaderReplayed) != "true" {
+		t.Errorf("replay = %v %q, want %v %q", second.Code, second.Body, first.Code, first.Body)
+	}
+	// keys belong to each app
+	_, other := crypto.RandomAsymetricKey()
+	if w := idempotentCall(handler, other, "/v1/users", "key", []byte(`{"handle":"alice"}`)); w.Header().Get(HeaderReplayed) != "" {
+		t.Errorf("outcome of another app replayed")
+	}
+}
+
+func TestIdempotentConflict(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	rest := RestAPI{Safe: s}
+	handler := rest.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	_, key := crypto.RandomAsymetricKey()
+	idempotentCall(handler, key, "/v1/users", "key", []byte(`{"handle":"alice"}`))
+	for _, request := range []struct{ path, body string }{
+		{"/v1/users", `{"handle":"bob"}`},
+		{"/v1/users?handle=bob", `{"handle":"alice"}`},
+	} {
+		w := idempotentCall(handler, key, request.path, "key", []byte(request.body))
+		if w.Code != http.StatusConflict || errorCode(w) != ErrIdempotencyConflict {
+			t.Errorf("reuse with %v %v = %v %v", request.path, request.body, w.Code, errorCode(w))
+		}
+	}
+}
+
+func TestIdempotentInProgress(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	rest := RestAPI{Safe: s}
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := rest.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+	_, key := crypto.RandomAsymetricKey()
+	body := []byte(`{"handle":"alice"}`)
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- idempotentCall(handler, key, "/v1/users", "key", body) }()
+	<-started
+	if w := idempotentCall(handler, key, "/v1/users", "key", body); w.Code != http.StatusConflict || errorCode(w) != ErrIdempotencyInProgress {
+		t.Errorf("request in progress = %v %v", w.Code, errorCode(w))
+	}
+	close(release)
+	if w := <-done; w.Code != http.StatusCreated {
+		t.Errorf("first request = %v", w.Code)
+	}
+	if w := idempotentCall(handler, key, "/v1/users", "key", body); w.Header().Get(HeaderReplayed) != "true" {
+		t.Errorf("finished request not replayed: %v", w.Code)
+	}
+}
+
+func TestIdempotentServerError(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	rest := RestAPI{Safe: s}
+	var calls atomic.Int32
+	handler := rest.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	_, key := crypto.RandomAsymetricKey()
+	idempotentCall(handler, key, "/v1/users", "key", nil)
+	idempotentCall(handler, key, "/v1/users", "key", nil)
+	if calls.Load() != 2 {
+		t.Errorf("handler called %v times, want server errors retried", calls.Load())
+	}
+}
+
+func TestIdempotencyStoreBounds(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	rest := RestAPI{Safe: s}
+	handler := rest.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	store := s.idempotency
+	now := time.Now()
+	store.mu.Lock()
+	store.add(idempotencyKey{app: "expired", key: "key"}, &idempotentOutcome{done: true, created: now, expires: now.Add(-time.Second)})
+	store.add(idempotencyKey{app: "running", key: "key"}, &idempotentOutcome{created: now})
+	store.mu.Unlock()
+	store.Sweep(now)
+	if _, ok := store.outcomes[idempotencyKey{app: "expired", key: "key"}]; ok {
+		t.Errorf("expired outcome kept by Sweep")
+	}
+	if _, ok := store.outcomes[idempotencyKey{app: "running", key: "key"}]; !ok {
+		t.Errorf("request in progress forgotten by Sweep")
+	}
+
+	store.mu.Lock()
+	for n := len(store.outcomes); n < maxIdempotentTotal; n++ {
+		store.outcomes[idempotencyKey{app: "filler", key: strconv.Itoa(n)}] = &idempotentOutcome{created: now}
+	}
+	store.mu.Unlock()
+	_, key := crypto.RandomAsymetricKey()
+	if w := idempotentCall(handler, key, "/v1/users", "key", nil); w.Code != http.StatusServiceUnavailable || errorCode(w) != ErrBusy {
+		t.Errorf("new key on a full store = %v %v", w.Code, errorCode(w))
+	}
+}
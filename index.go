@@ -0,0 +1,208 @@
+package safe
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+// Grantor is a user of the safe that granted power of attorney at Epoch.
+type Grantor struct {
+	Handle string `json:"handle"`
+	Epoch  uint64 `json:"epoch"`
+}
+
+// AttorneyIndex is the reverse of User.Attorneys: for each attorney token
+// it keeps the users that granted it power of attorney. It is kept up to
+// date by ingestion.
+type AttorneyIndex struct {
+	mu       sync.Mutex
+	grantors map[crypto.Token]map[string]uint64
+}
+
+func NewAttorneyIndex() *AttorneyIndex {
+	return &AttorneyIndex{grantors: make(map[crypto.Token]map[string]uint64)}
+}
+
+func (a *AttorneyIndex) Grant(attorney crypto.Token, handle string, epoch uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.grantors[attorney]; !ok {
+		a.grantors[attorney] = make(map[string]uint64)
+	}
+	if _, ok := a.grantors[attorney][handle]; !ok {
+		a.grantors[attorney][handle] = epoch
+	}
+}
+
+func (a *AttorneyIndex) Revoke(attorney crypto.Token, handle string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.grantors[attorney], handle)
+	if len(a.grantors[attorney]) == 0 {
+		delete(a.grantors, attorney)
+	}
+}
+
+// Granted tells whether some user granted power to attorney.
+func (a *AttorneyIndex) Granted(attorney crypto.Token) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.grantors[attorney]) > 0
+}
+
+// Grantors returns the users that granted power to attorney with grant
+// epoch within [from, to] (to equal to zero means no upper bound), ordered
+// by epoch and handle, together with the total number of matches before
+// pagination.
+func (a *AttorneyIndex) Grantors(attorney crypto.Token, from, to uint64, offset, limit int) ([]Grantor, int) {
+	a.mu.Lock()
+	grantors := make([]Grantor, 0)
+	for handle, epoch := range a.grantors[attorney] {
+		if epoch >= from && (to == 0 || epoch <= to) {
+			grantors = append(grantors, Grantor{Handle: handle, Epoch: epoch})
+		}
+	}
+	a.mu.Unlock()
+	sort.Slice(grantors, func(i, j int) bool {
+		if grantors[i].Epoch != grantors[j].Epoch {
+			return grantors[i].Epoch < grantors[j].Epoch
+		}
+		return grantors[i].Handle < grantors[j].Handle
+	})
+	total := len(grantors)
+	if offset < 0 || limit <= 0 || offset >= total {
+		return []Grantor{}, total
+	}
+	// limit is compared with what is left so that offset+limit cannot
+	// overflow
+	end := total
+	if limit < total-offset {
+		end = offset + limit
+	}
+	return grantors[offset:end], total
+}
+
+// NetworkIndex keeps every handle that joined the network on chain, so that
+// handles already taken by other safes or wallets are refused at sign up.
+// It is kept up to date by ingestion. Joins are public, so they are kept in
+// a plain file of their own instead of the vault: each join is appended as
+// a two byte little endian length followed by the serialized NetworkHandle.
+type NetworkIndex struct {
+	mu   sync.Mutex
+	file *os.File
+	// handles joined on chain by exact handle, and the first of them for
+	// each lower cased handle
+	network map[string]NetworkHandle
+	folded  map[string]NetworkHandle
+}
+
+func OpenNetworkIndex(path string) (*NetworkIndex, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	index := NetworkIndex{
+		file:    file,
+		network: make(map[string]NetworkHandle),
+		folded:  make(map[string]NetworkHandle),
+	}
+	offset := int64(0)
+	lengthBytes := make([]byte, 2)
+	for {
+		n, err := file.ReadAt(lengthBytes, offset)
+		if n == 0 && err == io.EOF {
+			return &index, nil
+		}
+		if n != 2 {
+			return nil, fmt.Errorf("could not read join length on file at position %d: %v", offset, err)
+		}
+		length := int(lengthBytes[0]) | int(lengthBytes[1])<<8
+		data := make([]byte, length)
+		if n, err = file.ReadAt(data, offset+2); n != length {
+			return nil, fmt.Errorf("could not read join at position %d: %v", offset+2, err)
+		}
+		if network, ok := ParseNetworkHandle(data); ok {
+			index.put(network)
+		}
+		offset += int64(2 + length)
+	}
+}
+
+func (n *NetworkIndex) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.file.Close()
+}
+
+func (n *NetworkIndex) put(network NetworkHandle) {
+	n.network[network.Handle] = network
+	key := strings.ToLower(network.Handle)
+	if _, ok := n.folded[key]; !ok {
+		n.folded[key] = network
+	}
+}
+
+// Join records the handle of a join seen on chain. Only the first join of a
+// handle is kept, later ones are rejected by the network. Handles differing
+// only in case are distinct and all kept.
+func (n *NetworkIndex) Join(handle string, token crypto.Token, epoch uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.network[handle]; ok {
+		return
+	}
+	network := NetworkHandle{Handle: handle, Token: token, Epoch: epoch}
+	data := network.Serialize()
+	if len(data) > 1<<16-1 {
+		log.Printf("could not index network handle %v: join too large", handle)
+		return
+	}
+	bytes := make([]byte, 0, len(data)+2)
+	util.PutUint16(uint16(len(data)), &bytes)
+	bytes = append(bytes, data...)
+	if _, err := n.file.Seek(0, io.SeekEnd); err != nil {
+		log.Printf("could not index network handle %v: %v", handle, err)
+		return
+	}
+	if _, err := n.file.Write(bytes); err != nil {
+		log.Printf("could not index network handle %v: %v", handle, err)
+		return
+	}
+	n.put(network)
+}
+
+// Handle returns the join of handle.
+func (n *NetworkIndex) Handle(handle string) (NetworkHandle, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	network, ok := n.network[handle]
+	return network, ok
+}
+
+// Folded returns the first join of a handle differing from handle at most
+// in case.
+func (n *NetworkIndex) Folded(handle string) (NetworkHandle, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	network, ok := n.folded[strings.ToLower(handle)]
+	return network, ok
+}
+
+// Taken tells whether handle joined the network. With fold handles
+// differing only in case are taken as well.
+func (n *NetworkIndex) Taken(handle string, fold bool) bool {
+	if fold {
+		_, ok := n.Folded(handle)
+		return ok
+	}
+	_, ok := n.Handle(handle)
+	return ok
+}
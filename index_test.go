@@ -0,0 +1,73 @@
+package safe
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func TestGrantorsPagination(t *testing.T) {
+	index := NewAttorneyIndex()
+	attorney, _ := crypto.RandomAsymetricKey()
+	for n, handle := range []string{"alice", "bob", "carol"} {
+		index.Grant(attorney, handle, uint64(n+1))
+	}
+	cases := []struct {
+		offset, limit int
+		want          int
+	}{
+		{0, 2, 2},
+		{2, 2, 1},
+		{3, 2, 0},
+		{1, math.MaxInt, 2},
+		{math.MaxInt, math.MaxInt, 0},
+		{-1, 2, 0},
+		{0, 0, 0},
+	}
+	for _, c := range cases {
+		users, total := index.Grantors(attorney, 0, 0, c.offset, c.limit)
+		if len(users) != c.want || total != 3 {
+			t.Errorf("Grantors(offset %v, limit %v) = %v users of %v, want %v of 3", c.offset, c.limit, len(users), total, c.want)
+		}
+	}
+}
+
+func TestNetworkHandleCase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "network.dat")
+	index, err := OpenNetworkIndex(path)
+	if err != nil {
+		t.Fatalf("OpenNetworkIndex: %v", err)
+	}
+	alice, _ := crypto.RandomAsymetricKey()
+	other, _ := crypto.RandomAsymetricKey()
+	index.Join("Alice", alice, 1)
+	if index.Taken("alice", false) {
+		t.Error("alice taken by Alice without folding")
+	}
+	if !index.Taken("alice", true) {
+		t.Error("alice not taken by Alice with folding")
+	}
+	index.Join("alice", other, 2)
+	if !index.Taken("alice", false) {
+		t.Error("join of alice after Alice was not kept")
+	}
+	if !index.Taken("Alice", false) {
+		t.Error("join of Alice was lost")
+	}
+	// a later join of a taken handle is rejected by the network
+	index.Join("alice", alice, 3)
+	index.Close()
+
+	if index, err = OpenNetworkIndex(path); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer index.Close()
+	if network, ok := index.Handle("alice"); !ok || !network.Token.Equal(other) || network.Epoch != 2 {
+		t.Errorf("Handle(alice) = %v, %v, want the first join of alice", network, ok)
+	}
+	if network, ok := index.Folded("ALICE"); !ok || !network.Token.Equal(alice) {
+		t.Errorf("Folded(ALICE) = %v, %v, want the first join", network, ok)
+	}
+}
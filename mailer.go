@@ -0,0 +1,68 @@
+package safe
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mailer sends notifications to the email of the users.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// LogMailer only logs the messages. It is used when no mailer is
+// configured.
+type LogMailer struct{}
+
+func (LogMailer) Send(to, subject, body string) error {
+	log.Printf("mail to %v: %v", to, subject)
+	return nil
+}
+
+// SMTPMailer sends plain text messages through an SMTP server with PLAIN
+// authentication if Username is set.
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (m SMTPMailer) Send(to, subject, body string) error {
+	// header injection through user provided addresses
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid mail header")
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	message := fmt.Sprintf("From: %v\r\nTo: %v\r\nSubject: %v\r\nDate: %v\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%v\r\n",
+		m.From, to, subject, time.Now().Format(time.RFC1123Z), body)
+	return smtp.SendMail(m.Addr, auth, m.From, []string{to}, []byte(message))
+}
+
+// notify mails handle in the background if it has an email.
+func (s *Safe) notify(handle, subject, body string) {
+	s.mail(s.Email(handle), subject, body)
+}
+
+// mail sends a message in the background. Empty addresses are ignored.
+func (s *Safe) mail(to, subject, body string) {
+	if to == "" {
+		return
+	}
+	go func() {
+		if err := s.mailer.Send(to, subject, body); err != nil {
+			log.Printf("could not mail %v: %v", to, err)
+		}
+	}()
+}
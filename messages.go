@@ -0,0 +1,485 @@
+package safe
+
+// messagesEnglish is the reference catalog. Keys are grouped by the page or
+// the API they belong to.
+var messagesEnglish = map[string]string{
+	"language.en":    "English",
+	"language.pt-BR": "Português (Brasil)",
+
+	"nav.login":     "log in",
+	"nav.signin":    "sign in",
+	"nav.logout":    "log out",
+	"nav.history":   "history",
+	"nav.settings":  "settings",
+	"nav.twofactor": "two-factor",
+	"nav.passkeys":  "passkeys",
+	"nav.export":    "export",
+
+	"form.handle":      "handle",
+	"form.email":       "email",
+	"form.password":    "password",
+	"form.repassword":  "confirm password",
+	"form.code":        "code",
+	"form.totp":        "code from your authenticator app",
+	"form.send":        "send",
+	"form.grant":       "grant",
+	"form.allow":       "allow",
+	"form.approve":     "approve",
+	"form.deny":        "deny",
+	"form.attorney":    "attorney",
+	"form.fingerprint": "finger print",
+	"form.scopes":      "scopes",
+	"form.share":       "share with the app",
+	"form.expires_in":  "expires after epochs",
+	"form.expires_at":  "or at",
+
+	"scope.email":   "email",
+	"scope.profile": "profile",
+
+	"method.web":     "granted on the safe page",
+	"method.rest":    "granted through the REST API",
+	"method.confirm": "granted on a confirmation link",
+	"method.oidc":    "granted on sign in with the safe",
+	"method.network": "granted outside this safe",
+
+	"expiry.time":  "expires %v",
+	"expiry.epoch": "expires at epoch %v",
+	"expiry.both":  "expires at epoch %v or %v",
+
+	"app.unverified": "unverified",
+	"app.claims":     "claims to be \"%v\"",
+
+	"handle.required":         "Handle is required",
+	"handle.characters":       "Handle may only contain the characters %v",
+	"handle.edges":            "Handle must start and end with a letter or digit",
+	"handle.too_short":        "Handle must have at least %v characters",
+	"handle.too_long":         "Handle must have at most %v characters",
+	"handle.reserved":         "Handle is reserved",
+	"handle.blocked":          "Handle is not allowed",
+	"handle.policy":           "%v characters among %v, starting and ending with a letter or digit",
+	"handle.policy_unbounded": "characters among %v, starting and ending with a letter or digit",
+	"handle.length_range":     "%v to %v",
+	"handle.length_max":       "up to %v",
+	"handle.length_min":       "at least %v",
+
+	"error.credentials":        "invalid handle or password",
+	"error.current_password":   "current password does not match",
+	"error.second_factor":      "invalid or missing second factor code",
+	"error.throttled":          "too many failed attempts, try again in %v",
+	"error.locked":             "account locked after too many failed attempts, try again in %v",
+	"error.password_too_short": "password must have at least %v characters",
+	"error.password_mismatch":  "passwords do not match",
+	"error.password_unchanged": "new password must differ from the current one",
+	"error.email_invalid":      "invalid email address",
+	"error.sessions_not_ended": "password changed but other sessions could not be logged out",
+	"error.expiry_past":        "expiry must be in the future",
+	"error.expiry_epochs":      "invalid number of epochs",
+	"error.expiry_date":        "invalid expiry date",
+	"error.user_not_found":     "user not found",
+	"error.attorney_token":     "attorney must be a token of 64 hexadecimal characters",
+	"error.account_frozen":     "account frozen, unfreeze it before granting",
+	"error.not_sent":           "could not send the action to the network, try again later",
+	"error.totp_enabled":       "two-factor authentication already enabled",
+	"error.totp_disabled":      "two-factor authentication not enabled",
+	"error.totp_no_enrollment": "no pending enrollment, enable two-factor authentication again",
+	"error.passkey_invalid":    "the passkey response could not be verified",
+	"error.passkey_expired":    "unknown or expired passkey request, try again",
+	"error.passkey_registered": "passkey already registered",
+	"error.passkey_unknown":    "unknown passkey",
+	"error.app_header":         "missing or invalid %v header",
+	"error.app_window":         "request timestamp out of window",
+	"error.app_replay":         "request already received",
+	"error.app_busy":           "too many signed requests, try again later",
+	"error.webhook_url":        "invalid webhook url",
+	"error.webhook_host":       "could not resolve the webhook host",
+	"error.webhook_address":    "webhook url must resolve to public addresses",
+
+	"flash.handle_missing":   "enter your handle",
+	"flash.password_missing": "enter your password",
+	"flash.handle_taken":     "handle already taken",
+	"flash.user_created":     "%v created, log in to continue",
+	"flash.create_failed":    "could not create the user, try again later",
+	"flash.attorney_invalid": "attorney must be a token of 64 hexadecimal characters",
+	"flash.grant_sent":       "grant sent, it takes effect once on chain",
+	"flash.grant_failed":     "could not grant: %v",
+	"flash.revoke_sent":      "revocation sent, it takes effect once on chain",
+	"flash.revoke_failed":    "could not revoke: %v",
+	"flash.scopes_failed":    "could not record scopes: %v",
+	"flash.expiry_failed":    "could not record expiry: %v",
+	"flash.frozen":           "every attorney revoked and account frozen, all sessions were logged out",
+	"flash.freeze_failed":    "could not revoke everything: %v",
+	"flash.unfrozen":         "account unfrozen",
+
+	"login.forgot":  "forgot my password",
+	"login.passkey": "log in with a passkey",
+
+	"signin.about": "Your handle is your identity on the network. The safe keeps its keys and signs on your behalf only what you authorize.",
+
+	"main.active":         "active",
+	"main.pending":        "pending",
+	"main.frozen":         "account frozen: no grant will be signed until you unfreeze it",
+	"main.unfreeze":       "unfreeze",
+	"main.attorneys":      "attorneys",
+	"main.at_epoch":       "at epoch %v",
+	"main.can_read":       "can read",
+	"main.fingerprint":    "fingerprint %v",
+	"main.revoke":         "revoke",
+	"main.no_attorneys":   "no attorneys",
+	"main.awaiting":       "awaiting confirmation",
+	"main.not_on_chain":   "%v at epoch %v, not yet on chain",
+	"main.add_attorney":   "add attorney",
+	"main.emergency":      "emergency",
+	"main.freeze":         "revoke everything and freeze",
+	"main.freeze_confirm": "Revoke every attorney, end all sessions and freeze the account?",
+
+	"confirm.title":      "grant power of attorney",
+	"confirm.claiming":   "an app claiming to be <span class=\"bold\">\"%v\"</span>",
+	"confirm.app":        "an app",
+	"confirm.wants":      "wants to act on behalf of <span class=\"bold\">%v</span>",
+	"confirm.unverified": "unverified: this token is not in the directory of known apps, grant only if you trust it",
+
+	"authorize.title":  "sign in with your handle",
+	"authorize.wants":  "<span class=\"bold\">%v</span> wants to sign you in as <span class=\"bold\">%v</span>",
+	"authorize.grants": "signing in also grants power of attorney to",
+
+	"challenge.title": "prove your handle",
+	"challenge.asks":  "an app asks you to prove that you control <span class=\"bold\">%v</span>. no power of attorney is granted.",
+	"challenge.app":   "app",
+	"challenge.nonce": "nonce",
+
+	"history.title":            "history",
+	"history.empty":            "nothing yet",
+	"history.epoch":            "epoch %v",
+	"history.action.join":      "join",
+	"history.action.grant":     "grant",
+	"history.action.revoke":    "revoke",
+	"history.status.confirmed": "confirmed",
+	"history.status.active":    "active",
+	"history.status.revoked":   "revoked",
+	"history.status.renewed":   "renewed",
+	"history.status.awaiting":  "awaiting confirmation",
+	"history.failed":           "could not load the history, try again later",
+
+	"settings.title":            "account settings",
+	"settings.current":          "current password",
+	"settings.new_password":     "new password",
+	"settings.confirm_password": "confirm new password",
+	"settings.change_password":  "change password",
+	"settings.change_email":     "change email",
+	"settings.password_changed": "password changed, other sessions were logged out",
+	"settings.email_changed":    "email changed",
+	"settings.language":         "language",
+	"settings.language_browser": "same as the browser",
+	"settings.change_language":  "change language",
+
+	"passkeys.title":         "passkeys",
+	"passkeys.added":         "added %v",
+	"passkeys.second_factor": "second factor after the password",
+	"passkeys.passwordless":  "sign in without password",
+	"passkeys.remove":        "remove",
+	"passkeys.empty":         "no passkeys",
+	"passkeys.add_title":     "add a passkey",
+	"passkeys.name":          "name",
+	"passkeys.name_hint":     "laptop, phone, security key",
+	"passkeys.require":       "require it after the password instead of signing in without password",
+	"passkeys.add":           "add",
+
+	"totp.title":         "two-factor authentication",
+	"totp.recovery":      "recovery codes",
+	"totp.recovery_hint": "each code can be used once instead of the authenticator app. keep them somewhere safe, they are not shown again.",
+	"totp.enabled":       "enabled, codes are required to log in, grant, revoke and change the password.",
+	"totp.recovery_left": "%v recovery codes left.",
+	"totp.new_recovery":  "new recovery codes",
+	"totp.disable":       "disable",
+	"totp.enroll_hint":   "add the account to your authenticator app by opening the link below on your phone or by typing the secret, then confirm with the code it shows.",
+	"totp.secret":        "secret",
+	"totp.confirm":       "confirm",
+	"totp.intro":         "protect your account with codes from an authenticator app.",
+	"totp.enable":        "enable",
+
+	"twofactor.passkey":      "use your passkey",
+	"twofactor.code":         "code from your authenticator app or a recovery code",
+	"twofactor.invalid_code": "invalid code",
+
+	"api.post_only":               "Only POST method is allowed",
+	"api.invalid_json":            "Invalid JSON format",
+	"api.invalid_handle":          "Invalid user handle",
+	"api.handle_unavailable":      "Handle unavailable",
+	"api.handle_joined":           "Handle already joined the network from another safe or wallet",
+	"api.user_exists":             "Handle already in use, request a grant instead",
+	"api.user_not_found":          "User not found",
+	"api.password_required":       "Password is required for a new user",
+	"api.create_failed":           "Failed to create user",
+	"api.grant_failed":            "User created but the power of attorney could not be requested: %v",
+	"api.user_created":            "User created, the power of attorney waits for the user consent at the verify url",
+	"api.frozen":                  "Account frozen by the user",
+	"api.invalid_attorney":        "Invalid attorney token",
+	"api.methods_only":            "Only %v allowed",
+	"api.method_only":             "Only %v method is allowed",
+	"api.not_found":               "Resource not found",
+	"api.json_only":               "Content-Type must be application/json",
+	"api.session_invalid":         "Missing or invalid session",
+	"api.session_other":           "Session does not belong to this user",
+	"api.session_failed":          "Could not create session",
+	"api.totp_header":             "Invalid or missing %v header",
+	"api.nothing_to_update":       "Provide a new password or email",
+	"api.frozen_grant":            "Account frozen, unfreeze it before granting",
+	"api.admin_required":          "Admin token required",
+	"api.invalid_token":           "Invalid token",
+	"api.directory_not_found":     "No admin entry for the token",
+	"api.attorney_only":           "Only the attorney can check its grants",
+	"api.pending_not_found":       "Pending request not found or already answered",
+	"api.webhook_not_found":       "Webhook not found",
+	"api.not_attorney":            "App is not an attorney of this user",
+	"api.invalid_nonce":           "Nonce must have between 1 and %v bytes and no control characters",
+	"api.challenge_not_found":     "Challenge not found or expired",
+	"api.own_users_only":          "Apps can only list their own users",
+	"api.invalid_query":           "offset, limit (1 to %v), from_epoch and to_epoch must be non negative integers",
+	"api.idempotency_too_long":    "Idempotency key must have at most %v characters",
+	"api.idempotency_conflict":    "Idempotency key reused with different parameters",
+	"api.idempotency_in_progress": "A request with this idempotency key is in progress",
+	"api.busy":                    "Too many pending requests, try again later",
+	"api.unreadable_body":         "Could not read body",
+	"api.passkey_login":           "Log in to register a passkey",
+}
+
+var messagesPortuguese = map[string]string{
+	"language.en":    "English",
+	"language.pt-BR": "Português (Brasil)",
+
+	"nav.login":     "entrar",
+	"nav.signin":    "criar conta",
+	"nav.logout":    "sair",
+	"nav.history":   "histórico",
+	"nav.settings":  "configurações",
+	"nav.twofactor": "dois fatores",
+	"nav.passkeys":  "chaves de acesso",
+	"nav.export":    "exportar",
+
+	"form.handle":      "handle",
+	"form.email":       "e-mail",
+	"form.password":    "senha",
+	"form.repassword":  "confirme a senha",
+	"form.code":        "código",
+	"form.totp":        "código do seu app autenticador",
+	"form.send":        "enviar",
+	"form.grant":       "conceder",
+	"form.allow":       "permitir",
+	"form.approve":     "aprovar",
+	"form.deny":        "recusar",
+	"form.attorney":    "procurador",
+	"form.fingerprint": "impressão digital",
+	"form.scopes":      "escopos",
+	"form.share":       "compartilhar com o app",
+	"form.expires_in":  "expira após épocas",
+	"form.expires_at":  "ou em",
+
+	"scope.email":   "e-mail",
+	"scope.profile": "perfil",
+
+	"method.web":     "concedida na página do safe",
+	"method.rest":    "concedida pela API REST",
+	"method.confirm": "concedida por um link de confirmação",
+	"method.oidc":    "concedida ao entrar com o safe",
+	"method.network": "concedida fora deste safe",
+
+	"expiry.time":  "expira em %v",
+	"expiry.epoch": "expira na época %v",
+	"expiry.both":  "expira na época %v ou em %v",
+
+	"app.unverified": "não verificado",
+	"app.claims":     "diz ser \"%v\"",
+
+	"handle.required":         "O handle é obrigatório",
+	"handle.characters":       "O handle só pode conter os caracteres %v",
+	"handle.edges":            "O handle deve começar e terminar com uma letra ou dígito",
+	"handle.too_short":        "O handle deve ter pelo menos %v caracteres",
+	"handle.too_long":         "O handle deve ter no máximo %v caracteres",
+	"handle.reserved":         "O handle é reservado",
+	"handle.blocked":          "O handle não é permitido",
+	"handle.policy":           "%v caracteres entre %v, começando e terminando com letra ou dígito",
+	"handle.policy_unbounded": "caracteres entre %v, começando e terminando com letra ou dígito",
+	"handle.length_range":     "de %v a %v",
+	"handle.length_max":       "até %v",
+	"handle.length_min":       "pelo menos %v",
+
+	"error.credentials":        "handle ou senha inválidos",
+	"error.current_password":   "a senha atual não confere",
+	"error.second_factor":      "código do segundo fator inválido ou ausente",
+	"error.throttled":          "muitas tentativas sem sucesso, tente novamente em %v",
+	"error.locked":             "conta bloqueada após muitas tentativas sem sucesso, tente novamente em %v",
+	"error.password_too_short": "a senha deve ter pelo menos %v caracteres",
+	"error.password_mismatch":  "as senhas não conferem",
+	"error.password_unchanged": "a nova senha deve ser diferente da atual",
+	"error.email_invalid":      "endereço de e-mail inválido",
+	"error.sessions_not_ended": "senha alterada mas não foi possível encerrar as outras sessões",
+	"error.expiry_past":        "a expiração deve ser no futuro",
+	"error.expiry_epochs":      "número de épocas inválido",
+	"error.expiry_date":        "data de expiração inválida",
+	"error.user_not_found":     "usuário não encontrado",
+	"error.attorney_token":     "o procurador deve ser um token de 64 caracteres hexadecimais",
+	"error.account_frozen":     "conta congelada, descongele-a antes de conceder",
+	"error.not_sent":           "não foi possível enviar a ação para a rede, tente novamente mais tarde",
+	"error.totp_enabled":       "autenticação em dois fatores já está ativada",
+	"error.totp_disabled":      "autenticação em dois fatores não está ativada",
+	"error.totp_no_enrollment": "nenhuma ativação pendente, ative a autenticação em dois fatores novamente",
+	"error.passkey_invalid":    "não foi possível verificar a resposta da passkey",
+	"error.passkey_expired":    "pedido de passkey desconhecido ou expirado, tente novamente",
+	"error.passkey_registered": "passkey já registrada",
+	"error.passkey_unknown":    "passkey desconhecida",
+	"error.app_header":         "cabeçalho %v ausente ou inválido",
+	"error.app_window":         "horário da requisição fora da janela aceita",
+	"error.app_replay":         "requisição já recebida",
+	"error.app_busy":           "requisições assinadas demais, tente novamente mais tarde",
+	"error.webhook_url":        "url de webhook inválida",
+	"error.webhook_host":       "não foi possível resolver o host do webhook",
+	"error.webhook_address":    "a url do webhook deve resolver para endereços públicos",
+
+	"flash.handle_missing":   "informe seu handle",
+	"flash.password_missing": "informe sua senha",
+	"flash.handle_taken":     "handle já está em uso",
+	"flash.user_created":     "%v criado, entre para continuar",
+	"flash.create_failed":    "não foi possível criar o usuário, tente novamente mais tarde",
+	"flash.attorney_invalid": "o procurador deve ser um token de 64 caracteres hexadecimais",
+	"flash.grant_sent":       "procuração enviada, vale assim que estiver na rede",
+	"flash.grant_failed":     "não foi possível conceder: %v",
+	"flash.revoke_sent":      "revogação enviada, vale assim que estiver na rede",
+	"flash.revoke_failed":    "não foi possível revogar: %v",
+	"flash.scopes_failed":    "não foi possível registrar os escopos: %v",
+	"flash.expiry_failed":    "não foi possível registrar a expiração: %v",
+	"flash.frozen":           "todos os procuradores revogados e conta congelada, todas as sessões foram encerradas",
+	"flash.freeze_failed":    "não foi possível revogar tudo: %v",
+	"flash.unfrozen":         "conta descongelada",
+
+	"login.forgot":  "esqueci minha senha",
+	"login.passkey": "entrar com uma chave de acesso",
+
+	"signin.about": "Seu handle é sua identidade na rede. O safe guarda as chaves e assina em seu nome apenas o que você autorizar.",
+
+	"main.active":         "ativo",
+	"main.pending":        "pendente",
+	"main.frozen":         "conta congelada: nenhuma procuração será assinada até você descongelá-la",
+	"main.unfreeze":       "descongelar",
+	"main.attorneys":      "procuradores",
+	"main.at_epoch":       "na época %v",
+	"main.can_read":       "pode ler",
+	"main.fingerprint":    "impressão digital %v",
+	"main.revoke":         "revogar",
+	"main.no_attorneys":   "nenhum procurador",
+	"main.awaiting":       "aguardando confirmação",
+	"main.not_on_chain":   "%v na época %v, ainda não está na rede",
+	"main.add_attorney":   "adicionar procurador",
+	"main.emergency":      "emergência",
+	"main.freeze":         "revogar tudo e congelar",
+	"main.freeze_confirm": "Revogar todos os procuradores, encerrar todas as sessões e congelar a conta?",
+
+	"confirm.title":      "conceder procuração",
+	"confirm.claiming":   "um app que diz ser <span class=\"bold\">\"%v\"</span>",
+	"confirm.app":        "um app",
+	"confirm.wants":      "quer agir em nome de <span class=\"bold\">%v</span>",
+	"confirm.unverified": "não verificado: este token não está no diretório de apps conhecidos, conceda apenas se confiar nele",
+
+	"authorize.title":  "entrar com seu handle",
+	"authorize.wants":  "<span class=\"bold\">%v</span> quer que você entre como <span class=\"bold\">%v</span>",
+	"authorize.grants": "entrar também concede procuração para",
+
+	"challenge.title": "comprove seu handle",
+	"challenge.asks":  "um app pede que você comprove que controla <span class=\"bold\">%v</span>. nenhuma procuração é concedida.",
+	"challenge.app":   "app",
+	"challenge.nonce": "nonce",
+
+	"history.title":            "histórico",
+	"history.empty":            "nada ainda",
+	"history.epoch":            "época %v",
+	"history.action.join":      "entrada na rede",
+	"history.action.grant":     "procuração",
+	"history.action.revoke":    "revogação",
+	"history.status.confirmed": "confirmada",
+	"history.status.active":    "ativa",
+	"history.status.revoked":   "revogada",
+	"history.status.renewed":   "renovada",
+	"history.status.awaiting":  "aguardando confirmação",
+	"history.failed":           "não foi possível carregar o histórico, tente novamente mais tarde",
+
+	"settings.title":            "configurações da conta",
+	"settings.current":          "senha atual",
+	"settings.new_password":     "nova senha",
+	"settings.confirm_password": "confirme a nova senha",
+	"settings.change_password":  "alterar senha",
+	"settings.change_email":     "alterar e-mail",
+	"settings.password_changed": "senha alterada, as outras sessões foram encerradas",
+	"settings.email_changed":    "e-mail alterado",
+	"settings.language":         "idioma",
+	"settings.language_browser": "o mesmo do navegador",
+	"settings.change_language":  "alterar idioma",
+
+	"passkeys.title":         "chaves de acesso",
+	"passkeys.added":         "adicionada em %v",
+	"passkeys.second_factor": "segundo fator após a senha",
+	"passkeys.passwordless":  "entrar sem senha",
+	"passkeys.remove":        "remover",
+	"passkeys.empty":         "nenhuma chave de acesso",
+	"passkeys.add_title":     "adicionar uma chave de acesso",
+	"passkeys.name":          "nome",
+	"passkeys.name_hint":     "notebook, celular, chave de segurança",
+	"passkeys.require":       "exigir após a senha em vez de entrar sem senha",
+	"passkeys.add":           "adicionar",
+
+	"totp.title":         "autenticação de dois fatores",
+	"totp.recovery":      "códigos de recuperação",
+	"totp.recovery_hint": "cada código pode ser usado uma vez no lugar do app autenticador. guarde-os em lugar seguro, eles não serão mostrados de novo.",
+	"totp.enabled":       "ativada, códigos são exigidos para entrar, conceder, revogar e alterar a senha.",
+	"totp.recovery_left": "restam %v códigos de recuperação.",
+	"totp.new_recovery":  "novos códigos de recuperação",
+	"totp.disable":       "desativar",
+	"totp.enroll_hint":   "adicione a conta ao seu app autenticador abrindo o link abaixo no celular ou digitando o segredo, depois confirme com o código exibido.",
+	"totp.secret":        "segredo",
+	"totp.confirm":       "confirmar",
+	"totp.intro":         "proteja sua conta com códigos de um app autenticador.",
+	"totp.enable":        "ativar",
+
+	"twofactor.passkey":      "usar sua chave de acesso",
+	"twofactor.code":         "código do seu app autenticador ou um código de recuperação",
+	"twofactor.invalid_code": "código inválido",
+
+	"api.post_only":               "Apenas o método POST é permitido",
+	"api.invalid_json":            "Formato JSON inválido",
+	"api.invalid_handle":          "Handle de usuário inválido",
+	"api.handle_unavailable":      "Handle indisponível",
+	"api.handle_joined":           "Handle já entrou na rede por outro safe ou carteira",
+	"api.user_exists":             "Handle já está em uso, solicite uma procuração",
+	"api.user_not_found":          "Usuário não encontrado",
+	"api.password_required":       "Senha não especificada para usuário novo",
+	"api.create_failed":           "Não foi possível criar o usuário",
+	"api.grant_failed":            "Usuário criado, mas não foi possível solicitar a procuração: %v",
+	"api.user_created":            "Usuário criado, a procuração aguarda o consentimento do usuário na url de verificação",
+	"api.frozen":                  "Conta congelada pelo usuário",
+	"api.invalid_attorney":        "Token de procurador inválido",
+	"api.methods_only":            "Apenas %v permitidos",
+	"api.method_only":             "Apenas o método %v é permitido",
+	"api.not_found":               "Recurso não encontrado",
+	"api.json_only":               "Content-Type deve ser application/json",
+	"api.session_invalid":         "Sessão ausente ou inválida",
+	"api.session_other":           "A sessão não pertence a este usuário",
+	"api.session_failed":          "Não foi possível criar a sessão",
+	"api.totp_header":             "Cabeçalho %v inválido ou ausente",
+	"api.nothing_to_update":       "Informe uma nova senha ou e-mail",
+	"api.frozen_grant":            "Conta congelada, descongele-a antes de conceder",
+	"api.admin_required":          "Token de administrador necessário",
+	"api.invalid_token":           "Token inválido",
+	"api.directory_not_found":     "Nenhuma entrada de administrador para o token",
+	"api.attorney_only":           "Apenas o procurador pode consultar suas procurações",
+	"api.pending_not_found":       "Pedido pendente não encontrado ou já respondido",
+	"api.webhook_not_found":       "Webhook não encontrado",
+	"api.not_attorney":            "O app não é procurador deste usuário",
+	"api.invalid_nonce":           "O nonce deve ter entre 1 e %v bytes e nenhum caractere de controle",
+	"api.challenge_not_found":     "Desafio não encontrado ou expirado",
+	"api.own_users_only":          "Apps só podem listar os próprios usuários",
+	"api.invalid_query":           "offset, limit (1 a %v), from_epoch e to_epoch devem ser inteiros não negativos",
+	"api.idempotency_too_long":    "A chave de idempotência deve ter no máximo %v caracteres",
+	"api.idempotency_conflict":    "Chave de idempotência reutilizada com parâmetros diferentes",
+	"api.idempotency_in_progress": "Uma requisição com esta chave de idempotência está em andamento",
+	"api.busy":                    "Requisições pendentes demais, tente novamente mais tarde",
+	"api.unreadable_body":         "Não foi possível ler o corpo",
+	"api.passkey_login":           "Entre para registrar uma passkey",
+}
@@ -0,0 +1,489 @@
+package safe
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+const (
+	oidcCodeTTL        = time.Minute
+	oidcAccessTokenTTL = time.Hour
+	oidcIDTokenTTL     = time.Hour
+	// expired codes and access tokens are swept at most every oidcSweep
+	oidcSweep = time.Minute
+)
+
+// OIDCClient is an app allowed to sign users in through the safe. Clients
+// are public and must use PKCE. If GrantOnToken is set, issuing tokens to the
+// client also grants power of attorney to the client Attorney token.
+type OIDCClient struct {
+	ID           string
+	Name         string
+	RedirectURIs []string
+	Attorney     string
+	GrantOnToken bool
+}
+
+func (c *OIDCClient) allowedRedirect(uri string) bool {
+	for _, allowed := range c.RedirectURIs {
+		if allowed == uri {
+			return true
+		}
+	}
+	return false
+}
+
+type authorizationCode struct {
+	client        *OIDCClient
+	handle        string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	scopes        []string
+	authTime      time.Time
+	expires       time.Time
+}
+
+type accessToken struct {
+	client  *OIDCClient
+	handle  string
+	scopes  []string
+	expires time.Time
+}
+
+// OIDCProvider keeps the state of the OpenID Connect authorization server.
+type OIDCProvider struct {
+	mu      sync.Mutex
+	issuer  string
+	clients map[string]*OIDCClient
+	codes   map[string]*authorizationCode
+	tokens  map[string]*accessToken
+	swept   time.Time
+}
+
+func NewOIDCProvider(issuer string, clients []OIDCClient) *OIDCProvider {
+	provider := OIDCProvider{
+		issuer:  strings.TrimSuffix(issuer, "/"),
+		clients: make(map[string]*OIDCClient),
+		codes:   make(map[string]*authorizationCode),
+		tokens:  make(map[string]*accessToken),
+	}
+	for n := range clients {
+		provider.clients[clients[n].ID] = &clients[n]
+	}
+	return &provider
+}
+
+// sweep removes expired codes and access tokens. It must be called with the
+// lock held.
+func (p *OIDCProvider) sweep(now time.Time) {
+	if now.Sub(p.swept) < oidcSweep {
+		return
+	}
+	p.swept = now
+	for key, code := range p.codes {
+		if now.After(code.expires) {
+			delete(p.codes, key)
+		}
+	}
+	for key, access := range p.tokens {
+		if now.After(access.expires) {
+			delete(p.tokens, key)
+		}
+	}
+}
+
+func randomString() string {
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		log.Printf("unexpected error in random generation: %v", err)
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(seed)
+}
+
+// oidcScopes converts the space separated OpenID scopes into safe scopes.
+func oidcScopes(scope string) []string {
+	return ParseScopes(strings.Fields(scope))
+}
+
+type DiscoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+type IDTokenClaims struct {
+	Issuer   string `json:"iss"`
+	Subject  string `json:"sub"`
+	Audience string `json:"aud"`
+	Expires  int64  `json:"exp"`
+	IssuedAt int64  `json:"iat"`
+	AuthTime int64  `json:"auth_time"`
+	Nonce    string `json:"nonce,omitempty"`
+	Handle   string `json:"preferred_username,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+type OAuthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+type AuthorizeView struct {
+	Handle    string
+	Client    string
+	Attorney  string
+	Grant     bool
+	Scopes    []ScopeView
+	Action    template.URL
+	TwoFactor bool
+	CSRF      string
+	// Info is what the directory knows about the attorney
+	Info AppInfo
+}
+
+// SignJWT encodes claims as a compact JWS signed with EdDSA by the safe
+// credentials.
+func (s *Safe) SignJWT(claims any) (string, error) {
+	header := map[string]string{
+		"alg": "EdDSA",
+		"typ": "JWT",
+		"kid": s.credentials.PublicKey().Hex(),
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signing := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	signature := s.credentials.Sign([]byte(signing))
+	return signing + "." + base64.RawURLEncoding.EncodeToString(signature[:]), nil
+}
+
+func (s *Safe) DiscoveryHandler(w http.ResponseWriter, r *http.Request) {
+	issuer := s.oidc.issuer
+	writeJSON(w, http.StatusOK, DiscoveryDocument{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserinfoEndpoint:                  issuer + "/oauth/userinfo",
+		JWKSURI:                           issuer + "/oauth/jwks",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"EdDSA"},
+		ScopesSupported:                   append([]string{"openid"}, KnownScopes...),
+		TokenEndpointAuthMethodsSupported: []string{"none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"sub", "preferred_username", "email", "token", "nonce", "auth_time"},
+	})
+}
+
+func (s *Safe) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	token := s.credentials.PublicKey()
+	writeJSON(w, http.StatusOK, JWKSet{Keys: []JWK{{
+		Kty: "OKP",
+		Crv: "Ed25519",
+		X:   base64.RawURLEncoding.EncodeToString(token[:]),
+		Kid: token.Hex(),
+		Use: "sig",
+		Alg: "EdDSA",
+	}}})
+}
+
+// authorizeRedirect sends the user agent back to the client with the given
+// parameters added to the redirect uri.
+func authorizeRedirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	query := target.Query()
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// AuthorizeHandler implements the authorization endpoint. GET shows the
+// consent page to the logged in user and POST records the decision. The
+// authorization parameters are always read from the query string.
+func (s *Safe) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	params := r.URL.Query()
+	s.oidc.mu.Lock()
+	client, ok := s.oidc.clients[params.Get("client_id")]
+	s.oidc.mu.Unlock()
+	redirectURI := params.Get("redirect_uri")
+	// errors before the redirect uri is validated must not redirect
+	if !ok || !client.allowedRedirect(redirectURI) {
+		http.Error(w, "unknown client or redirect_uri", http.StatusBadRequest)
+		return
+	}
+	state := params.Get("state")
+	fail := func(code, description string) {
+		response := url.Values{"error": {code}, "error_description": {description}}
+		if state != "" {
+			response.Set("state", state)
+		}
+		authorizeRedirect(w, r, redirectURI, response)
+	}
+	if params.Get("response_type") != "code" {
+		fail("unsupported_response_type", "only code is supported")
+		return
+	}
+	if !HasScope(strings.Fields(params.Get("scope")), "openid") {
+		fail("invalid_scope", "openid scope is required")
+		return
+	}
+	challenge := params.Get("code_challenge")
+	if challenge == "" || params.Get("code_challenge_method") != "S256" {
+		fail("invalid_request", "PKCE with S256 is required")
+		return
+	}
+	handle := s.Handle(r)
+	if handle == "" {
+		next := url.QueryEscape("/oauth/authorize?" + params.Encode())
+		http.Redirect(w, r, fmt.Sprintf("%v/login?next=%v", s.serverName, next), http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		view := AuthorizeView{
+			Handle:   handle,
+			Client:   client.Name,
+			Attorney: client.Attorney,
+			Grant:    client.GrantOnToken && client.Attorney != "",
+			Scopes:   scopesView(oidcScopes(params.Get("scope"))),
+			Action:   template.URL("./authorize?" + params.Encode()),
+			CSRF:     s.csrfToken(r),
+		}
+		view.TwoFactor = view.Grant && s.TwoFactorEnabled(handle)
+		if token, ok := attorneyToken(client.Attorney); ok && view.Grant {
+			view.Info = s.directory.Lookup(token)
+		}
+		if view.Client == "" {
+			view.Client = client.ID
+		}
+		s.render(w, r, "authorize.html", view)
+		return
+	}
+	// the client knows the consent url, so the decision must come from the
+	// page rendered by the safe
+	if !s.checkCSRF(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.PostForm.Get("consent") != "grant" {
+		fail("access_denied", "the user denied the request")
+		return
+	}
+	// the token exchange grants power of attorney on behalf of the user
+	if client.GrantOnToken && client.Attorney != "" && s.SecondFactor(handle, r.PostForm.Get("totp"), clientIP(r)) != nil {
+		fail("access_denied", "second factor required to grant power of attorney")
+		return
+	}
+	code := randomString()
+	now := time.Now()
+	s.oidc.mu.Lock()
+	s.oidc.sweep(now)
+	s.oidc.codes[code] = &authorizationCode{
+		client:        client,
+		handle:        handle,
+		redirectURI:   redirectURI,
+		codeChallenge: challenge,
+		nonce:         params.Get("nonce"),
+		scopes:        ParseScopes(r.PostForm["scope"]),
+		authTime:      now,
+		expires:       now.Add(oidcCodeTTL),
+	}
+	s.oidc.mu.Unlock()
+	response := url.Values{"code": {code}}
+	if state != "" {
+		response.Set("state", state)
+	}
+	authorizeRedirect(w, r, redirectURI, response)
+}
+
+func oauthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, OAuthError{Error: code, Description: description})
+}
+
+// verifyPKCE checks the S256 code challenge against the verifier.
+func verifyPKCE(challenge, verifier string) bool {
+	hash := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(hash[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// TokenHandler implements the token endpoint for the authorization code
+// grant.
+func (s *Safe) TokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		oauthError(w, http.StatusMethodNotAllowed, "invalid_request", "only POST is allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "could not parse form")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+	s.oidc.mu.Lock()
+	code, ok := s.oidc.codes[r.PostForm.Get("code")]
+	// codes are single use
+	delete(s.oidc.codes, r.PostForm.Get("code"))
+	s.oidc.mu.Unlock()
+	if !ok || time.Now().After(code.expires) {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired code")
+		return
+	}
+	if code.client.ID != r.PostForm.Get("client_id") || code.redirectURI != r.PostForm.Get("redirect_uri") {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "client_id or redirect_uri mismatch")
+		return
+	}
+	if !verifyPKCE(code.codeChallenge, r.PostForm.Get("code_verifier")) {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "invalid code_verifier")
+		return
+	}
+	now := time.Now()
+	claims := s.userClaims(code.handle, code.scopes)
+	claims.Audience = code.client.ID
+	claims.IssuedAt = now.Unix()
+	claims.Expires = now.Add(oidcIDTokenTTL).Unix()
+	claims.AuthTime = code.authTime.Unix()
+	claims.Nonce = code.nonce
+	idToken, err := s.SignJWT(claims)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	access := randomString()
+	s.oidc.mu.Lock()
+	s.oidc.sweep(now)
+	s.oidc.tokens[access] = &accessToken{
+		client:  code.client,
+		handle:  code.handle,
+		scopes:  code.scopes,
+		expires: now.Add(oidcAccessTokenTTL),
+	}
+	s.oidc.mu.Unlock()
+	if code.client.GrantOnToken {
+		s.grantToClient(code.handle, code.client, code.scopes)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, TokenResponse{
+		AccessToken: access,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(oidcAccessTokenTTL.Seconds()),
+		IDToken:     idToken,
+		Scope:       strings.Join(append([]string{"openid"}, code.scopes...), " "),
+	})
+}
+
+// grantToClient grants power of attorney to the client attorney token
+// unless it is already an attorney of the user.
+func (s *Safe) grantToClient(handle string, client *OIDCClient, scopes []string) {
+	token, ok := attorneyToken(client.Attorney)
+	if !ok {
+		log.Printf("oidc client %v has no valid attorney token", client.ID)
+		return
+	}
+	for _, attorney := range s.UserAttorneys(handle) {
+		if attorney.Equal(token) {
+			return
+		}
+	}
+	fingerprint := crypto.EncodeHash(crypto.HashToken(token))
+	if err := s.GrantPower(handle, client.Attorney, fingerprint, scopes, GrantOrigin{Method: GrantOIDC, App: client.Name}); err != nil {
+		log.Printf("could not grant power of attorney to oidc client %v: %v", client.ID, err)
+	}
+}
+
+// userClaims returns the claims about handle that scopes allow.
+func (s *Safe) userClaims(handle string, scopes []string) IDTokenClaims {
+	email, token := s.EmailAndToken(handle)
+	claims := IDTokenClaims{
+		Issuer:  s.oidc.issuer,
+		Subject: hex.EncodeToString(token[:]),
+	}
+	if HasScope(scopes, ScopeProfile) {
+		claims.Handle = handle
+		claims.Token = token.Hex()
+	}
+	if HasScope(scopes, ScopeEmail) {
+		claims.Email = email
+	}
+	return claims
+}
+
+func (s *Safe) UserinfoHandler(w http.ResponseWriter, r *http.Request) {
+	s.oidc.mu.Lock()
+	access, ok := s.oidc.tokens[bearer(r)]
+	s.oidc.mu.Unlock()
+	if !ok || time.Now().After(access.expires) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		oauthError(w, http.StatusUnauthorized, "invalid_token", "unknown or expired access token")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.userClaims(access.handle, access.scopes))
+}
@@ -0,0 +1,158 @@
+package safe
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	// the example of RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if !verifyPKCE(challenge, verifier) {
+		t.Errorf("the RFC 7636 verifier was refused")
+	}
+	if verifyPKCE(challenge, verifier+"x") {
+		t.Errorf("a wrong verifier was accepted")
+	}
+	if verifyPKCE(verifier, verifier) {
+		t.Errorf("a plain challenge was accepted")
+	}
+}
+
+// exchangeCode posts code to the token endpoint of s.
+func exchangeCode(s *Safe, code, verifier string) *httptest.ResponseRecorder {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {"app"},
+		"redirect_uri":  {"https://app.example.com/callback"},
+		"code_verifier": {verifier},
+	}
+	r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.TokenHandler(w, r)
+	return w
+}
+
+// testCode stores an authorization code for handle with the S256 challenge
+// of verifier.
+func testCode(s *Safe, handle, verifier string, expires time.Time) string {
+	hash := sha256.Sum256([]byte(verifier))
+	code := randomString()
+	s.oidc.mu.Lock()
+	s.oidc.codes[code] = &authorizationCode{
+		client:        &OIDCClient{ID: "app", RedirectURIs: []string{"https://app.example.com/callback"}},
+		handle:        handle,
+		redirectURI:   "https://app.example.com/callback",
+		codeChallenge: base64.RawURLEncoding.EncodeToString(hash[:]),
+		nonce:         "n-0S6_WzA2Mj",
+		scopes:        []string{ScopeProfile},
+		authTime:      time.Now(),
+		expires:       expires,
+	}
+	s.oidc.mu.Unlock()
+	return code
+}
+
+func TestTokenCodeIsSingleUse(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	testUser(t, s, "alice")
+	verifier := randomString()
+
+	code := testCode(s, "alice", verifier, time.Now().Add(oidcCodeTTL))
+	if w := exchangeCode(s, code, "wrong verifier"); w.Code != http.StatusBadRequest {
+		t.Errorf("exchange with a wrong verifier = %v", w.Code)
+	}
+	// a failed exchange spends the code as well
+	if w := exchangeCode(s, code, verifier); w.Code != http.StatusBadRequest {
+		t.Errorf("exchange after a failed attempt = %v", w.Code)
+	}
+
+	code = testCode(s, "alice", verifier, time.Now().Add(oidcCodeTTL))
+	if w := exchangeCode(s, code, verifier); w.Code != http.StatusOK {
+		t.Fatalf("exchange = %v: %v", w.Code, w.Body)
+	}
+	w := exchangeCode(s, code, verifier)
+	var response OAuthError
+	json.NewDecoder(w.Body).Decode(&response)
+	if w.Code != http.StatusBadRequest || response.Error != "invalid_grant" {
+		t.Errorf("second exchange = %v %+v", w.Code, response)
+	}
+
+	code = testCode(s, "alice", verifier, time.Now().Add(-time.Second))
+	if w := exchangeCode(s, code, verifier); w.Code != http.StatusBadRequest {
+		t.Errorf("exchange of an expired code = %v", w.Code)
+	}
+}
+
+func TestIDTokenSignature(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	testUser(t, s, "alice")
+	verifier := randomString()
+	w := exchangeCode(s, testCode(s, "alice", verifier, time.Now().Add(oidcCodeTTL)), verifier)
+	var response TokenResponse
+	json.NewDecoder(w.Body).Decode(&response)
+	parts := strings.Split(response.IDToken, ".")
+	if len(parts) != 3 {
+		t.Fatalf("id token %q is not a JWT", response.IDToken)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(data) != len(crypto.Signature{}) {
+		t.Fatalf("invalid signature encoding %q", parts[2])
+	}
+	var signature crypto.Signature
+	copy(signature[:], data)
+	signing := parts[0] + "." + parts[1]
+	if !s.credentials.PublicKey().Verify([]byte(signing), signature) {
+		t.Errorf("id token signature does not verify with the safe key")
+	}
+	if s.credentials.PublicKey().Verify([]byte(signing+"x"), signature) {
+		t.Errorf("signature verifies a tampered token")
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims IDTokenClaims
+	json.Unmarshal(payload, &claims)
+	if claims.Audience != "app" || claims.Nonce != "n-0S6_WzA2Mj" || claims.Handle != "alice" {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/oauth/userinfo", nil)
+	r.Header.Set("Authorization", "Bearer "+response.AccessToken)
+	userinfo := httptest.NewRecorder()
+	s.UserinfoHandler(userinfo, r)
+	if userinfo.Code != http.StatusOK {
+		t.Errorf("userinfo = %v", userinfo.Code)
+	}
+}
+
+func TestOIDCSweep(t *testing.T) {
+	provider := NewOIDCProvider("https://safe.example.com", nil)
+	now := time.Now()
+	provider.codes["expired"] = &authorizationCode{expires: now.Add(-time.Second)}
+	provider.codes["valid"] = &authorizationCode{expires: now.Add(oidcCodeTTL)}
+	provider.tokens["expired"] = &accessToken{expires: now.Add(-time.Second)}
+	provider.tokens["valid"] = &accessToken{expires: now.Add(oidcAccessTokenTTL)}
+	provider.sweep(now)
+	if _, ok := provider.codes["expired"]; ok || len(provider.codes) != 1 {
+		t.Errorf("codes after sweep = %v", provider.codes)
+	}
+	if _, ok := provider.tokens["expired"]; ok || len(provider.tokens) != 1 {
+		t.Errorf("tokens after sweep = %v", provider.tokens)
+	}
+	provider.codes["later"] = &authorizationCode{expires: now.Add(-time.Second)}
+	provider.sweep(now.Add(oidcSweep / 2))
+	if _, ok := provider.codes["later"]; !ok {
+		t.Errorf("sweep ran again before oidcSweep")
+	}
+}
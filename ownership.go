@@ -0,0 +1,267 @@
+package safe
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+const (
+	challengeTTL = 10 * time.Minute
+	statementTTL = 10 * time.Minute
+	maxNonceSize = 256
+)
+
+const (
+	ChallengePending  = "pending"
+	ChallengeApproved = "approved"
+	ChallengeDenied   = "denied"
+)
+
+// OwnershipStatement is signed by the user key to prove to App that the
+// user controls Handle. Nonce is chosen by the app to prevent replays.
+type OwnershipStatement struct {
+	Handle    string
+	Token     crypto.Token
+	App       crypto.Token
+	Nonce     string
+	Epoch     uint64
+	Expires   time.Time
+	Signature crypto.Signature
+}
+
+// Message is the human readable text signed by the user.
+func (o OwnershipStatement) Message() []byte {
+	return []byte(fmt.Sprintf("%v controls handle %v\napp: %v\nnonce: %v\nepoch: %v\nexpires: %v",
+		o.Token.Hex(), o.Handle, o.App.Hex(), o.Nonce, o.Epoch, o.Expires.Unix()))
+}
+
+// statementText tells whether text can go into the lines of a statement
+// message: valid UTF-8 without control characters such as new lines.
+func statementText(text string) bool {
+	if !utf8.ValidString(text) {
+		return false
+	}
+	for _, char := range text {
+		if unicode.IsControl(char) {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyOwnership checks that the statement was signed by the user token for
+// the given app and nonce and is not expired.
+func VerifyOwnership(statement OwnershipStatement, user, app crypto.Token, nonce string) error {
+	if !statementText(statement.Handle) || !statementText(statement.Nonce) {
+		return errors.New("control characters in statement")
+	}
+	if !statement.Token.Equal(user) {
+		return errors.New("statement signed by another token")
+	}
+	if !statement.App.Equal(app) {
+		return errors.New("statement issued to another app")
+	}
+	if statement.Nonce != nonce {
+		return errors.New("nonce mismatch")
+	}
+	if time.Now().After(statement.Expires) {
+		return errors.New("statement expired")
+	}
+	if !user.Verify(statement.Message(), statement.Signature) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+type OwnershipStatementJSON struct {
+	Handle    string `json:"handle"`
+	Token     string `json:"token"`
+	App       string `json:"app"`
+	Nonce     string `json:"nonce"`
+	Epoch     uint64 `json:"epoch"`
+	Expires   int64  `json:"expires"`
+	Signature string `json:"signature"`
+}
+
+func (o OwnershipStatement) JSON() OwnershipStatementJSON {
+	return OwnershipStatementJSON{
+		Handle:    o.Handle,
+		Token:     o.Token.Hex(),
+		App:       o.App.Hex(),
+		Nonce:     o.Nonce,
+		Epoch:     o.Epoch,
+		Expires:   o.Expires.Unix(),
+		Signature: hex.EncodeToString(o.Signature[:]),
+	}
+}
+
+// Statement parses the json representation back into a statement.
+func (o OwnershipStatementJSON) Statement() (OwnershipStatement, error) {
+	statement := OwnershipStatement{
+		Handle:  o.Handle,
+		Nonce:   o.Nonce,
+		Epoch:   o.Epoch,
+		Expires: time.Unix(o.Expires, 0),
+	}
+	var ok bool
+	if statement.Token, ok = attorneyToken(o.Token); !ok {
+		return statement, errors.New("invalid token")
+	}
+	if statement.App, ok = attorneyToken(o.App); !ok {
+		return statement, errors.New("invalid app token")
+	}
+	signature, _ := hex.DecodeString(o.Signature)
+	if len(signature) != len(statement.Signature) {
+		return statement, errors.New("invalid signature")
+	}
+	copy(statement.Signature[:], signature)
+	return statement, nil
+}
+
+type Challenge struct {
+	ID        string
+	Handle    string
+	App       crypto.Token
+	Nonce     string
+	Status    string
+	Expires   time.Time
+	Statement *OwnershipStatement
+}
+
+type ChallengeStore struct {
+	mu         sync.Mutex
+	challenges map[string]*Challenge
+}
+
+func NewChallengeStore() *ChallengeStore {
+	return &ChallengeStore{challenges: make(map[string]*Challenge)}
+}
+
+func (c *ChallengeStore) New(handle string, app crypto.Token, nonce string) *Challenge {
+	challenge := &Challenge{
+		ID:      randomString(),
+		Handle:  handle,
+		App:     app,
+		Nonce:   nonce,
+		Status:  ChallengePending,
+		Expires: time.Now().Add(challengeTTL),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, old := range c.challenges {
+		if time.Now().After(old.Expires) {
+			delete(c.challenges, id)
+		}
+	}
+	c.challenges[challenge.ID] = challenge
+	return challenge
+}
+
+// Get returns a copy of a challenge that is not expired.
+func (c *ChallengeStore) Get(id string) (Challenge, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	challenge, ok := c.challenges[id]
+	if !ok || time.Now().After(challenge.Expires) {
+		return Challenge{}, false
+	}
+	return *challenge, true
+}
+
+func (c *ChallengeStore) answer(id string, statement *OwnershipStatement) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	challenge, ok := c.challenges[id]
+	if !ok || challenge.Status != ChallengePending || time.Now().After(challenge.Expires) {
+		return false
+	}
+	if statement == nil {
+		challenge.Status = ChallengeDenied
+	} else {
+		challenge.Status = ChallengeApproved
+		challenge.Statement = statement
+		challenge.Expires = statement.Expires
+	}
+	return true
+}
+
+// SignOwnership signs with the key of handle a statement answering the
+// challenge.
+func (s *Safe) SignOwnership(handle string, challenge Challenge) (*OwnershipStatement, error) {
+	user, ok := s.vault.UserSecret(handle)
+	if !ok {
+		return nil, errors.New("invalid user")
+	}
+	statement := OwnershipStatement{
+		Handle:  handle,
+		Token:   user.Secret.PublicKey(),
+		App:     challenge.App,
+		Nonce:   challenge.Nonce,
+		Epoch:   s.Epoch(),
+		Expires: time.Now().Add(statementTTL).Truncate(time.Second),
+	}
+	statement.Signature = user.Secret.Sign(statement.Message())
+	return &statement, nil
+}
+
+type ChallengeView struct {
+	ID     string
+	Handle string
+	App    string
+	Nonce  string
+	CSRF   string
+}
+
+func (s *Safe) ChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/challenge/")
+	challenge, ok := s.challenges.Get(id)
+	if !ok || challenge.Status != ChallengePending {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if s.Handle(r) != challenge.Handle {
+		next := url.QueryEscape("/challenge/" + id)
+		http.Redirect(w, r, fmt.Sprintf("%v/login?next=%v", s.serverName, next), http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		view := ChallengeView{
+			ID:     id,
+			Handle: challenge.Handle,
+			App:    challenge.App.Hex(),
+			Nonce:  challenge.Nonce,
+			CSRF:   s.csrfToken(r),
+		}
+		s.render(w, r, "challenge.html", view)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		return
+	}
+	if !s.checkCSRF(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var statement *OwnershipStatement
+	if r.FormValue("answer") == "approve" {
+		var err error
+		if statement, err = s.SignOwnership(challenge.Handle, challenge); err != nil {
+			log.Printf("could not sign ownership statement: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	s.challenges.answer(id, statement)
+	http.Redirect(w, r, fmt.Sprintf("%v/", s.serverName), http.StatusSeeOther)
+}
@@ -0,0 +1,110 @@
+package safe
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// testStatement signs a statement of alice to app for nonce and returns it
+// with the token of alice.
+func testStatement(t *testing.T, s *Safe, app crypto.Token, nonce string) (OwnershipStatement, crypto.Token) {
+	t.Helper()
+	challenge := s.challenges.New("alice", app, nonce)
+	statement, err := s.SignOwnership("alice", *challenge)
+	if err != nil {
+		t.Fatalf("SignOwnership: %v", err)
+	}
+	return *statement, s.vault.handle["alice"].Secret.PublicKey()
+}
+
+func TestOwnershipRoundTrip(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	testUser(t, s, "alice")
+	app, _ := crypto.RandomAsymetricKey()
+	statement, user := testStatement(t, s, app, "nonce 1")
+	data, _ := json.Marshal(statement.JSON())
+	var decoded OwnershipStatementJSON
+	json.Unmarshal(data, &decoded)
+	parsed, err := decoded.Statement()
+	if err != nil {
+		t.Fatalf("Statement: %v", err)
+	}
+	if err := VerifyOwnership(parsed, user, app, "nonce 1"); err != nil {
+		t.Errorf("VerifyOwnership: %v", err)
+	}
+	if err := VerifyOwnership(parsed, user, app, "nonce 2"); err == nil {
+		t.Errorf("statement accepted for another nonce")
+	}
+	other, _ := crypto.RandomAsymetricKey()
+	if err := VerifyOwnership(parsed, other, app, "nonce 1"); err == nil {
+		t.Errorf("statement accepted for another user")
+	}
+}
+
+func TestOwnershipWrongApp(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	testUser(t, s, "alice")
+	app, _ := crypto.RandomAsymetricKey()
+	other, _ := crypto.RandomAsymetricKey()
+	statement, user := testStatement(t, s, app, "nonce")
+	if err := VerifyOwnership(statement, user, other, "nonce"); err == nil {
+		t.Errorf("statement accepted by another app")
+	}
+}
+
+func TestOwnershipExpired(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	testUser(t, s, "alice")
+	app, _ := crypto.RandomAsymetricKey()
+	statement, user := testStatement(t, s, app, "nonce")
+	statement.Expires = time.Now().Add(-time.Second).Truncate(time.Second)
+	statement.Signature = s.vault.handle["alice"].Secret.Sign(statement.Message())
+	if err := VerifyOwnership(statement, user, app, "nonce"); err == nil {
+		t.Errorf("expired statement accepted")
+	}
+}
+
+func TestOwnershipTampered(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	testUser(t, s, "alice")
+	app, _ := crypto.RandomAsymetricKey()
+	statement, user := testStatement(t, s, app, "nonce")
+	tampered := statement
+	tampered.Handle = "mallory"
+	if err := VerifyOwnership(tampered, user, app, "nonce"); err == nil {
+		t.Errorf("statement with a changed handle accepted")
+	}
+	tampered = statement
+	tampered.Epoch++
+	if err := VerifyOwnership(tampered, user, app, "nonce"); err == nil {
+		t.Errorf("statement with a changed epoch accepted")
+	}
+	// a nonce with a new line could forge the lines that follow it
+	statement, user = testStatement(t, s, app, "nonce\nepoch: 0")
+	if err := VerifyOwnership(statement, user, app, "nonce\nepoch: 0"); err == nil {
+		t.Errorf("statement with a control character in the nonce accepted")
+	}
+}
+
+func TestChallengeNonce(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	testUser(t, s, "alice")
+	_, key := crypto.RandomAsymetricKey()
+	handler := (&RestAPI{Safe: s}).Handler()
+	for nonce, status := range map[string]int{"nonce": http.StatusCreated, "nonce\nepoch: 0": http.StatusBadRequest, "": http.StatusBadRequest} {
+		body, _ := json.Marshal(ChallengeRequest{Handle: "alice", Nonce: nonce})
+		r := httptest.NewRequest(http.MethodPost, "/v1/challenges", bytes.NewReader(body))
+		SignAppRequest(r, body, key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != status {
+			t.Errorf("challenge with nonce %q = %v, want %v", nonce, w.Code, status)
+		}
+	}
+}
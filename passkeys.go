@@ -0,0 +1,460 @@
+package safe
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	webauthnChallengeSize = 32
+	webauthnTimeout       = 2 * time.Minute
+)
+
+// Errors of the ceremonies shown to users. Failed verifications wrap
+// ErrPasskeyInvalid with the reason.
+var (
+	ErrPasskeyInvalid    = errors.New("invalid passkey response")
+	ErrPasskeyExpired    = errors.New("unknown or expired passkey request")
+	ErrPasskeyRegistered = errors.New("passkey already registered")
+	ErrPasskeyUnknown    = errors.New("unknown passkey")
+)
+
+type ceremony struct {
+	handle   string
+	ticket   string
+	register bool
+	expires  time.Time
+}
+
+// WebAuthn keeps the challenges of the ceremonies in progress. Credentials
+// are kept in the vault.
+type WebAuthn struct {
+	mu         sync.Mutex
+	config     WebAuthnConfig
+	vault      *Vault
+	ceremonies map[string]ceremony
+}
+
+func NewWebAuthn(config WebAuthnConfig, vault *Vault) *WebAuthn {
+	return &WebAuthn{
+		config:     config,
+		vault:      vault,
+		ceremonies: make(map[string]ceremony),
+	}
+}
+
+func (wa *WebAuthn) newChallenge(c ceremony) []byte {
+	challenge := make([]byte, webauthnChallengeSize)
+	rand.Read(challenge)
+	wa.mu.Lock()
+	defer wa.mu.Unlock()
+	now := time.Now()
+	for key, pending := range wa.ceremonies {
+		if now.After(pending.expires) {
+			delete(wa.ceremonies, key)
+		}
+	}
+	c.expires = now.Add(webauthnTimeout)
+	wa.ceremonies[b64url.EncodeToString(challenge)] = c
+	return challenge
+}
+
+// takeChallenge returns the ceremony of the challenge in the client data,
+// which can only be used once.
+func (wa *WebAuthn) takeChallenge(clientDataJSON []byte) ([]byte, ceremony, bool) {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return nil, ceremony{}, false
+	}
+	wa.mu.Lock()
+	defer wa.mu.Unlock()
+	pending, ok := wa.ceremonies[data.Challenge]
+	delete(wa.ceremonies, data.Challenge)
+	if !ok || time.Now().After(pending.expires) {
+		return nil, ceremony{}, false
+	}
+	challenge, err := b64url.DecodeString(data.Challenge)
+	if err != nil {
+		return nil, ceremony{}, false
+	}
+	return challenge, pending, true
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type relyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type webauthnUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are the PublicKeyCredentialCreationOptions given to
+// navigator.credentials.create, with binary fields base64url encoded.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     relyingParty           `json:"rp"`
+	User                   webauthnUser           `json:"user"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+}
+
+// RequestOptions are the PublicKeyCredentialRequestOptions given to
+// navigator.credentials.get, with binary fields base64url encoded.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationRequest carries the response of navigator.credentials.create.
+// TOTP is required if the user enabled two-factor authentication.
+type RegistrationRequest struct {
+	Name              string `json:"name"`
+	SecondFactor      bool   `json:"second_factor"`
+	TOTP              string `json:"totp,omitempty"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+}
+
+// LoginBeginRequest carries the ticket of a login waiting for the second
+// factor. Without a ticket the login is passwordless.
+type LoginBeginRequest struct {
+	Ticket string `json:"ticket,omitempty"`
+}
+
+// AssertionRequest carries the response of navigator.credentials.get.
+type AssertionRequest struct {
+	Ticket            string `json:"ticket,omitempty"`
+	Next              string `json:"next,omitempty"`
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+}
+
+type LoginResponse struct {
+	Redirect string `json:"redirect"`
+}
+
+func descriptors(credentials []WebAuthnCredential) []credentialDescriptor {
+	list := make([]credentialDescriptor, len(credentials))
+	for n, credential := range credentials {
+		list[n] = credentialDescriptor{Type: "public-key", ID: b64url.EncodeToString(credential.ID)}
+	}
+	return list
+}
+
+// BeginRegistration starts the registration of a new credential of handle.
+func (s *Safe) BeginRegistration(handle string) CreationOptions {
+	challenge := s.webauthn.newChallenge(ceremony{handle: handle, register: true})
+	_, token := s.EmailAndToken(handle)
+	return CreationOptions{
+		Challenge: b64url.EncodeToString(challenge),
+		RP:        relyingParty{ID: s.webauthn.config.RPID, Name: s.webauthn.config.RPName},
+		// the user id must not be personal information, the token is not
+		User: webauthnUser{ID: b64url.EncodeToString(token[:]), Name: handle, DisplayName: handle},
+		PubKeyCredParams: []credentialParameter{
+			{Type: "public-key", Alg: coseES256},
+			{Type: "public-key", Alg: coseEdDSA},
+			{Type: "public-key", Alg: coseRS256},
+		},
+		Timeout:            webauthnTimeout.Milliseconds(),
+		Attestation:        "none",
+		ExcludeCredentials: descriptors(s.vault.HandleCredentials(handle)),
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+	}
+}
+
+// FinishRegistration verifies and stores a new credential of handle.
+func (s *Safe) FinishRegistration(handle string, req RegistrationRequest) error {
+	clientDataJSON, err := b64url.DecodeString(req.ClientDataJSON)
+	if err != nil {
+		return fmt.Errorf("%w: invalid client data", ErrPasskeyInvalid)
+	}
+	attestation, err := b64url.DecodeString(req.AttestationObject)
+	if err != nil {
+		return fmt.Errorf("%w: invalid attestation object", ErrPasskeyInvalid)
+	}
+	challenge, pending, ok := s.webauthn.takeChallenge(clientDataJSON)
+	if !ok || !pending.register || pending.handle != handle {
+		return ErrPasskeyExpired
+	}
+	auth, err := s.webauthn.config.VerifyRegistration(clientDataJSON, attestation, challenge)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPasskeyInvalid, err)
+	}
+	if _, exists := s.vault.Credential(auth.CredentialID); exists {
+		return ErrPasskeyRegistered
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "passkey"
+	}
+	return s.vault.SaveCredential(WebAuthnCredential{
+		Handle:       handle,
+		ID:           auth.CredentialID,
+		PublicKey:    auth.PublicKey,
+		SignCount:    auth.SignCount,
+		Name:         name,
+		SecondFactor: req.SecondFactor,
+		Created:      uint64(time.Now().Unix()),
+		Active:       true,
+	})
+}
+
+// BeginLogin starts a login. With a ticket from CredentialsHandler the
+// credential is a second factor of the ticket handle, otherwise any
+// discoverable credential verifying the user signs in without password.
+func (s *Safe) BeginLogin(ticket string) (RequestOptions, error) {
+	options := RequestOptions{
+		RPID:             s.webauthn.config.RPID,
+		Timeout:          webauthnTimeout.Milliseconds(),
+		AllowCredentials: []credentialDescriptor{},
+		UserVerification: "required",
+	}
+	c := ceremony{}
+	if ticket != "" {
+		pending, ok := s.twoFactor.Ticket(ticket)
+		if !ok {
+			return options, ErrPasskeyExpired
+		}
+		c.handle = pending.handle
+		c.ticket = ticket
+		options.AllowCredentials = descriptors(s.vault.HandleCredentials(pending.handle))
+		options.UserVerification = "preferred"
+	}
+	options.Challenge = b64url.EncodeToString(s.webauthn.newChallenge(c))
+	return options, nil
+}
+
+// FinishLogin verifies an assertion and returns the handle it signs in.
+func (s *Safe) FinishLogin(req AssertionRequest) (string, error) {
+	id, err := b64url.DecodeString(req.ID)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid credential id", ErrPasskeyInvalid)
+	}
+	clientDataJSON, err := b64url.DecodeString(req.ClientDataJSON)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid client data", ErrPasskeyInvalid)
+	}
+	authData, err := b64url.DecodeString(req.AuthenticatorData)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authenticator data", ErrPasskeyInvalid)
+	}
+	signature, err := b64url.DecodeString(req.Signature)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid signature", ErrPasskeyInvalid)
+	}
+	challenge, pending, ok := s.webauthn.takeChallenge(clientDataJSON)
+	if !ok || pending.register || pending.ticket != req.Ticket {
+		return "", ErrPasskeyExpired
+	}
+	credential, ok := s.vault.Credential(id)
+	if !ok || (pending.handle != "" && credential.Handle != pending.handle) {
+		return "", ErrPasskeyUnknown
+	}
+	// without password the credential must verify the user
+	requireUV := pending.ticket == ""
+	count, err := s.webauthn.config.VerifyAssertion(credential, clientDataJSON, authData, signature, challenge, requireUV)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrPasskeyInvalid, err)
+	}
+	if count != credential.SignCount {
+		credential.SignCount = count
+		if err := s.vault.SaveCredential(credential); err != nil {
+			log.Printf("could not update signature counter: %v", err)
+		}
+	}
+	return credential.Handle, nil
+}
+
+// HasSecurityKey tells if a login of handle with password requires one of
+// its credentials registered as second factor.
+func (s *Safe) HasSecurityKey(handle string) bool {
+	for _, credential := range s.vault.HandleCredentials(handle) {
+		if credential.SecondFactor {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonRequest only accepts JSON bodies so that the cookie authenticated
+// ceremonies cannot be posted by cross-site forms.
+func (s *Safe) jsonRequest(w http.ResponseWriter, r *http.Request) bool {
+	if !s.allowMethod(w, r, http.MethodPost) {
+		return false
+	}
+	if media, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); media != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, ErrInvalidJSON, Translate(s.Language(r), "api.json_only"))
+		return false
+	}
+	return true
+}
+
+// WebAuthnAPIHandler serves the ceremonies used by static/webauthn.js:
+//
+//	POST /webauthn/register/begin   session
+//	POST /webauthn/register/finish  session
+//	POST /webauthn/login/begin
+//	POST /webauthn/login/finish
+func (s *Safe) WebAuthnAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.jsonRequest(w, r) {
+		return
+	}
+	language := s.Language(r)
+	switch strings.TrimPrefix(r.URL.Path, "/webauthn/") {
+	case "register/begin":
+		handle := s.Handle(r)
+		if handle == "" {
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized, Translate(language, "api.passkey_login"))
+			return
+		}
+		writeJSON(w, http.StatusOK, s.BeginRegistration(handle))
+	case "register/finish":
+		handle := s.Handle(r)
+		if handle == "" {
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized, Translate(language, "api.passkey_login"))
+			return
+		}
+		var req RegistrationRequest
+		if !s.decodeJSON(w, r, &req) {
+			return
+		}
+		if err := s.SecondFactor(handle, req.TOTP, clientIP(r)); err != nil {
+			if !writeThrottled(w, language, err) {
+				writeError(w, http.StatusForbidden, ErrSecondFactorRequired, translateError(language, err))
+			}
+			return
+		}
+		if err := s.FinishRegistration(handle, req); err != nil {
+			writeError(w, http.StatusBadRequest, ErrBadCredentials, translateError(language, err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "login/begin":
+		var req LoginBeginRequest
+		if !s.decodeJSON(w, r, &req) {
+			return
+		}
+		options, err := s.BeginLogin(req.Ticket)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized, translateError(language, err))
+			return
+		}
+		writeJSON(w, http.StatusOK, options)
+	case "login/finish":
+		var req AssertionRequest
+		if !s.decodeJSON(w, r, &req) {
+			return
+		}
+		handle, err := s.FinishLogin(req)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, ErrBadCredentials, translateError(language, err))
+			return
+		}
+		next := req.Next
+		if req.Ticket != "" {
+			if pending, ok := s.twoFactor.Ticket(req.Ticket); ok {
+				next = pending.next
+			}
+			s.twoFactor.EndTicket(req.Ticket)
+		}
+		if !s.setSessionCookie(w, handle) {
+			writeError(w, http.StatusInternalServerError, ErrBadCredentials, Translate(language, "api.session_failed"))
+			return
+		}
+		writeJSON(w, http.StatusOK, LoginResponse{Redirect: fmt.Sprintf("%v%v", s.serverName, nextPath(next))})
+	default:
+		writeError(w, http.StatusNotFound, ErrNotFound, Translate(language, "api.not_found"))
+	}
+}
+
+type CredentialView struct {
+	ID           string
+	Name         string
+	SecondFactor bool
+	Created      string
+}
+
+type PasskeysView struct {
+	Handle      string
+	Credentials []CredentialView
+	TwoFactor   bool
+	Error       string
+}
+
+// PasskeysHandler lists the credentials of the user and removes them on
+// POST with action remove.
+func (s *Safe) PasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	handle := s.Handle(r)
+	if handle == "" {
+		http.Redirect(w, r, fmt.Sprintf("%v/login?next=/webauthn", s.serverName), http.StatusSeeOther)
+		return
+	}
+	view := PasskeysView{Handle: handle, TwoFactor: s.TwoFactorEnabled(handle)}
+	if r.Method == http.MethodPost {
+		language := s.Language(r)
+		if err := r.ParseForm(); err != nil {
+			return
+		}
+		id, err := b64url.DecodeString(r.FormValue("id"))
+		credential, ok := s.vault.Credential(id)
+		if err != nil || !ok || credential.Handle != handle {
+			view.Error = translateError(language, ErrPasskeyUnknown)
+		} else if err := s.SecondFactor(handle, r.FormValue("totp"), clientIP(r)); err != nil {
+			view.Error = translateError(language, err)
+		} else {
+			credential.Active = false
+			if err := s.vault.SaveCredential(credential); err != nil {
+				view.Error = translateError(language, err)
+			}
+		}
+	}
+	credentials := s.vault.HandleCredentials(handle)
+	sort.Slice(credentials, func(i, j int) bool { return credentials[i].Created < credentials[j].Created })
+	for _, credential := range credentials {
+		view.Credentials = append(view.Credentials, CredentialView{
+			ID:           b64url.EncodeToString(credential.ID),
+			Name:         credential.Name,
+			SecondFactor: credential.SecondFactor,
+			Created:      time.Unix(int64(credential.Created), 0).Format("2006-01-02 15:04"),
+		})
+	}
+	s.render(w, r, "passkeys.html", view)
+}
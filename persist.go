@@ -1,10 +1,13 @@
 package safe
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/freehandle/breeze/crypto"
 	"github.com/freehandle/breeze/util"
@@ -12,6 +15,19 @@ import (
 
 const (
 	UserSecretKind byte = iota
+	AttorneyScopesKind
+	WebhookKind
+	AttorneyExpiryKind
+	FrozenKind
+	AttorneyRecordKind
+	DirectoryKind
+	TOTPKind
+	WebAuthnKind
+	// NetworkHandleKind entries of older vaults are ignored, the handles
+	// joined on chain are kept by NetworkIndex in their own file
+	NetworkHandleKind
+	LanguageKind
+	SessionKind
 )
 
 type UserSecret struct {
@@ -43,16 +59,408 @@ func ParseUserSecret(data []byte) (UserSecret, bool) {
 	return user, position == len(data)
 }
 
+type AttorneyScopes struct {
+	Handle   string
+	Attorney crypto.Token
+	Scopes   []string
+}
+
+func (a AttorneyScopes) Serialize() []byte {
+	bytes := []byte{AttorneyScopesKind}
+	util.PutString(a.Handle, &bytes)
+	util.PutToken(a.Attorney, &bytes)
+	util.PutUint16(uint16(len(a.Scopes)), &bytes)
+	for _, scope := range a.Scopes {
+		util.PutString(scope, &bytes)
+	}
+	return bytes
+}
+
+func ParseAttorneyScopes(data []byte) (AttorneyScopes, bool) {
+	var scopes AttorneyScopes
+	if data[0] != AttorneyScopesKind {
+		return scopes, false
+	}
+	position := 1
+	scopes.Handle, position = util.ParseString(data, position)
+	scopes.Attorney, position = util.ParseToken(data, position)
+	var count uint16
+	count, position = util.ParseUint16(data, position)
+	scopes.Scopes = make([]string, count)
+	for n := 0; n < int(count); n++ {
+		scopes.Scopes[n], position = util.ParseString(data, position)
+	}
+	return scopes, position == len(data)
+}
+
+// Webhook is an url registered by an app to be notified of the events that
+// involve its attorney token. Removed webhooks are persisted with Active
+// false.
+type Webhook struct {
+	ID       string
+	Attorney crypto.Token
+	URL      string
+	Active   bool
+}
+
+func (h Webhook) Serialize() []byte {
+	bytes := []byte{WebhookKind}
+	util.PutString(h.ID, &bytes)
+	util.PutToken(h.Attorney, &bytes)
+	util.PutString(h.URL, &bytes)
+	util.PutBool(h.Active, &bytes)
+	return bytes
+}
+
+func ParseWebhook(data []byte) (Webhook, bool) {
+	var hook Webhook
+	if data[0] != WebhookKind {
+		return hook, false
+	}
+	position := 1
+	hook.ID, position = util.ParseString(data, position)
+	hook.Attorney, position = util.ParseToken(data, position)
+	hook.URL, position = util.ParseString(data, position)
+	hook.Active, position = util.ParseBool(data, position)
+	return hook, position == len(data)
+}
+
+// AttorneyExpiry is persisted with zero Epoch and Time when the bound is
+// removed. Time is in unix seconds.
+type AttorneyExpiry struct {
+	Handle   string
+	Attorney crypto.Token
+	Epoch    uint64
+	Time     uint64
+}
+
+func (a AttorneyExpiry) Serialize() []byte {
+	bytes := []byte{AttorneyExpiryKind}
+	util.PutString(a.Handle, &bytes)
+	util.PutToken(a.Attorney, &bytes)
+	util.PutUint64(a.Epoch, &bytes)
+	util.PutUint64(a.Time, &bytes)
+	return bytes
+}
+
+func ParseAttorneyExpiry(data []byte) (AttorneyExpiry, bool) {
+	var expiry AttorneyExpiry
+	if data[0] != AttorneyExpiryKind {
+		return expiry, false
+	}
+	position := 1
+	expiry.Handle, position = util.ParseString(data, position)
+	expiry.Attorney, position = util.ParseToken(data, position)
+	expiry.Epoch, position = util.ParseUint64(data, position)
+	expiry.Time, position = util.ParseUint64(data, position)
+	return expiry, position == len(data)
+}
+
+func (a AttorneyExpiry) Expiry() Expiry {
+	expiry := Expiry{Epoch: a.Epoch}
+	if a.Time != 0 {
+		expiry.Time = time.Unix(int64(a.Time), 0)
+	}
+	return expiry
+}
+
+type FrozenAccount struct {
+	Handle string
+	Frozen bool
+}
+
+func (f FrozenAccount) Serialize() []byte {
+	bytes := []byte{FrozenKind}
+	util.PutString(f.Handle, &bytes)
+	util.PutBool(f.Frozen, &bytes)
+	return bytes
+}
+
+func ParseFrozenAccount(data []byte) (FrozenAccount, bool) {
+	var frozen FrozenAccount
+	if data[0] != FrozenKind {
+		return frozen, false
+	}
+	position := 1
+	frozen.Handle, position = util.ParseString(data, position)
+	frozen.Frozen, position = util.ParseBool(data, position)
+	return frozen, position == len(data)
+}
+
+// AttorneyRecord describes how a power of attorney was granted by the safe.
+// Epoch is the epoch the grant was signed at and identifies the grant on
+// chain. Confirmed is set once the grant is incorporated in a block.
+type AttorneyRecord struct {
+	Handle      string
+	Attorney    crypto.Token
+	Epoch       uint64
+	Fingerprint string
+	App         string
+	Method      string
+	Confirmed   bool
+}
+
+func (a AttorneyRecord) Serialize() []byte {
+	bytes := []byte{AttorneyRecordKind}
+	util.PutString(a.Handle, &bytes)
+	util.PutToken(a.Attorney, &bytes)
+	util.PutUint64(a.Epoch, &bytes)
+	util.PutString(a.Fingerprint, &bytes)
+	util.PutString(a.App, &bytes)
+	util.PutString(a.Method, &bytes)
+	util.PutBool(a.Confirmed, &bytes)
+	return bytes
+}
+
+func ParseAttorneyRecord(data []byte) (AttorneyRecord, bool) {
+	var record AttorneyRecord
+	if data[0] != AttorneyRecordKind {
+		return record, false
+	}
+	position := 1
+	record.Handle, position = util.ParseString(data, position)
+	record.Attorney, position = util.ParseToken(data, position)
+	record.Epoch, position = util.ParseUint64(data, position)
+	record.Fingerprint, position = util.ParseString(data, position)
+	record.App, position = util.ParseString(data, position)
+	record.Method, position = util.ParseString(data, position)
+	record.Confirmed, position = util.ParseBool(data, position)
+	return record, position == len(data)
+}
+
+// DirectoryEntry names a known attorney token. Entries removed by admins are
+// persisted with Active false.
+type DirectoryEntry struct {
+	Token       crypto.Token
+	Name        string
+	Description string
+	Homepage    string
+	Icon        string
+	Active      bool
+}
+
+func (e DirectoryEntry) Serialize() []byte {
+	bytes := []byte{DirectoryKind}
+	util.PutToken(e.Token, &bytes)
+	util.PutString(e.Name, &bytes)
+	util.PutString(e.Description, &bytes)
+	util.PutString(e.Homepage, &bytes)
+	util.PutString(e.Icon, &bytes)
+	util.PutBool(e.Active, &bytes)
+	return bytes
+}
+
+func ParseDirectoryEntry(data []byte) (DirectoryEntry, bool) {
+	var entry DirectoryEntry
+	if data[0] != DirectoryKind {
+		return entry, false
+	}
+	position := 1
+	entry.Token, position = util.ParseToken(data, position)
+	entry.Name, position = util.ParseString(data, position)
+	entry.Description, position = util.ParseString(data, position)
+	entry.Homepage, position = util.ParseString(data, position)
+	entry.Icon, position = util.ParseString(data, position)
+	entry.Active, position = util.ParseBool(data, position)
+	return entry, position == len(data)
+}
+
+// TOTPSecret is the second factor of a user. It is persisted with Enabled
+// false while the enrollment is not confirmed and with an empty Secret once
+// it is removed. Recovery holds the hashes of the unused recovery codes.
+type TOTPSecret struct {
+	Handle   string
+	Secret   []byte
+	Enabled  bool
+	Recovery []crypto.Hash
+}
+
+func (t TOTPSecret) Serialize() []byte {
+	bytes := []byte{TOTPKind}
+	util.PutString(t.Handle, &bytes)
+	util.PutByteArray(t.Secret, &bytes)
+	util.PutBool(t.Enabled, &bytes)
+	util.PutUint16(uint16(len(t.Recovery)), &bytes)
+	for _, code := range t.Recovery {
+		util.PutHash(code, &bytes)
+	}
+	return bytes
+}
+
+func ParseTOTPSecret(data []byte) (TOTPSecret, bool) {
+	var totp TOTPSecret
+	if data[0] != TOTPKind {
+		return totp, false
+	}
+	position := 1
+	totp.Handle, position = util.ParseString(data, position)
+	totp.Secret, position = util.ParseByteArray(data, position)
+	totp.Enabled, position = util.ParseBool(data, position)
+	var count uint16
+	count, position = util.ParseUint16(data, position)
+	totp.Recovery = make([]crypto.Hash, count)
+	for n := 0; n < int(count); n++ {
+		totp.Recovery[n], position = util.ParseHash(data, position)
+	}
+	return totp, position == len(data)
+}
+
+// WebAuthnCredential is a credential registered by a user. PublicKey is the
+// COSE encoded key. Removed credentials are persisted with Active false.
+type WebAuthnCredential struct {
+	Handle       string
+	ID           []byte
+	PublicKey    []byte
+	SignCount    uint32
+	Name         string
+	SecondFactor bool
+	Created      uint64
+	Active       bool
+}
+
+func (c WebAuthnCredential) Serialize() []byte {
+	bytes := []byte{WebAuthnKind}
+	util.PutString(c.Handle, &bytes)
+	util.PutByteArray(c.ID, &bytes)
+	util.PutByteArray(c.PublicKey, &bytes)
+	util.PutUint32(c.SignCount, &bytes)
+	util.PutString(c.Name, &bytes)
+	util.PutBool(c.SecondFactor, &bytes)
+	util.PutUint64(c.Created, &bytes)
+	util.PutBool(c.Active, &bytes)
+	return bytes
+}
+
+func ParseWebAuthnCredential(data []byte) (WebAuthnCredential, bool) {
+	var credential WebAuthnCredential
+	if data[0] != WebAuthnKind {
+		return credential, false
+	}
+	position := 1
+	credential.Handle, position = util.ParseString(data, position)
+	credential.ID, position = util.ParseByteArray(data, position)
+	credential.PublicKey, position = util.ParseByteArray(data, position)
+	credential.SignCount, position = util.ParseUint32(data, position)
+	credential.Name, position = util.ParseString(data, position)
+	credential.SecondFactor, position = util.ParseBool(data, position)
+	credential.Created, position = util.ParseUint64(data, position)
+	credential.Active, position = util.ParseBool(data, position)
+	return credential, position == len(data)
+}
+
+// NetworkHandle is a handle seen joining the network on chain, by this or
+// any other safe or wallet.
+type NetworkHandle struct {
+	Handle string
+	Token  crypto.Token
+	Epoch  uint64
+}
+
+func (n NetworkHandle) Serialize() []byte {
+	bytes := []byte{NetworkHandleKind}
+	util.PutString(n.Handle, &bytes)
+	util.PutToken(n.Token, &bytes)
+	util.PutUint64(n.Epoch, &bytes)
+	return bytes
+}
+
+func ParseNetworkHandle(data []byte) (NetworkHandle, bool) {
+	var network NetworkHandle
+	if data[0] != NetworkHandleKind {
+		return network, false
+	}
+	position := 1
+	network.Handle, position = util.ParseString(data, position)
+	network.Token, position = util.ParseToken(data, position)
+	network.Epoch, position = util.ParseUint64(data, position)
+	return network, position == len(data)
+}
+
+// UserLanguage is the language chosen by the user for the web pages.
+// An empty Language goes back to the one asked by the browser.
+type UserLanguage struct {
+	Handle   string
+	Language string
+}
+
+func (u UserLanguage) Serialize() []byte {
+	bytes := []byte{LanguageKind}
+	util.PutString(u.Handle, &bytes)
+	util.PutString(u.Language, &bytes)
+	return bytes
+}
+
+func ParseUserLanguage(data []byte) (UserLanguage, bool) {
+	var language UserLanguage
+	if data[0] != LanguageKind {
+		return language, false
+	}
+	position := 1
+	language.Handle, position = util.ParseString(data, position)
+	language.Language, position = util.ParseString(data, position)
+	return language, position == len(data)
+}
+
+// SessionRecord opens or ends a web session of handle. Sessions are kept in
+// the vault so that they can be ended after a restart: the cookie store
+// cannot list the sessions of a user.
+type SessionRecord struct {
+	Handle string
+	Cookie string
+	Active bool
+}
+
+func (r SessionRecord) Serialize() []byte {
+	bytes := []byte{SessionKind}
+	util.PutString(r.Handle, &bytes)
+	util.PutString(r.Cookie, &bytes)
+	util.PutBool(r.Active, &bytes)
+	return bytes
+}
+
+func ParseSessionRecord(data []byte) (SessionRecord, bool) {
+	var record SessionRecord
+	if data[0] != SessionKind {
+		return record, false
+	}
+	position := 1
+	record.Handle, position = util.ParseString(data, position)
+	record.Cookie, position = util.ParseString(data, position)
+	record.Active, position = util.ParseBool(data, position)
+	return record, position == len(data)
+}
+
+// Vault keeps the secrets of the safe in memory and appends every change to
+// the encrypted vault file. mu serializes the appends and guards the maps,
+// since ingestion, tickers and HTTP handlers all use the vault.
 type Vault struct {
-	vault  *util.SecureVault
-	handle map[string]*UserSecret
+	mu       sync.RWMutex
+	vault    *util.SecureVault
+	handle   map[string]*UserSecret
+	scopes   map[string]map[crypto.Token][]string
+	webhooks map[string]Webhook
+	expiry   map[string]map[crypto.Token]Expiry
+	frozen   map[string]bool
+	records  map[string]map[crypto.Token]AttorneyRecord
+	apps     map[crypto.Token]DirectoryEntry
+	totp     map[string]TOTPSecret
+	// webauthn credentials by hex encoded credential id
+	webauthn map[string]WebAuthnCredential
+	// language chosen by each user
+	language map[string]string
+	// active session cookies of each user
+	sessions map[string]map[string]struct{}
 }
 
 func (v *Vault) Close() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
 	v.vault.Close()
 }
 
 func (v *Vault) Check(handle, password string) bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
 	hashed := crypto.Hasher([]byte(password))
 	if user, ok := v.handle[handle]; ok {
 		return user.Password.Equal(hashed)
@@ -61,6 +469,8 @@ func (v *Vault) Check(handle, password string) bool {
 }
 
 func (v *Vault) HandleToEmail(handle string) string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
 	if user, ok := v.handle[handle]; ok && user != nil {
 		return user.Email
 	}
@@ -68,13 +478,26 @@ func (v *Vault) HandleToEmail(handle string) string {
 }
 
 func (v *Vault) HandleToEmailAndToken(handle string) (string, crypto.Token) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
 	if user, ok := v.handle[handle]; ok && user != nil {
 		return user.Email, user.Secret.PublicKey()
 	}
 	return "", crypto.ZeroToken
 }
 
+// UserSecret returns the secrets of handle. Secrets are replaced, never
+// changed, so the result can be used without the lock.
+func (v *Vault) UserSecret(handle string) (*UserSecret, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	user, ok := v.handle[handle]
+	return user, ok
+}
+
 func (v *Vault) FindHandle(handle, email string) *UserSecret {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
 	if user, ok := v.handle[handle]; ok {
 		if user.Email == email {
 			return user
@@ -84,6 +507,8 @@ func (v *Vault) FindHandle(handle, email string) *UserSecret {
 }
 
 func (v *Vault) FindEmail(email string) []*UserSecret {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
 	users := make([]*UserSecret, 0)
 	for _, user := range v.handle {
 		if user.Email == email {
@@ -94,6 +519,8 @@ func (v *Vault) FindEmail(email string) []*UserSecret {
 }
 
 func (v *Vault) UpdateUser(handle, password, email string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
 	if user, ok := v.handle[handle]; ok {
 		updated := UserSecret{
 			Handle:   handle,
@@ -131,20 +558,403 @@ func OpenVaultFromPassword(passwd []byte, path string) (*Vault, error) {
 		return nil, err
 	}
 	newVault := Vault{
-		vault:  vault,
-		handle: make(map[string]*UserSecret),
+		vault:    vault,
+		handle:   make(map[string]*UserSecret),
+		scopes:   make(map[string]map[crypto.Token][]string),
+		webhooks: make(map[string]Webhook),
+		expiry:   make(map[string]map[crypto.Token]Expiry),
+		frozen:   make(map[string]bool),
+		records:  make(map[string]map[crypto.Token]AttorneyRecord),
+		apps:     make(map[crypto.Token]DirectoryEntry),
+		totp:     make(map[string]TOTPSecret),
+		webauthn: make(map[string]WebAuthnCredential),
+		language: make(map[string]string),
+		sessions: make(map[string]map[string]struct{}),
 	}
 	for _, entry := range vault.Entries {
-		if len(entry) > 0 && entry[0] == UserSecretKind {
+		if len(entry) == 0 {
+			continue
+		}
+		switch entry[0] {
+		case UserSecretKind:
 			if user, ok := ParseUserSecret(entry); ok {
 				newVault.handle[user.Handle] = &user
 			}
+		case AttorneyScopesKind:
+			if scopes, ok := ParseAttorneyScopes(entry); ok {
+				newVault.putScopes(scopes)
+			}
+		case AttorneyExpiryKind:
+			if expiry, ok := ParseAttorneyExpiry(entry); ok {
+				newVault.putExpiry(expiry.Handle, expiry.Attorney, expiry.Expiry())
+			}
+		case FrozenKind:
+			if frozen, ok := ParseFrozenAccount(entry); ok {
+				newVault.frozen[frozen.Handle] = frozen.Frozen
+			}
+		case AttorneyRecordKind:
+			if record, ok := ParseAttorneyRecord(entry); ok {
+				newVault.putRecord(record)
+			}
+		case DirectoryKind:
+			if app, ok := ParseDirectoryEntry(entry); ok {
+				if app.Active {
+					newVault.apps[app.Token] = app
+				} else {
+					delete(newVault.apps, app.Token)
+				}
+			}
+		case TOTPKind:
+			if totp, ok := ParseTOTPSecret(entry); ok {
+				if len(totp.Secret) > 0 {
+					newVault.totp[totp.Handle] = totp
+				} else {
+					delete(newVault.totp, totp.Handle)
+				}
+			}
+		case WebAuthnKind:
+			if credential, ok := ParseWebAuthnCredential(entry); ok {
+				newVault.putCredential(credential)
+			}
+		case LanguageKind:
+			if language, ok := ParseUserLanguage(entry); ok {
+				newVault.language[language.Handle] = language.Language
+			}
+		case SessionKind:
+			if record, ok := ParseSessionRecord(entry); ok {
+				newVault.putSession(record)
+			}
+		case WebhookKind:
+			if hook, ok := ParseWebhook(entry); ok {
+				if hook.Active {
+					newVault.webhooks[hook.ID] = hook
+				} else {
+					delete(newVault.webhooks, hook.ID)
+				}
+			}
 		}
 	}
 	return &newVault, nil
 }
 
+func (v *Vault) putScopes(scopes AttorneyScopes) {
+	if _, ok := v.scopes[scopes.Handle]; !ok {
+		v.scopes[scopes.Handle] = make(map[crypto.Token][]string)
+	}
+	v.scopes[scopes.Handle][scopes.Attorney] = scopes.Scopes
+}
+
+func (v *Vault) SetScopes(handle string, attorney crypto.Token, scopes []string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.handle[handle]; !ok {
+		return errors.New("user not found")
+	}
+	entry := AttorneyScopes{Handle: handle, Attorney: attorney, Scopes: scopes}
+	if err := v.vault.NewEntry(entry.Serialize()); err != nil {
+		return err
+	}
+	v.putScopes(entry)
+	return nil
+}
+
+// HandleScopes returns a copy of the scopes granted by handle to each of its
+// attorneys.
+func (v *Vault) HandleScopes(handle string) map[crypto.Token][]string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	scopes := make(map[crypto.Token][]string)
+	for attorney, granted := range v.scopes[handle] {
+		scopes[attorney] = granted
+	}
+	return scopes
+}
+
+func (v *Vault) putExpiry(handle string, attorney crypto.Token, expiry Expiry) {
+	if expiry.IsZero() {
+		delete(v.expiry[handle], attorney)
+		return
+	}
+	if _, ok := v.expiry[handle]; !ok {
+		v.expiry[handle] = make(map[crypto.Token]Expiry)
+	}
+	v.expiry[handle][attorney] = expiry
+}
+
+func (v *Vault) SetExpiry(handle string, attorney crypto.Token, expiry Expiry) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.handle[handle]; !ok {
+		return errors.New("user not found")
+	}
+	entry := AttorneyExpiry{Handle: handle, Attorney: attorney, Epoch: expiry.Epoch}
+	if !expiry.Time.IsZero() {
+		entry.Time = uint64(expiry.Time.Unix())
+	}
+	if err := v.vault.NewEntry(entry.Serialize()); err != nil {
+		return err
+	}
+	v.putExpiry(handle, attorney, expiry)
+	return nil
+}
+
+// HandleExpiry returns a copy of the expiry of each attorney of handle.
+func (v *Vault) HandleExpiry(handle string) map[crypto.Token]Expiry {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	expiry := make(map[crypto.Token]Expiry)
+	for attorney, bound := range v.expiry[handle] {
+		expiry[attorney] = bound
+	}
+	return expiry
+}
+
+func (v *Vault) SetFrozen(handle string, frozen bool) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.handle[handle]; !ok {
+		return errors.New("user not found")
+	}
+	entry := FrozenAccount{Handle: handle, Frozen: frozen}
+	if err := v.vault.NewEntry(entry.Serialize()); err != nil {
+		return err
+	}
+	v.frozen[handle] = frozen
+	return nil
+}
+
+func (v *Vault) IsFrozen(handle string) bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.frozen[handle]
+}
+
+// SetLanguage records the language chosen by handle, empty to follow the
+// browser.
+func (v *Vault) SetLanguage(handle, language string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.handle[handle]; !ok {
+		return errors.New("user not found")
+	}
+	entry := UserLanguage{Handle: handle, Language: language}
+	if err := v.vault.NewEntry(entry.Serialize()); err != nil {
+		return err
+	}
+	v.language[handle] = language
+	return nil
+}
+
+func (v *Vault) Language(handle string) string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.language[handle]
+}
+
+func (v *Vault) putSession(record SessionRecord) {
+	if !record.Active {
+		delete(v.sessions[record.Handle], record.Cookie)
+		if len(v.sessions[record.Handle]) == 0 {
+			delete(v.sessions, record.Handle)
+		}
+		return
+	}
+	if _, ok := v.sessions[record.Handle]; !ok {
+		v.sessions[record.Handle] = make(map[string]struct{})
+	}
+	v.sessions[record.Handle][record.Cookie] = struct{}{}
+}
+
+// SetSession records that the session cookie of handle was opened or ended.
+func (v *Vault) SetSession(handle, cookie string, active bool) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.handle[handle]; !ok {
+		return errors.New("user not found")
+	}
+	record := SessionRecord{Handle: handle, Cookie: cookie, Active: active}
+	if err := v.vault.NewEntry(record.Serialize()); err != nil {
+		return err
+	}
+	v.putSession(record)
+	return nil
+}
+
+// HandleSessions returns the active session cookies of handle.
+func (v *Vault) HandleSessions(handle string) map[string]struct{} {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	sessions := make(map[string]struct{})
+	for cookie := range v.sessions[handle] {
+		sessions[cookie] = struct{}{}
+	}
+	return sessions
+}
+
+func (v *Vault) putRecord(record AttorneyRecord) {
+	if _, ok := v.records[record.Handle]; !ok {
+		v.records[record.Handle] = make(map[crypto.Token]AttorneyRecord)
+	}
+	v.records[record.Handle][record.Attorney] = record
+}
+
+func (v *Vault) SaveRecord(record AttorneyRecord) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.handle[record.Handle]; !ok {
+		return errors.New("user not found")
+	}
+	if err := v.vault.NewEntry(record.Serialize()); err != nil {
+		return err
+	}
+	v.putRecord(record)
+	return nil
+}
+
+// Record returns the latest record of a grant from handle to attorney.
+func (v *Vault) Record(handle string, attorney crypto.Token) (AttorneyRecord, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	record, ok := v.records[handle][attorney]
+	return record, ok
+}
+
+// HandleRecords returns the latest record of every grant signed by handle.
+func (v *Vault) HandleRecords(handle string) []AttorneyRecord {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	records := make([]AttorneyRecord, 0, len(v.records[handle]))
+	for _, record := range v.records[handle] {
+		records = append(records, record)
+	}
+	return records
+}
+
+func (v *Vault) SaveDirectoryEntry(entry DirectoryEntry) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.vault.NewEntry(entry.Serialize()); err != nil {
+		return err
+	}
+	if entry.Active {
+		v.apps[entry.Token] = entry
+	} else {
+		delete(v.apps, entry.Token)
+	}
+	return nil
+}
+
+// DirectoryEntries returns the directory entries managed by admins.
+func (v *Vault) DirectoryEntries() []DirectoryEntry {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	entries := make([]DirectoryEntry, 0, len(v.apps))
+	for _, entry := range v.apps {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (v *Vault) SetTOTP(totp TOTPSecret) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.handle[totp.Handle]; !ok {
+		return errors.New("user not found")
+	}
+	if err := v.vault.NewEntry(totp.Serialize()); err != nil {
+		return err
+	}
+	if len(totp.Secret) > 0 {
+		v.totp[totp.Handle] = totp
+	} else {
+		delete(v.totp, totp.Handle)
+	}
+	return nil
+}
+
+func (v *Vault) TOTP(handle string) (TOTPSecret, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	totp, ok := v.totp[handle]
+	return totp, ok
+}
+
+func (v *Vault) putCredential(credential WebAuthnCredential) {
+	id := hex.EncodeToString(credential.ID)
+	if credential.Active {
+		v.webauthn[id] = credential
+	} else {
+		delete(v.webauthn, id)
+	}
+}
+
+func (v *Vault) SaveCredential(credential WebAuthnCredential) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.handle[credential.Handle]; !ok {
+		return errors.New("user not found")
+	}
+	if err := v.vault.NewEntry(credential.Serialize()); err != nil {
+		return err
+	}
+	v.putCredential(credential)
+	return nil
+}
+
+func (v *Vault) Credential(id []byte) (WebAuthnCredential, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	credential, ok := v.webauthn[hex.EncodeToString(id)]
+	return credential, ok
+}
+
+// HandleCredentials returns the webauthn credentials of handle.
+func (v *Vault) HandleCredentials(handle string) []WebAuthnCredential {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	credentials := make([]WebAuthnCredential, 0)
+	for _, credential := range v.webauthn {
+		if credential.Handle == handle {
+			credentials = append(credentials, credential)
+		}
+	}
+	return credentials
+}
+
+func (v *Vault) SaveWebhook(hook Webhook) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.vault.NewEntry(hook.Serialize()); err != nil {
+		return err
+	}
+	if hook.Active {
+		v.webhooks[hook.ID] = hook
+	} else {
+		delete(v.webhooks, hook.ID)
+	}
+	return nil
+}
+
+func (v *Vault) Webhook(id string) (Webhook, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	hook, ok := v.webhooks[id]
+	return hook, ok
+}
+
+func (v *Vault) Webhooks() []Webhook {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	hooks := make([]Webhook, 0, len(v.webhooks))
+	for _, hook := range v.webhooks {
+		hooks = append(hooks, hook)
+	}
+	return hooks
+}
+
 func (v *Vault) NewUser(handle, password, email string) (crypto.Token, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
 	if _, ok := v.handle[handle]; ok {
 		return crypto.ZeroToken, errors.New("handle already in use")
 	}
@@ -190,9 +1000,14 @@ type SafeDatabaseConfig struct {
 }
 
 type SafeDatabase struct {
+	mu           sync.Mutex
 	file         *os.File
 	actionOffset []lengthOffset
 	tokenIndex   map[crypto.Hash][]int
+	indexer      func([]byte) []crypto.Hash
+	// hashes of the stored actions, since ingestion sees actions again
+	// after a restart
+	stored map[crypto.Hash]struct{}
 }
 
 func OpenSafeDatabase(path string, indexer func([]byte) []crypto.Hash) (*SafeDatabase, error) {
@@ -204,6 +1019,8 @@ func OpenSafeDatabase(path string, indexer func([]byte) []crypto.Hash) (*SafeDat
 		file:         file,
 		tokenIndex:   make(map[crypto.Hash][]int),
 		actionOffset: make([]lengthOffset, 0),
+		indexer:      indexer,
+		stored:       make(map[crypto.Hash]struct{}),
 	}
 	offset := int64(0)
 	lengthBytes := make([]byte, 2)
@@ -221,19 +1038,40 @@ func OpenSafeDatabase(path string, indexer func([]byte) []crypto.Hash) (*SafeDat
 		if n != length {
 			return nil, fmt.Errorf("could not read action at position %d: %v", offset+2, err)
 		}
-		db.actionOffset = append(db.actionOffset, lengthOffset{offset, length})
+		db.index(bytes, offset)
 		offset += int64(2 + length)
-		for _, hash := range indexer(bytes) {
-			db.tokenIndex[hash] = append(db.tokenIndex[hash], len(db.actionOffset)-1)
-		}
 	}
 }
 
+// index adds the action stored at offset to the token index. Copies of an
+// action already indexed, stored before duplicates were refused, are
+// skipped.
+func (s *SafeDatabase) index(action []byte, offset int64) {
+	hash := crypto.Hasher(action)
+	if _, ok := s.stored[hash]; ok {
+		return
+	}
+	s.stored[hash] = struct{}{}
+	s.actionOffset = append(s.actionOffset, lengthOffset{offset, len(action)})
+	for _, hash := range s.indexer(action) {
+		s.tokenIndex[hash] = append(s.tokenIndex[hash], len(s.actionOffset)-1)
+	}
+}
+
+// SaveAction stores msg unless the same action is already stored.
 func (s *SafeDatabase) SaveAction(msg []byte) error {
 	if len(msg) > 1<<16-1 {
 		return errors.New("message too large")
 	}
-	s.file.Seek(0, 2)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.stored[crypto.Hasher(msg)]; ok {
+		return nil
+	}
+	offset, err := s.file.Seek(0, 2)
+	if err != nil {
+		return err
+	}
 	bytes := make([]byte, 0)
 	util.PutUint16(uint16(len(msg)), &bytes)
 	bytes = append(bytes, msg...)
@@ -242,10 +1080,13 @@ func (s *SafeDatabase) SaveAction(msg []byte) error {
 	} else if n != len(bytes) {
 		return errors.New("failed to write all bytes")
 	}
+	s.index(msg, offset)
 	return nil
 }
 
 func (s *SafeDatabase) LoadIndexedActions(hash crypto.Hash) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	actions := make([][]byte, 0)
 	for _, actionSeq := range s.tokenIndex[hash] {
 		length := s.actionOffset[actionSeq].length
@@ -0,0 +1,39 @@
+package safe
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func TestSaveActionSkipsStoredActions(t *testing.T) {
+	key := crypto.Hasher([]byte("alice"))
+	indexer := func([]byte) []crypto.Hash { return []crypto.Hash{key} }
+	path := filepath.Join(t.TempDir(), "actions.dat")
+	db, err := OpenSafeDatabase(path, indexer)
+	if err != nil {
+		t.Fatalf("OpenSafeDatabase: %v", err)
+	}
+	defer func() { db.file.Close() }()
+	grant, join := []byte("grant action"), []byte("join action")
+	// a restart ingests the same actions again
+	for _, action := range [][]byte{grant, join, grant, join} {
+		if err := db.SaveAction(action); err != nil {
+			t.Fatalf("SaveAction: %v", err)
+		}
+	}
+	if actions, _ := db.LoadIndexedActions(key); len(actions) != 2 {
+		t.Errorf("LoadIndexedActions = %q, want each action once", actions)
+	}
+	db.file.Close()
+	if db, err = OpenSafeDatabase(path, indexer); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if err := db.SaveAction(grant); err != nil {
+		t.Fatalf("SaveAction: %v", err)
+	}
+	if actions, _ := db.LoadIndexedActions(key); len(actions) != 2 {
+		t.Errorf("LoadIndexedActions after reopen = %q, want each action once", actions)
+	}
+}
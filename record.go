@@ -0,0 +1,112 @@
+package safe
+
+import (
+	"log"
+	"sort"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/handles/attorney"
+)
+
+// Methods by which a power of attorney is granted.
+const (
+	GrantWeb     = "web"
+	GrantREST    = "rest"
+	GrantConfirm = "confirm"
+	GrantOIDC    = "oidc"
+	// GrantNetwork marks grants seen on chain that were not signed through
+	// this safe, or whose record was lost.
+	GrantNetwork = "network"
+)
+
+// grantMethodKeys are the catalog keys of the descriptions of the methods.
+var grantMethodKeys = map[string]string{
+	GrantWeb:     "method.web",
+	GrantREST:    "method.rest",
+	GrantConfirm: "method.confirm",
+	GrantOIDC:    "method.oidc",
+	GrantNetwork: "method.network",
+}
+
+// GrantOrigin tells how a grant was requested and on behalf of which app.
+type GrantOrigin struct {
+	Method string
+	App    string
+}
+
+// recordGrant persists the record of a grant just sent.
+func (s *Safe) recordGrant(handle string, grant *attorney.GrantPowerOfAttorney, origin GrantOrigin) error {
+	record := AttorneyRecord{
+		Handle:      handle,
+		Attorney:    grant.Attorney,
+		Epoch:       grant.Epoch,
+		Fingerprint: string(grant.Fingerprint),
+		App:         origin.App,
+		Method:      origin.Method,
+	}
+	return s.vault.SaveRecord(record)
+}
+
+// confirmRecord attaches to user the record of a grant incorporated in a
+// block, marking it as confirmed. Grants without a record of the same
+// epoch get a record in memory only, since the chain is their source.
+func (s *Safe) confirmRecord(handle string, user *User, grant *attorney.GrantPowerOfAttorney) {
+	record, ok := s.vault.Record(handle, grant.Attorney)
+	if !ok || record.Epoch != grant.Epoch {
+		user.Records[grant.Attorney] = AttorneyRecord{
+			Handle:      handle,
+			Attorney:    grant.Attorney,
+			Epoch:       grant.Epoch,
+			Fingerprint: string(grant.Fingerprint),
+			Method:      GrantNetwork,
+			Confirmed:   true,
+		}
+		return
+	}
+	if !record.Confirmed {
+		record.Confirmed = true
+		if err := s.vault.SaveRecord(record); err != nil {
+			log.Printf("could not confirm attorney record: %v", err)
+		}
+	}
+	user.Records[grant.Attorney] = record
+}
+
+// shortToken abbreviates a token for display.
+func shortToken(token crypto.Token) string {
+	hex := token.Hex()
+	return hex[:8] + "…" + hex[len(hex)-8:]
+}
+
+func (s *Safe) attorneyView(user *User, record AttorneyRecord, language string) AttorneyView {
+	method := record.Method
+	if key, ok := grantMethodKeys[record.Method]; ok {
+		method = Translate(language, key)
+	}
+	return AttorneyView{
+		Token:       record.Attorney.Hex(),
+		Short:       shortToken(record.Attorney),
+		App:         record.App,
+		Info:        s.directory.Lookup(record.Attorney),
+		Method:      method,
+		Epoch:       record.Epoch,
+		Fingerprint: record.Fingerprint,
+		Scopes:      user.Scopes[record.Attorney],
+		Confirmed:   record.Confirmed,
+		Expires:     user.Expiry[record.Attorney].Describe(language),
+	}
+}
+
+// awaitingViews lists grants sent by the safe that are not yet on chain,
+// newest first.
+func (s *Safe) awaitingViews(handle string, user *User, language string) []AttorneyView {
+	views := make([]AttorneyView, 0)
+	for _, record := range s.vault.HandleRecords(handle) {
+		if record.Confirmed || user.IsAttorney(record.Attorney) {
+			continue
+		}
+		views = append(views, s.attorneyView(user, record, language))
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Epoch > views[j].Epoch })
+	return views
+}
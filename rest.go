@@ -21,11 +21,12 @@ type AttorneyRequest struct {
 }
 
 type UserRequest struct {
-	Handle        string `json:"handle"`
-	Email         string `json:"email,omitempty"`
-	Password      string `json:"password,omitempty"`
-	AttorneyToken string `json:"attorney_token"`
-	App           string `json:"app,omitempty"`
+	Handle        string   `json:"handle"`
+	Email         string   `json:"email,omitempty"`
+	Password      string   `json:"password,omitempty"`
+	AttorneyToken string   `json:"attorney_token"`
+	App           string   `json:"app,omitempty"`
+	Scopes        []string `json:"scopes,omitempty"`
 }
 
 /*type GrantRequest struct {
@@ -36,17 +37,131 @@ type UserRequest struct {
 */
 
 type APIResponse struct {
-	Status  string `json:"status"`
-	Message string `json:"message,omitempty"`
-	Token   string `json:"token,omitempty"`
-	Verify  string `json:"verify,omitempty"`
+	Status  string   `json:"status"`
+	Message string   `json:"message,omitempty"`
+	Token   string   `json:"token,omitempty"`
+	Verify  string   `json:"verify,omitempty"`
+	Scopes  []string `json:"scopes,omitempty"`
 }
 
 func (rest *RestAPI) userExists(handle string) bool {
-	// Verificar se o usuário existe tentando criar uma sessão
-	// Se retornar string vazia, o usuário não existe
-	session := rest.Safe.CreateSession(handle)
-	return session != ""
+	rest.Safe.mu.RLock()
+	defer rest.Safe.mu.RUnlock()
+	_, ok := rest.Safe.users[handle]
+	return ok
+}
+
+// authenticated tells whether r carries a valid session or the signature of
+// a known app, one in the directory or holding power of attorney from some
+// user. Anyone can sign with a fresh key, so a signature alone is not
+// enough. Only authenticated callers are told whether a handle exists. It
+// must be called before the body of r is read.
+func (rest *RestAPI) authenticated(r *http.Request) bool {
+	if rest.Safe.BearerHandle(r) != "" {
+		return true
+	}
+	app, err := VerifyAppRequest(r)
+	if err != nil {
+		return false
+	}
+	return rest.Safe.directory.Lookup(app).Verified || rest.Safe.grantors.Granted(app)
+}
+
+// allowProbe answers with 429 and returns false if the unauthenticated
+// caller of r asked about too many handles.
+func (rest *RestAPI) allowProbe(w http.ResponseWriter, r *http.Request, authenticated bool) bool {
+	if authenticated {
+		return true
+	}
+	if err := rest.Safe.throttle.CheckIP(clientIP(r)); err != nil {
+		writeThrottled(w, rest.Safe.Language(r), err)
+		return false
+	}
+	return true
+}
+
+// hidesHandles tells whether the existence of a handle must be hidden from
+// the caller of r. The question is counted against unauthenticated
+// callers.
+func (rest *RestAPI) hidesHandles(r *http.Request, authenticated bool) bool {
+	if authenticated {
+		return false
+	}
+	rest.Safe.throttle.Probe(clientIP(r))
+	return true
+}
+
+// newPending signs a grant from handle to the attorney token and keeps it
+// waiting for the user consent. It returns the pending secret and the
+// confirmation url to be forwarded to the user. If hide is set, unknown and
+// frozen handles are not revealed: a decoy without grant is kept instead
+// and answered like any other pending request.
+func (rest *RestAPI) newPending(handle, attorney, app string, scopes []string, hide bool, language string) (string, string, *APIError) {
+	token, ok := attorneyToken(attorney)
+	if !ok {
+		return "", "", &APIError{Code: ErrInvalidAttorney, Message: Translate(language, "api.invalid_attorney")}
+	}
+	pending := PendingGrant{Handle: handle, Attorney: token, Scopes: ParseScopes(scopes), App: app}
+	grant, err := rest.Safe.GrantAction(handle, attorney)
+	switch {
+	case err == nil:
+		pending.Grant = grant
+	case hide:
+	case err == ErrAccountFrozen:
+		return "", "", &APIError{Code: ErrFrozen, Message: Translate(language, "api.frozen")}
+	default:
+		return "", "", &APIError{Code: ErrUserNotFound, Message: Translate(language, "api.user_not_found")}
+	}
+	random, _ := crypto.RandomAsymetricKey()
+	secret := random.Hex()
+	if err := rest.Safe.NewPending(secret, pending); err != nil {
+		return "", "", &APIError{Code: ErrBusy, Message: Translate(language, "api.busy")}
+	}
+	return secret, rest.Safe.PublicURL("confirm/" + secret), nil
+}
+
+// createUser creates a new user and asks it for power of attorney to the
+// app that signed the user up. Signing up through the app is not consent
+// to the scopes: the grant waits for the user on the confirmation page like
+// any other request. It returns the pending secret and the confirmation url.
+// Messages are in language.
+func (rest *RestAPI) createUser(req UserRequest, language string) (string, string, *APIError) {
+	handle, err := rest.Safe.CheckHandle(req.Handle)
+	if err != nil {
+		return "", "", handleError(err, language)
+	}
+	req.Handle = handle
+	if rest.Safe.HandleTaken(handle) {
+		return "", "", &APIError{Code: ErrHandleUnavailable, Message: Translate(language, "api.handle_unavailable")}
+	}
+	if req.Password == "" {
+		return "", "", &APIError{Code: ErrPasswordRequired, Message: Translate(language, "api.password_required")}
+	}
+	if _, ok := attorneyToken(req.AttorneyToken); !ok {
+		return "", "", &APIError{Code: ErrInvalidAttorney, Message: Translate(language, "api.invalid_attorney")}
+	}
+	if success, _ := rest.Safe.SigninWithToken(req.Handle, req.Password, req.Email); !success {
+		return "", "", &APIError{Code: ErrCreateFailed, Message: Translate(language, "api.create_failed")}
+	}
+	id, verify, apiErr := rest.newPending(req.Handle, req.AttorneyToken, req.App, req.Scopes, false, language)
+	if apiErr != nil {
+		return "", "", &APIError{Code: ErrGrantFailed, Message: Translate(language, "api.grant_failed", apiErr.Message)}
+	}
+	return id, verify, nil
+}
+
+// handleError converts a violation of the handle policy.
+func handleError(err error, language string) *APIError {
+	if violation, ok := err.(*HandleError); ok {
+		return &APIError{Code: violation.Code, Message: translateError(language, violation)}
+	}
+	return &APIError{Code: ErrInvalidHandle, Message: err.Error()}
+}
+
+// deprecated marks responses of the routes superseded by the v1 API.
+func deprecated(w http.ResponseWriter, successor string) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", fmt.Sprintf("<%v>; rel=\"successor-version\"", successor))
 }
 
 /*func (rest *RestAPI) userEmail(handle string) string {
@@ -57,48 +172,104 @@ func (rest *RestAPI) userEmailAndToken(handle string) (string, crypto.Token) {
 	return rest.Safe.EmailAndToken(handle)
 }
 
+// granted returns true if handle has granted power of attorney to the
+// attorney token (in hex) together with the scopes of the grant.
+func (rest *RestAPI) granted(handle, attorneyToken string) (bool, []string) {
+	for _, attorney := range rest.Safe.UserAttorneys(handle) {
+		if attorneyToken == attorney.String() {
+			return true, rest.Safe.AttorneyScopes(handle, attorney)
+		}
+	}
+	return false, nil
+}
+
+// revealed returns the email and token of the user as allowed by scopes.
+func (rest *RestAPI) revealed(handle string, scopes []string) (string, string) {
+	email, token := rest.userEmailAndToken(handle)
+	revealedEmail, revealedToken := "", ""
+	if HasScope(scopes, ScopeEmail) {
+		revealedEmail = email
+	}
+	if HasScope(scopes, ScopeProfile) {
+		revealedToken = token.String()
+	}
+	return revealedEmail, revealedToken
+}
+
+// signer returns the app that signed r, or the zero token if r is not
+// signed. It must be called before the body of r is read.
+func (rest *RestAPI) signer(r *http.Request) crypto.Token {
+	app, err := VerifyAppRequest(r)
+	if err != nil {
+		return crypto.ZeroToken
+	}
+	return app
+}
+
+// signedByAttorney tells whether signer is the attorney token (in hex).
+// Attorney tokens are public on chain, so the data the user shared is only
+// revealed to requests signed by the attorney itself.
+func signedByAttorney(signer crypto.Token, attorney string) bool {
+	token, ok := attorneyToken(attorney)
+	return ok && signer != crypto.ZeroToken && signer.Equal(token)
+}
+
+// reveal fills the response with the user data allowed by scopes.
+func (rest *RestAPI) reveal(handle string, scopes []string, response *APIResponse) {
+	response.Message, response.Token = rest.revealed(handle, scopes)
+	response.Scopes = scopes
+}
+
+// handleAttorneyAPI is deprecated in favor of
+// GET /v1/users/{handle}/attorneys/{token}.
 func (rest *RestAPI) handleAttorneyAPI(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	deprecated(w, "/v1/users/{handle}/attorneys/{token}")
+	language := rest.Safe.Language(r)
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		json.NewEncoder(w).Encode(APIResponse{
 			Status:  "error",
-			Message: "Only POST method is allowed",
+			Message: Translate(language, "api.post_only"),
 		})
 		return
 	}
+	authenticated := rest.authenticated(r)
+	signer := rest.signer(r)
 	var req AttorneyRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(APIResponse{
 			Status:  "error",
-			Message: "Invalid JSON format",
+			Message: Translate(language, "api.invalid_json"),
 		})
 		return
 	}
-	if req.Handle == "" || (!rest.userExists(req.Handle)) {
-		w.WriteHeader(http.StatusBadRequest)
+	if err := rest.Safe.throttle.CheckIP(clientIP(r)); err != nil && !authenticated {
+		w.WriteHeader(http.StatusTooManyRequests)
 		json.NewEncoder(w).Encode(APIResponse{
 			Status:  "error",
-			Message: "Invalid user handle",
+			Message: translateError(language, err),
 		})
 		return
 	}
-	attorneys := rest.Safe.UserAttorneys(req.Handle)
-	granted := false
-	for _, attorney := range attorneys {
-		if req.AttorneyToken == attorney.String() {
-			granted = true
-			break
-		}
-	}
-	email, token := rest.Safe.EmailAndToken(req.Handle)
-	response := APIResponse{
-		Message: email,
-		Token:   token.String(),
+	if req.Handle == "" || (!rest.userExists(req.Handle) && !rest.hidesHandles(r, authenticated)) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIResponse{
+			Status:  "error",
+			Message: Translate(language, "api.invalid_handle"),
+		})
+		return
 	}
-	if granted {
+	// unknown handles are answered as not granted to unauthenticated callers
+	response := APIResponse{}
+	if !rest.userExists(req.Handle) {
+		response.Status = "Not Granted"
+	} else if granted, scopes := rest.granted(req.Handle, req.AttorneyToken); granted {
 		response.Status = "Granted"
+		if signedByAttorney(signer, req.AttorneyToken) {
+			rest.reveal(req.Handle, scopes, &response)
+		}
 	} else {
 		response.Status = "Not Granted"
 	}
@@ -106,24 +277,30 @@ func (rest *RestAPI) handleAttorneyAPI(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// handleAPI is deprecated in favor of POST /v1/users and
+// POST /v1/users/{handle}/pending.
 func (rest *RestAPI) handleAPI(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	deprecated(w, "/v1/users")
+	language := rest.Safe.Language(r)
 
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		json.NewEncoder(w).Encode(APIResponse{
 			Status:  "error",
-			Message: "Only POST method is allowed",
+			Message: Translate(language, "api.post_only"),
 		})
 		return
 	}
 
+	authenticated := rest.authenticated(r)
+	signer := rest.signer(r)
 	var req UserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(APIResponse{
 			Status:  "error",
-			Message: "Invalid JSON format",
+			Message: Translate(language, "api.invalid_json"),
 		})
 		return
 	}
@@ -132,79 +309,100 @@ func (rest *RestAPI) handleAPI(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(APIResponse{
 			Status:  "error",
-			Message: "Handle is required",
+			Message: Translate(language, "handle.required"),
 		})
 		return
 	}
 
-	// Verificar se o handle já existe no safe
-	if rest.userExists(req.Handle) {
-		token, _ := crypto.RandomAsymetricKey()
-		secret := token.Hex()
-		msg := fmt.Sprintf("http://%s/confirm/%s", rest.Safe.address, secret)
-		if rest.Safe.serverName != "" {
-			msg = fmt.Sprintf("http://%s/%sconfirm/%s", rest.Safe.address, rest.Safe.serverName, secret)
-		}
-		grant := rest.Safe.GrantAction(req.Handle, req.AttorneyToken)
-		rest.Safe.NewPending(secret, grant)
-		w.WriteHeader(http.StatusOK)
-		email, token := rest.userEmailAndToken(req.Handle)
+	if err := rest.Safe.throttle.CheckIP(clientIP(r)); err != nil && !authenticated {
+		w.WriteHeader(http.StatusTooManyRequests)
 		json.NewEncoder(w).Encode(APIResponse{
-			Status:  "existente",
-			Message: email,
-			Token:   token.String(),
-			Verify:  msg,
+			Status:  "error",
+			Message: translateError(language, err),
 		})
 		return
 	}
 
-	// Se não existe, criar novo usuário
-	// Usar valores padrão se email ou password não fornecidos
-	email := req.Email
-	password := req.Password
-	if password == "" {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(APIResponse{
-			Status:  "error",
-			Message: "Senha não especificada para usuário novo",
-		})
+	// every request of unauthenticated callers counts as a probe, as in
+	// POST /v1/users/{handle}/pending, whether the handle exists or not
+	hide := rest.hidesHandles(r, authenticated)
+	// the handle already exists in the safe
+	if rest.userExists(req.Handle) {
+		// only authenticated apps are told that the handle exists, others
+		// are answered as on sign up with a handle taken on chain
+		if hide {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIResponse{
+				Status:  "error",
+				Message: Translate(language, "api.handle_unavailable"),
+			})
+			return
+		}
+		_, msg, apiErr := rest.newPending(req.Handle, req.AttorneyToken, req.App, req.Scopes, false, language)
+		if apiErr != nil {
+			if apiErr.Code == ErrBusy {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			} else {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+			json.NewEncoder(w).Encode(APIResponse{
+				Status:  "error",
+				Message: apiErr.Message,
+			})
+			return
+		}
+		response := APIResponse{
+			Status: "existente",
+			Verify: msg,
+		}
+		if granted, scopes := rest.granted(req.Handle, req.AttorneyToken); granted && signedByAttorney(signer, req.AttorneyToken) {
+			rest.reveal(req.Handle, scopes, &response)
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
 		return
 	}
-	// Tentar criar o usuário
-	success, token := rest.Safe.SigninWithToken(req.Handle, password, email)
-	if !success {
-		w.WriteHeader(http.StatusInternalServerError)
+
+	// otherwise a new user is created
+	_, verify, apiErr := rest.createUser(req, language)
+	if apiErr != nil {
+		if apiErr.Code == ErrCreateFailed || apiErr.Code == ErrGrantFailed {
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
 		json.NewEncoder(w).Encode(APIResponse{
 			Status:  "error",
-			Message: "Failed to create user",
+			Message: apiErr.Message,
 		})
 		return
 	}
-	hashToken := crypto.EncodeHash(crypto.HashToken(token))
-	if err := rest.Safe.GrantPower(req.Handle, req.AttorneyToken, hashToken); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(APIResponse{
-			Status:  "error",
-			Message: "User created but failed to grant power of attorney: " + err.Error(),
-		})
-		return
+	response := APIResponse{
+		Status:  "criado",
+		Message: Translate(language, "api.user_created"),
+		Verify:  verify,
+		Scopes:  ParseScopes(req.Scopes),
 	}
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(APIResponse{
-		Status:  "criado",
-		Message: "Usuário criado com sucesso e power of attorney concedido",
-		Token:   token.String(),
-	})
+	json.NewEncoder(w).Encode(response)
 }
 
-func NewSafeRestAPI(port int, safe *Safe) {
+// Handler returns the router of the REST API. The routes outside /v1/ are
+// kept as deprecated aliases. Every response is signed by the safe.
+func (rest *RestAPI) Handler() http.Handler {
 	mux := http.NewServeMux()
-	rest := RestAPI{Safe: safe}
-	mux.HandleFunc("/", rest.handleAPI)
+	mux.HandleFunc("/", rest.idempotent(rest.handleAPI))
 	mux.HandleFunc("/attorney", rest.handleAttorneyAPI)
+	mux.HandleFunc("/v1/", rest.handleV1)
+	mux.HandleFunc(WellKnownPath, rest.Safe.WellKnownHandler)
+	return rest.Safe.signResponses(rest.verifyApps(mux))
+}
+
+func NewSafeRestAPI(port int, safe *Safe) {
+	rest := RestAPI{Safe: safe}
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%v", port),
-		Handler:      mux,
+		Handler:      rest.Handler(),
 		WriteTimeout: 2 * time.Second,
 	}
 	fmt.Println("Safe REST API server started on port", port)
@@ -0,0 +1,133 @@
+package safe
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// appCall sends a request signed by key with a JSON body to the REST API
+// of s.
+func appCall(s *Safe, key crypto.PrivateKey, method, path string, body any) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	r := httptest.NewRequest(method, path, bytes.NewReader(data))
+	SignAppRequest(r, data, key)
+	w := httptest.NewRecorder()
+	(&RestAPI{Safe: s}).Handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestAuthenticated(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	session := testUser(t, s, "alice")
+	rest := RestAPI{Safe: s}
+	signed := func(key crypto.PrivateKey) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/v1/users/alice", nil)
+		SignAppRequest(r, nil, key)
+		return r
+	}
+	stranger, key := crypto.RandomAsymetricKey()
+	if rest.authenticated(signed(key)) {
+		t.Errorf("app signing with a fresh key authenticated")
+	}
+	s.grantors.Grant(stranger, "alice", 1)
+	if !rest.authenticated(signed(key)) {
+		t.Errorf("app holding a grant not authenticated")
+	}
+	listed, key := crypto.RandomAsymetricKey()
+	if _, err := s.directory.Set(AppInfo{Token: listed.Hex(), Name: "App"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if !rest.authenticated(signed(key)) {
+		t.Errorf("app in the directory not authenticated")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/users/alice", nil)
+	r.Header.Set("Authorization", "Bearer "+session)
+	if !rest.authenticated(r) {
+		t.Errorf("bearer session not authenticated")
+	}
+	for _, header := range []string{session, "Basic " + session, "bearer " + session} {
+		r.Header.Set("Authorization", header)
+		if rest.authenticated(r) {
+			t.Errorf("authorization %q authenticated", header)
+		}
+	}
+}
+
+func TestPendingHidesHandles(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	testUser(t, s, "alice")
+	testUser(t, s, "bob")
+	if err := s.RevokeAll("bob"); err != nil {
+		t.Fatalf("RevokeAll: %v", err)
+	}
+	app, key := crypto.RandomAsymetricKey()
+	request := PendingRequest{AttorneyToken: app.Hex(), App: "App", Scopes: []string{ScopeEmail}}
+	answers := make(map[string]string)
+	for _, handle := range []string{"alice", "bob", "nobody"} {
+		w := appCall(s, key, http.MethodPost, "/v1/users/"+handle+"/pending", request)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("pending for %v = %v: %v", handle, w.Code, w.Body)
+		}
+		var response PendingResponse
+		json.NewDecoder(w.Body).Decode(&response)
+		id := response.ID
+		response.ID, response.Verify = "", ""
+		shape, _ := json.Marshal(response)
+		answers[handle] = string(bytes.Replace(shape, []byte(handle), nil, -1))
+		if w := appCall(s, key, http.MethodGet, "/v1/pending/"+id, nil); w.Code != http.StatusOK {
+			t.Errorf("GET pending of %v = %v", handle, w.Code)
+		}
+	}
+	if answers["alice"] != answers["bob"] || answers["alice"] != answers["nobody"] {
+		t.Errorf("answers differ: %v", answers)
+	}
+
+	if _, err := s.directory.Set(AppInfo{Token: app.Hex(), Name: "App"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if w := appCall(s, key, http.MethodPost, "/v1/users/nobody/pending", request); w.Code != http.StatusNotFound {
+		t.Errorf("known app asking an unknown user = %v", w.Code)
+	}
+	if w := appCall(s, key, http.MethodPost, "/v1/users/bob/pending", request); w.Code != http.StatusForbidden {
+		t.Errorf("known app asking a frozen user = %v", w.Code)
+	}
+}
+
+func TestPendingBounds(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	expired := time.Now().Add(-time.Second)
+	s.pending["expired"] = &PendingGrant{Handle: "alice", expires: expired}
+	if _, ok := s.Pending("expired"); ok {
+		t.Errorf("expired request returned")
+	}
+	for n := len(s.pending); n < maxPending; n++ {
+		s.pending[strconv.Itoa(n)] = &PendingGrant{Handle: "alice", expires: expired}
+	}
+	if err := s.NewPending("new", PendingGrant{Handle: "alice"}); err != nil {
+		t.Fatalf("NewPending with expired requests only: %v", err)
+	}
+	if len(s.pending) != 1 {
+		t.Errorf("%v requests kept, want the expired ones swept", len(s.pending))
+	}
+	if _, ok := s.Pending("new"); !ok {
+		t.Errorf("new request not kept")
+	}
+	live := time.Now().Add(time.Hour)
+	for n := len(s.pending); n < maxPending; n++ {
+		s.pending[strconv.Itoa(n)] = &PendingGrant{Handle: "alice", expires: live}
+	}
+	if err := s.NewPending("full", PendingGrant{Handle: "alice"}); err != ErrTooManyPending {
+		t.Errorf("NewPending on a full store = %v, want ErrTooManyPending", err)
+	}
+}
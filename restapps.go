@@ -0,0 +1,256 @@
+package safe
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+type ChallengeRequest struct {
+	Handle string `json:"handle"`
+	Nonce  string `json:"nonce"`
+}
+
+type ChallengeResponse struct {
+	ID        string                  `json:"id"`
+	Handle    string                  `json:"handle"`
+	Nonce     string                  `json:"nonce"`
+	Status    string                  `json:"status"`
+	Approve   string                  `json:"approve,omitempty"`
+	Statement *OwnershipStatementJSON `json:"statement,omitempty"`
+}
+
+type GrantorListResponse struct {
+	Attorney string    `json:"attorney"`
+	Total    int       `json:"total"`
+	Offset   int       `json:"offset"`
+	Limit    int       `json:"limit"`
+	Users    []Grantor `json:"users"`
+}
+
+type WebhookRequest struct {
+	URL string `json:"url"`
+}
+
+type WebhookResponse struct {
+	ID       string `json:"id"`
+	Attorney string `json:"attorney"`
+	URL      string `json:"url"`
+}
+
+type WebhookListResponse struct {
+	Webhooks []WebhookResponse `json:"webhooks"`
+}
+
+type DeliveryListResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+func webhookResponse(hook Webhook) WebhookResponse {
+	return WebhookResponse{ID: hook.ID, Attorney: hook.Attorney.Hex(), URL: hook.URL}
+}
+
+func (rest *RestAPI) webhooksV1(w http.ResponseWriter, r *http.Request) {
+	app, ok := rest.authenticateApp(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		hooks := rest.Safe.webhooks.Hooks(app)
+		response := WebhookListResponse{Webhooks: make([]WebhookResponse, len(hooks))}
+		for n, hook := range hooks {
+			response.Webhooks[n] = webhookResponse(hook)
+		}
+		writeJSON(w, http.StatusOK, response)
+	case http.MethodPost:
+		var req WebhookRequest
+		if !rest.Safe.decodeJSON(w, r, &req) {
+			return
+		}
+		hook, err := rest.Safe.webhooks.Register(app, req.URL)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrInvalidWebhook, translateError(rest.Safe.Language(r), err))
+			return
+		}
+		writeJSON(w, http.StatusCreated, webhookResponse(hook))
+	default:
+		rest.Safe.notAllowed(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (rest *RestAPI) webhookV1(w http.ResponseWriter, r *http.Request, id string) {
+	if !rest.Safe.allowMethod(w, r, http.MethodDelete) {
+		return
+	}
+	app, ok := rest.authenticateApp(w, r)
+	if !ok {
+		return
+	}
+	if !rest.Safe.webhooks.Remove(app, id) {
+		writeError(w, http.StatusNotFound, ErrWebhookNotFound, Translate(rest.Safe.Language(r), "api.webhook_not_found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rest *RestAPI) deliveriesV1(w http.ResponseWriter, r *http.Request, id string) {
+	if !rest.Safe.allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	app, ok := rest.authenticateApp(w, r)
+	if !ok {
+		return
+	}
+	deliveries, ok := rest.Safe.webhooks.Deliveries(app, id)
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrWebhookNotFound, Translate(rest.Safe.Language(r), "api.webhook_not_found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, DeliveryListResponse{Deliveries: deliveries})
+}
+
+// isAttorney checks that app holds power of attorney from handle.
+func (rest *RestAPI) isAttorney(w http.ResponseWriter, r *http.Request, handle string, app crypto.Token) bool {
+	if rest.Safe.hasAttorney(handle, app) {
+		return true
+	}
+	writeError(w, http.StatusForbidden, ErrForbidden, Translate(rest.Safe.Language(r), "api.not_attorney"))
+	return false
+}
+
+func (rest *RestAPI) eventsV1(w http.ResponseWriter, r *http.Request, handle string) {
+	if !rest.Safe.allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	app, ok := rest.authenticateApp(w, r)
+	if !ok || !rest.isAttorney(w, r, handle, app) {
+		return
+	}
+	if rest.Safe.Frozen(handle) {
+		writeError(w, http.StatusForbidden, ErrFrozen, Translate(rest.Safe.Language(r), "api.frozen"))
+		return
+	}
+	allow, authorized := rest.Safe.appEvents(handle, app)
+	rest.Safe.streamEvents(w, r, handle, allow, authorized)
+}
+
+func (rest *RestAPI) challengeResponse(challenge Challenge) ChallengeResponse {
+	response := ChallengeResponse{
+		ID:     challenge.ID,
+		Handle: challenge.Handle,
+		Nonce:  challenge.Nonce,
+		Status: challenge.Status,
+	}
+	if challenge.Status == ChallengePending {
+		response.Approve = rest.Safe.PublicURL("challenge/" + challenge.ID)
+	}
+	if challenge.Statement != nil {
+		statement := challenge.Statement.JSON()
+		response.Statement = &statement
+	}
+	return response
+}
+
+func (rest *RestAPI) createChallengeV1(w http.ResponseWriter, r *http.Request) {
+	if !rest.Safe.allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	app, ok := rest.authenticateApp(w, r)
+	if !ok {
+		return
+	}
+	var req ChallengeRequest
+	if !rest.Safe.decodeJSON(w, r, &req) {
+		return
+	}
+	language := rest.Safe.Language(r)
+	if req.Nonce == "" || len(req.Nonce) > maxNonceSize || !statementText(req.Nonce) {
+		writeError(w, http.StatusBadRequest, ErrInvalidNonce, Translate(language, "api.invalid_nonce", maxNonceSize))
+		return
+	}
+	if !rest.userExists(req.Handle) {
+		writeError(w, http.StatusNotFound, ErrUserNotFound, Translate(language, "api.user_not_found"))
+		return
+	}
+	challenge := rest.Safe.challenges.New(req.Handle, app, req.Nonce)
+	writeJSON(w, http.StatusCreated, rest.challengeResponse(*challenge))
+}
+
+func (rest *RestAPI) challengeV1(w http.ResponseWriter, r *http.Request, id string) {
+	if !rest.Safe.allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	app, ok := rest.authenticateApp(w, r)
+	if !ok {
+		return
+	}
+	challenge, ok := rest.Safe.challenges.Get(id)
+	if !ok || !challenge.App.Equal(app) {
+		writeError(w, http.StatusNotFound, ErrChallengeNotFound, Translate(rest.Safe.Language(r), "api.challenge_not_found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, rest.challengeResponse(challenge))
+}
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// queryInt reads a non negative int query parameter.
+func queryInt(r *http.Request, name string, standard int) (int, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return standard, true
+	}
+	parsed, err := strconv.Atoi(value)
+	return parsed, err == nil && parsed >= 0
+}
+
+// queryUint reads an epoch query parameter.
+func queryUint(r *http.Request, name string, standard uint64) (uint64, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return standard, true
+	}
+	parsed, err := strconv.ParseUint(value, 10, 64)
+	return parsed, err == nil
+}
+
+func (rest *RestAPI) grantorsV1(w http.ResponseWriter, r *http.Request, attorney string) {
+	if !rest.Safe.allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	app, ok := rest.authenticateApp(w, r)
+	if !ok {
+		return
+	}
+	language := rest.Safe.Language(r)
+	token, ok := attorneyToken(attorney)
+	if !ok {
+		writeError(w, http.StatusBadRequest, ErrInvalidAttorney, Translate(language, "api.invalid_attorney"))
+		return
+	}
+	if !token.Equal(app) {
+		writeError(w, http.StatusForbidden, ErrForbidden, Translate(language, "api.own_users_only"))
+		return
+	}
+	offset, okOffset := queryInt(r, "offset", 0)
+	limit, okLimit := queryInt(r, "limit", defaultPageSize)
+	from, okFrom := queryUint(r, "from_epoch", 0)
+	to, okTo := queryUint(r, "to_epoch", 0)
+	if !okOffset || !okLimit || !okFrom || !okTo || limit == 0 || limit > maxPageSize {
+		writeError(w, http.StatusBadRequest, ErrInvalidQuery, Translate(language, "api.invalid_query", maxPageSize))
+		return
+	}
+	users, total := rest.Safe.grantors.Grantors(token, from, to, offset, limit)
+	writeJSON(w, http.StatusOK, GrantorListResponse{
+		Attorney: attorney,
+		Total:    total,
+		Offset:   offset,
+		Limit:    limit,
+		Users:    users,
+	})
+}
@@ -0,0 +1,483 @@
+package safe
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HeaderTOTP carries the second factor code required, once enabled, to
+// grant, revoke and update the user.
+const HeaderTOTP = "X-Safe-TOTP"
+
+// SessionRequest must carry TOTP if the user enabled two-factor
+// authentication. A recovery code is also accepted.
+type SessionRequest struct {
+	Handle   string `json:"handle"`
+	Password string `json:"password"`
+	TOTP     string `json:"totp,omitempty"`
+}
+
+type SessionResponse struct {
+	Handle  string `json:"handle"`
+	Session string `json:"session"`
+}
+
+// GrantRequest optionally bounds the power of attorney to ExpiresIn epochs
+// from now and/or to the unix time ExpiresAt.
+type GrantRequest struct {
+	AttorneyToken string   `json:"attorney_token"`
+	Fingerprint   string   `json:"fingerprint,omitempty"`
+	Scopes        []string `json:"scopes,omitempty"`
+	App           string   `json:"app,omitempty"`
+	ExpiresIn     uint64   `json:"expires_in,omitempty"`
+	ExpiresAt     int64    `json:"expires_at,omitempty"`
+}
+
+type UpdateUserRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Password        string `json:"password,omitempty"`
+	Email           string `json:"email,omitempty"`
+}
+
+type UnfreezeRequest struct {
+	Password string `json:"password"`
+}
+
+type UserStatusResponse struct {
+	Handle    string   `json:"handle"`
+	Token     string   `json:"token"`
+	Email     string   `json:"email"`
+	Confirmed bool     `json:"confirmed"`
+	Frozen    bool     `json:"frozen"`
+	TwoFactor bool     `json:"two_factor"`
+	Attorneys []string `json:"attorneys"`
+}
+
+type AttorneyExpiryResponse struct {
+	Epoch uint64 `json:"epoch,omitempty"`
+	Time  int64  `json:"time,omitempty"`
+}
+
+func expiryResponse(expiry Expiry) *AttorneyExpiryResponse {
+	if expiry.IsZero() {
+		return nil
+	}
+	response := AttorneyExpiryResponse{Epoch: expiry.Epoch}
+	if !expiry.Time.IsZero() {
+		response.Time = expiry.Time.Unix()
+	}
+	return &response
+}
+
+// AttorneyRecordResponse tells how and when a power of attorney was granted.
+type AttorneyRecordResponse struct {
+	Epoch       uint64 `json:"epoch"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	App         string `json:"app,omitempty"`
+	Method      string `json:"method"`
+	Confirmed   bool   `json:"confirmed"`
+}
+
+func recordResponse(record AttorneyRecord) *AttorneyRecordResponse {
+	return &AttorneyRecordResponse{
+		Epoch:       record.Epoch,
+		Fingerprint: record.Fingerprint,
+		App:         record.App,
+		Method:      record.Method,
+		Confirmed:   record.Confirmed,
+	}
+}
+
+type AttorneyListResponse struct {
+	Handle    string             `json:"handle"`
+	Attorneys []AttorneyResponse `json:"attorneys"`
+}
+
+// authorize checks that the request carries a session of handle. Otherwise
+// it writes the error response and returns false.
+func (rest *RestAPI) authorize(w http.ResponseWriter, r *http.Request, handle string) bool {
+	authenticated := rest.Safe.BearerHandle(r)
+	if authenticated == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="safe"`)
+		writeError(w, http.StatusUnauthorized, ErrUnauthorized, Translate(rest.Safe.Language(r), "api.session_invalid"))
+		return false
+	}
+	if authenticated != handle {
+		writeError(w, http.StatusForbidden, ErrForbidden, Translate(rest.Safe.Language(r), "api.session_other"))
+		return false
+	}
+	return true
+}
+
+// secondFactor checks the HeaderTOTP code of sensitive requests of handle.
+func (rest *RestAPI) secondFactor(w http.ResponseWriter, r *http.Request, handle string) bool {
+	err := rest.Safe.SecondFactor(handle, r.Header.Get(HeaderTOTP), clientIP(r))
+	if err == nil {
+		return true
+	}
+	language := rest.Safe.Language(r)
+	if !writeThrottled(w, language, err) {
+		writeError(w, http.StatusForbidden, ErrSecondFactorRequired, Translate(language, "api.totp_header", HeaderTOTP))
+	}
+	return false
+}
+
+// writeThrottled answers 429 with Retry-After if err is a *ThrottleError.
+func writeThrottled(w http.ResponseWriter, language string, err error) bool {
+	var throttled *ThrottleError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+	seconds := int64((throttled.RetryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	code := ErrTooManyAttempts
+	if throttled.Locked {
+		code = ErrAccountLocked
+	}
+	writeError(w, http.StatusTooManyRequests, code, translateError(language, throttled))
+	return true
+}
+
+// credentials checks the password of handle with login throttling and
+// answers with status and mismatch if it does not match.
+func (rest *RestAPI) credentials(w http.ResponseWriter, r *http.Request, handle, password string, status int, mismatch error) bool {
+	err := rest.Safe.Authenticate(handle, password, clientIP(r))
+	if err == nil {
+		return true
+	}
+	language := rest.Safe.Language(r)
+	if !writeThrottled(w, language, err) {
+		writeError(w, status, ErrBadCredentials, translateError(language, mismatch))
+	}
+	return false
+}
+
+func (rest *RestAPI) createSessionV1(w http.ResponseWriter, r *http.Request) {
+	var req SessionRequest
+	if !rest.Safe.decodeJSON(w, r, &req) {
+		return
+	}
+	req.Handle = rest.Safe.LoginHandle(req.Handle)
+	if !rest.credentials(w, r, req.Handle, req.Password, http.StatusUnauthorized, ErrInvalidCredentials) {
+		return
+	}
+	if err := rest.Safe.SecondFactor(req.Handle, req.TOTP, clientIP(r)); err != nil {
+		language := rest.Safe.Language(r)
+		if !writeThrottled(w, language, err) {
+			writeError(w, http.StatusUnauthorized, ErrSecondFactorRequired, translateError(language, err))
+		}
+		return
+	}
+	session := rest.Safe.CreateSession(req.Handle)
+	if session == "" {
+		writeError(w, http.StatusInternalServerError, ErrBadCredentials, Translate(rest.Safe.Language(r), "api.session_failed"))
+		return
+	}
+	writeJSON(w, http.StatusCreated, SessionResponse{Handle: req.Handle, Session: session})
+}
+
+func (rest *RestAPI) deleteSessionV1(w http.ResponseWriter, r *http.Request) {
+	handle := rest.Safe.BearerHandle(r)
+	if !rest.authorize(w, r, handle) {
+		return
+	}
+	rest.Safe.EndSession(bearer(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rest *RestAPI) userV1(w http.ResponseWriter, r *http.Request, handle string) {
+	if !rest.authorize(w, r, handle) {
+		return
+	}
+	email, token := rest.userEmailAndToken(handle)
+	response := UserStatusResponse{
+		Handle:    handle,
+		Token:     token.String(),
+		Email:     email,
+		TwoFactor: rest.Safe.TwoFactorEnabled(handle),
+	}
+	rest.Safe.mu.RLock()
+	user := rest.Safe.users[handle]
+	response.Confirmed = user.Confirmed
+	response.Frozen = user.Frozen
+	response.Attorneys = make([]string, len(user.Attorneys))
+	for n, attorney := range user.Attorneys {
+		response.Attorneys[n] = attorney.String()
+	}
+	rest.Safe.mu.RUnlock()
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (rest *RestAPI) updateUserV1(w http.ResponseWriter, r *http.Request, handle string) {
+	if !rest.authorize(w, r, handle) {
+		return
+	}
+	var req UpdateUserRequest
+	if !rest.Safe.decodeJSON(w, r, &req) {
+		return
+	}
+	if !rest.credentials(w, r, handle, req.CurrentPassword, http.StatusForbidden, ErrCurrentPassword) {
+		return
+	}
+	if !rest.secondFactor(w, r, handle) {
+		return
+	}
+	language := rest.Safe.Language(r)
+	if req.Password == "" && req.Email == "" {
+		writeError(w, http.StatusBadRequest, ErrNothingToUpdate, Translate(language, "api.nothing_to_update"))
+		return
+	}
+	// both changes are validated before any is applied
+	if req.Email != "" {
+		if _, err := validEmail(req.Email); err != nil {
+			writeUpdateError(w, language, err)
+			return
+		}
+	}
+	if req.Password != "" {
+		if err := rest.Safe.ChangePassword(handle, req.Password, bearer(r)); err != nil {
+			writeUpdateError(w, language, err)
+			return
+		}
+	}
+	if req.Email != "" {
+		if err := rest.Safe.ChangeEmail(handle, req.Email); err != nil {
+			writeUpdateError(w, language, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeUpdateError(w http.ResponseWriter, language string, err error) {
+	switch err {
+	case ErrPasswordTooShort, ErrPasswordUnchanged:
+		writeError(w, http.StatusBadRequest, ErrInvalidPassword, translateError(language, err))
+	case ErrEmailInvalid:
+		writeError(w, http.StatusBadRequest, ErrInvalidEmail, translateError(language, err))
+	default:
+		writeError(w, http.StatusInternalServerError, ErrUpdateFailed, translateError(language, err))
+	}
+}
+
+func (rest *RestAPI) attorneysV1(w http.ResponseWriter, r *http.Request, handle string) {
+	if !rest.authorize(w, r, handle) {
+		return
+	}
+	attorneys := rest.Safe.UserAttorneys(handle)
+	response := AttorneyListResponse{
+		Handle:    handle,
+		Attorneys: make([]AttorneyResponse, len(attorneys)),
+	}
+	for n, attorney := range attorneys {
+		response.Attorneys[n] = AttorneyResponse{
+			Handle:   handle,
+			Attorney: attorney.String(),
+			Granted:  true,
+			Scopes:   rest.Safe.AttorneyScopes(handle, attorney),
+			Expires:  expiryResponse(rest.Safe.users[handle].Expiry[attorney]),
+		}
+		if record, ok := rest.Safe.users[handle].Records[attorney]; ok {
+			response.Attorneys[n].Record = recordResponse(record)
+		}
+		info := rest.Safe.directory.Lookup(attorney)
+		response.Attorneys[n].Directory = &info
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// grantV1 signs and sends the grant. The response is accepted since the
+// grant is only effective once incorporated from the chain.
+func (rest *RestAPI) grantV1(w http.ResponseWriter, r *http.Request, handle string) {
+	if !rest.authorize(w, r, handle) {
+		return
+	}
+	if !rest.secondFactor(w, r, handle) {
+		return
+	}
+	var req GrantRequest
+	if !rest.Safe.decodeJSON(w, r, &req) {
+		return
+	}
+	language := rest.Safe.Language(r)
+	if _, ok := attorneyToken(req.AttorneyToken); !ok {
+		writeError(w, http.StatusBadRequest, ErrInvalidAttorney, Translate(language, "api.invalid_attorney"))
+		return
+	}
+	var at time.Time
+	if req.ExpiresAt != 0 {
+		at = time.Unix(req.ExpiresAt, 0)
+	}
+	expiry, err := rest.Safe.NewExpiry(req.ExpiresIn, at)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidExpiry, translateError(language, err))
+		return
+	}
+	origin := GrantOrigin{Method: GrantREST, App: req.App}
+	if err := rest.Safe.GrantPowerUntil(handle, req.AttorneyToken, req.Fingerprint, req.Scopes, expiry, origin); err != nil {
+		if err == ErrAccountFrozen {
+			writeError(w, http.StatusForbidden, ErrFrozen, Translate(language, "api.frozen_grant"))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, ErrGrantFailed, translateError(language, err))
+		return
+	}
+	writeJSON(w, http.StatusAccepted, AttorneyResponse{
+		Handle:   handle,
+		Attorney: req.AttorneyToken,
+		Scopes:   ParseScopes(req.Scopes),
+		Expires:  expiryResponse(expiry),
+	})
+}
+
+func (rest *RestAPI) revokeV1(w http.ResponseWriter, r *http.Request, handle, attorney string) {
+	if !rest.authorize(w, r, handle) {
+		return
+	}
+	if !rest.secondFactor(w, r, handle) {
+		return
+	}
+	language := rest.Safe.Language(r)
+	if _, ok := attorneyToken(attorney); !ok {
+		writeError(w, http.StatusBadRequest, ErrInvalidAttorney, Translate(language, "api.invalid_attorney"))
+		return
+	}
+	if err := rest.Safe.RevokePower(handle, attorney); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrRevokeFailed, translateError(language, err))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// authenticateAdmin is like authenticateApp but the request must be signed
+// by one of the admin tokens of the safe.
+func (rest *RestAPI) authenticateAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token, ok := rest.authenticateApp(w, r)
+	if !ok {
+		return false
+	}
+	for _, admin := range rest.Safe.admins {
+		if admin.Equal(token) {
+			return true
+		}
+	}
+	writeError(w, http.StatusForbidden, ErrForbidden, Translate(rest.Safe.Language(r), "api.admin_required"))
+	return false
+}
+
+func (rest *RestAPI) historyV1(w http.ResponseWriter, r *http.Request, handle string) {
+	if !rest.authorize(w, r, handle) {
+		return
+	}
+	entries, err := rest.Safe.History(handle)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrHistoryFailed, translateError(rest.Safe.Language(r), err))
+		return
+	}
+	writeJSON(w, http.StatusOK, HistoryResponse{Handle: handle, Entries: entries})
+}
+
+func (rest *RestAPI) freezeV1(w http.ResponseWriter, r *http.Request, handle string) {
+	if !rest.authorize(w, r, handle) {
+		return
+	}
+	if err := rest.Safe.RevokeAll(handle); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrRevokeFailed, translateError(rest.Safe.Language(r), err))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (rest *RestAPI) unfreezeV1(w http.ResponseWriter, r *http.Request, handle string) {
+	if !rest.authorize(w, r, handle) {
+		return
+	}
+	if !rest.secondFactor(w, r, handle) {
+		return
+	}
+	var req UnfreezeRequest
+	if !rest.Safe.decodeJSON(w, r, &req) {
+		return
+	}
+	if err := rest.Safe.Unfreeze(handle, req.Password, clientIP(r)); err != nil {
+		language := rest.Safe.Language(r)
+		if !writeThrottled(w, language, err) {
+			writeError(w, http.StatusForbidden, ErrBadCredentials, translateError(language, err))
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rest *RestAPI) adminFreezeV1(w http.ResponseWriter, r *http.Request, handle string) {
+	if !rest.authenticateAdmin(w, r) {
+		return
+	}
+	if !rest.userExists(handle) {
+		writeError(w, http.StatusNotFound, ErrUserNotFound, Translate(rest.Safe.Language(r), "api.user_not_found"))
+		return
+	}
+	if err := rest.Safe.RevokeAll(handle); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrRevokeFailed, translateError(rest.Safe.Language(r), err))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// adminUnlockV1 lifts the login lockout of handle before it expires.
+func (rest *RestAPI) adminUnlockV1(w http.ResponseWriter, r *http.Request, handle string) {
+	if !rest.authenticateAdmin(w, r) {
+		return
+	}
+	if !rest.userExists(handle) {
+		writeError(w, http.StatusNotFound, ErrUserNotFound, Translate(rest.Safe.Language(r), "api.user_not_found"))
+		return
+	}
+	rest.Safe.throttle.Unlock(handle)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type DirectoryResponse struct {
+	Entries []AppInfo `json:"entries"`
+}
+
+func (rest *RestAPI) setDirectoryV1(w http.ResponseWriter, r *http.Request, token string) {
+	if !rest.authenticateAdmin(w, r) {
+		return
+	}
+	var info AppInfo
+	if !rest.Safe.decodeJSON(w, r, &info) {
+		return
+	}
+	info.Token = token
+	info, err := rest.Safe.directory.Set(info)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidDirectoryEntry, translateError(rest.Safe.Language(r), err))
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (rest *RestAPI) removeDirectoryV1(w http.ResponseWriter, r *http.Request, token string) {
+	if !rest.authenticateAdmin(w, r) {
+		return
+	}
+	language := rest.Safe.Language(r)
+	attorney, ok := attorneyToken(token)
+	if !ok {
+		writeError(w, http.StatusBadRequest, ErrInvalidDirectoryEntry, Translate(language, "api.invalid_token"))
+		return
+	}
+	found, err := rest.Safe.directory.Remove(attorney)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrUpdateFailed, translateError(language, err))
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, ErrDirectoryNotFound, Translate(language, "api.directory_not_found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
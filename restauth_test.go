@@ -0,0 +1,137 @@
+package safe
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// restCall sends a request with an optional bearer session and JSON body
+// to the REST API of s.
+func restCall(t *testing.T, s *Safe, method, path, session string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	r := httptest.NewRequest(method, path, bytes.NewReader(data))
+	if session != "" {
+		r.Header.Set("Authorization", "Bearer "+session)
+	}
+	w := httptest.NewRecorder()
+	rest := RestAPI{Safe: s}
+	rest.Handler().ServeHTTP(w, r)
+	return w
+}
+
+// restSession logs handle in over REST.
+func restSession(t *testing.T, s *Safe, handle string) string {
+	t.Helper()
+	w := restCall(t, s, http.MethodPost, "/v1/sessions", "", SessionRequest{Handle: handle, Password: "correct horse battery"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /v1/sessions = %v: %v", w.Code, w.Body)
+	}
+	var response SessionResponse
+	json.NewDecoder(w.Body).Decode(&response)
+	return response.Session
+}
+
+func TestSessionsV1(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	testUser(t, s, "alice")
+	w := restCall(t, s, http.MethodPost, "/v1/sessions", "", SessionRequest{Handle: "alice", Password: "wrong password"})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("login with a wrong password = %v", w.Code)
+	}
+	session := restSession(t, s, "alice")
+	if w := restCall(t, s, http.MethodGet, "/v1/users/alice", session, nil); w.Code != http.StatusOK {
+		t.Fatalf("GET /v1/users/alice = %v", w.Code)
+	}
+	if w := restCall(t, s, http.MethodDelete, "/v1/sessions", session, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /v1/sessions = %v", w.Code)
+	}
+	if w := restCall(t, s, http.MethodGet, "/v1/users/alice", session, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /v1/users/alice after logout = %v", w.Code)
+	}
+}
+
+func TestAttorneysV1(t *testing.T) {
+	gateway := &testGateway{}
+	s := testSafe(t, gateway)
+	testUser(t, s, "alice")
+	testUser(t, s, "bob")
+	session := restSession(t, s, "alice")
+	attorney, _ := crypto.RandomAsymetricKey()
+
+	grant := GrantRequest{AttorneyToken: attorney.Hex(), Scopes: []string{ScopeEmail}}
+	if w := restCall(t, s, http.MethodPost, "/v1/users/bob/attorneys", session, grant); w.Code != http.StatusForbidden {
+		t.Errorf("grant with the session of another user = %v", w.Code)
+	}
+	if w := restCall(t, s, http.MethodPost, "/v1/users/alice/attorneys", session, GrantRequest{AttorneyToken: "zz"}); w.Code != http.StatusBadRequest {
+		t.Errorf("grant to an invalid attorney = %v", w.Code)
+	}
+	if w := restCall(t, s, http.MethodPost, "/v1/users/alice/attorneys", session, grant); w.Code != http.StatusAccepted {
+		t.Fatalf("grant = %v: %v", w.Code, w.Body)
+	}
+	action, err := s.GrantAction("alice", attorney.Hex())
+	if err != nil {
+		t.Fatalf("GrantAction: %v", err)
+	}
+	s.IncorporateGrant(action)
+
+	w := restCall(t, s, http.MethodGet, "/v1/users/alice/attorneys", session, nil)
+	var list AttorneyListResponse
+	json.NewDecoder(w.Body).Decode(&list)
+	if w.Code != http.StatusOK || len(list.Attorneys) != 1 || list.Attorneys[0].Attorney != attorney.Hex() {
+		t.Fatalf("GET attorneys = %v %+v", w.Code, list)
+	}
+	if record := list.Attorneys[0].Record; record == nil || record.Method != GrantREST || !record.Confirmed {
+		t.Errorf("record = %+v, want a confirmed REST grant", record)
+	}
+	if scopes := list.Attorneys[0].Scopes; len(scopes) != 1 || scopes[0] != ScopeEmail {
+		t.Errorf("scopes = %v", scopes)
+	}
+
+	if w := restCall(t, s, http.MethodDelete, "/v1/users/alice/attorneys/"+attorney.Hex(), session, nil); w.Code != http.StatusAccepted {
+		t.Errorf("revoke = %v: %v", w.Code, w.Body)
+	}
+	gateway.mu.Lock()
+	sent := len(gateway.actions)
+	gateway.mu.Unlock()
+	// the joins of alice and bob, the grant and the revoke
+	if sent != 4 {
+		t.Errorf("%v actions sent, want 4", sent)
+	}
+}
+
+func TestUpdateUserV1(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	testUser(t, s, "alice")
+	session := restSession(t, s, "alice")
+	update := UpdateUserRequest{CurrentPassword: "wrong password", Email: "new@example.com"}
+	if w := restCall(t, s, http.MethodPatch, "/v1/users/alice", session, update); w.Code != http.StatusForbidden {
+		t.Errorf("update with a wrong password = %v", w.Code)
+	}
+	update.CurrentPassword = "correct horse battery"
+	if w := restCall(t, s, http.MethodPatch, "/v1/users/alice", session, UpdateUserRequest{CurrentPassword: update.CurrentPassword}); w.Code != http.StatusBadRequest {
+		t.Errorf("update without changes = %v", w.Code)
+	}
+	update.Email = "not an email"
+	if w := restCall(t, s, http.MethodPatch, "/v1/users/alice", session, update); w.Code != http.StatusBadRequest {
+		t.Errorf("update to an invalid email = %v", w.Code)
+	}
+	update.Email = "new@example.com"
+	if w := restCall(t, s, http.MethodPatch, "/v1/users/alice", session, update); w.Code != http.StatusNoContent {
+		t.Fatalf("update = %v: %v", w.Code, w.Body)
+	}
+	w := restCall(t, s, http.MethodGet, "/v1/users/alice", session, nil)
+	var status UserStatusResponse
+	json.NewDecoder(w.Body).Decode(&status)
+	if status.Email != "new@example.com" || status.Handle != "alice" {
+		t.Errorf("user status = %+v", status)
+	}
+}
@@ -0,0 +1,135 @@
+package safe
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// Every REST response carries a detached signature by the safe credentials
+// in HeaderSafeSignature over the message built by ResponseMessage.
+const HeaderSafeTimestamp = "X-Safe-Timestamp"
+
+const WellKnownPath = "/.well-known/safe.json"
+
+type WellKnownResponse struct {
+	Token   string `json:"token"`
+	Address string `json:"address,omitempty"`
+}
+
+// ResponseMessage returns the message signed by the safe for a response:
+// method, path and raw query (empty if none) of the request, the app
+// signature of the request (empty if not signed), status, timestamp and the
+// hex sha256 of the body, one per line. Including the request signature ties
+// the response to the request.
+func ResponseMessage(method, path, query, requestSignature string, status int, timestamp string, body []byte) []byte {
+	hash := sha256.Sum256(body)
+	return []byte(fmt.Sprintf("%v\n%v\n%v\n%v\n%v\n%v\n%v", method, path, query, requestSignature, status, timestamp, hex.EncodeToString(hash[:])))
+}
+
+// VerifyResponse checks the signature of a response to req by the safe
+// token. body must be the full response body. Responses signed more than
+// appRequestWindow away from the local clock are refused so that old
+// responses cannot be replayed.
+func VerifyResponse(req *http.Request, resp *http.Response, body []byte, safe crypto.Token) error {
+	if resp.Header.Get(HeaderSafeToken) != safe.Hex() {
+		return errors.New("response signed by unexpected token")
+	}
+	timestamp := resp.Header.Get(HeaderSafeTimestamp)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid response timestamp")
+	}
+	if delta := time.Since(time.Unix(seconds, 0)); delta > appRequestWindow || delta < -appRequestWindow {
+		return errors.New("response timestamp outside the accepted window")
+	}
+	signatureBytes, _ := hex.DecodeString(resp.Header.Get(HeaderSafeSignature))
+	var signature crypto.Signature
+	if len(signatureBytes) != len(signature) {
+		return errors.New("missing or invalid response signature")
+	}
+	copy(signature[:], signatureBytes)
+	msg := ResponseMessage(req.Method, req.URL.Path, req.URL.RawQuery, req.Header.Get(HeaderAppSignature), resp.StatusCode, timestamp, body)
+	if !safe.Verify(msg, signature) {
+		return errors.New("invalid response signature")
+	}
+	return nil
+}
+
+// bufferedResponse holds a response until it is complete so that it can be
+// signed or stored.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header)}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(data)
+}
+
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for key, values := range b.header {
+		w.Header()[key] = values
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
+
+// signResponses signs every response of next with the safe credentials.
+// Event streams cannot be buffered and are left unsigned.
+func (s *Safe) signResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/events") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		buffered := newBufferedResponse()
+		next.ServeHTTP(buffered, r)
+		if buffered.status == 0 {
+			buffered.status = http.StatusOK
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		msg := ResponseMessage(r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get(HeaderAppSignature), buffered.status, timestamp, buffered.body.Bytes())
+		signature := s.credentials.Sign(msg)
+		buffered.header.Set(HeaderSafeToken, s.credentials.PublicKey().Hex())
+		buffered.header.Set(HeaderSafeTimestamp, timestamp)
+		buffered.header.Set(HeaderSafeSignature, hex.EncodeToString(signature[:]))
+		buffered.writeTo(w)
+	})
+}
+
+func (s *Safe) WellKnownHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, WellKnownResponse{
+		Token:   s.credentials.PublicKey().Hex(),
+		Address: s.address,
+	})
+}
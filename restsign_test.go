@@ -0,0 +1,61 @@
+package safe
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// signedResponse returns a response to req signed by credentials at when.
+func signedResponse(req *http.Request, credentials crypto.PrivateKey, when time.Time, body []byte) *http.Response {
+	timestamp := strconv.FormatInt(when.Unix(), 10)
+	msg := ResponseMessage(req.Method, req.URL.Path, req.URL.RawQuery, "", http.StatusOK, timestamp, body)
+	signature := credentials.Sign(msg)
+	resp := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header)}
+	resp.Header.Set(HeaderSafeToken, credentials.PublicKey().Hex())
+	resp.Header.Set(HeaderSafeTimestamp, timestamp)
+	resp.Header.Set(HeaderSafeSignature, hex.EncodeToString(signature[:]))
+	return resp
+}
+
+func TestVerifyResponseTimestamp(t *testing.T) {
+	token, credentials := crypto.RandomAsymetricKey()
+	req := httptest.NewRequest(http.MethodGet, "/v1/webhooks", nil)
+	body := []byte(`{"webhooks":[]}`)
+
+	if err := VerifyResponse(req, signedResponse(req, credentials, time.Now(), body), body, token); err != nil {
+		t.Errorf("fresh response refused: %v", err)
+	}
+	for _, when := range []time.Time{
+		time.Now().Add(-appRequestWindow - time.Minute),
+		time.Now().Add(appRequestWindow + time.Minute),
+	} {
+		if err := VerifyResponse(req, signedResponse(req, credentials, when, body), body, token); err == nil {
+			t.Errorf("response signed at %v accepted", when)
+		}
+	}
+	resp := signedResponse(req, credentials, time.Now(), body)
+	resp.Header.Del(HeaderSafeTimestamp)
+	if err := VerifyResponse(req, resp, body, token); err == nil {
+		t.Error("response without timestamp accepted")
+	}
+}
+
+func TestVerifyResponseQuery(t *testing.T) {
+	token, credentials := crypto.RandomAsymetricKey()
+	req := httptest.NewRequest(http.MethodGet, "/v1/users/alice/history?limit=10", nil)
+	body := []byte(`{"entries":[]}`)
+	resp := signedResponse(req, credentials, time.Now(), body)
+	if err := VerifyResponse(req, resp, body, token); err != nil {
+		t.Fatalf("VerifyResponse: %v", err)
+	}
+	other := httptest.NewRequest(http.MethodGet, "/v1/users/alice/history?limit=1000", nil)
+	if err := VerifyResponse(other, resp, body, token); err == nil {
+		t.Errorf("response to another query accepted")
+	}
+}
@@ -0,0 +1,445 @@
+package safe
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Error codes of the v1 API. Clients should rely on the code and not on the
+// message.
+const (
+	ErrMethodNotAllowed      = "method_not_allowed"
+	ErrInvalidJSON           = "invalid_json"
+	ErrNotFound              = "not_found"
+	ErrHandleRequired        = "handle_required"
+	ErrPasswordRequired      = "password_required"
+	ErrUserNotFound          = "user_not_found"
+	ErrUserExists            = "user_exists"
+	ErrInvalidAttorney       = "invalid_attorney"
+	ErrPendingNotFound       = "pending_not_found"
+	ErrCreateFailed          = "create_failed"
+	ErrGrantFailed           = "grant_failed"
+	ErrRevokeFailed          = "revoke_failed"
+	ErrUpdateFailed          = "update_failed"
+	ErrUnauthorized          = "unauthorized"
+	ErrForbidden             = "forbidden"
+	ErrBadCredentials        = "invalid_credentials"
+	ErrNothingToUpdate       = "nothing_to_update"
+	ErrInvalidWebhook        = "invalid_webhook"
+	ErrWebhookNotFound       = "webhook_not_found"
+	ErrInvalidNonce          = "invalid_nonce"
+	ErrChallengeNotFound     = "challenge_not_found"
+	ErrInvalidIdempotency    = "invalid_idempotency_key"
+	ErrIdempotencyConflict   = "idempotency_conflict"
+	ErrIdempotencyInProgress = "idempotency_in_progress"
+	ErrInvalidQuery          = "invalid_query"
+	ErrInvalidExpiry         = "invalid_expiry"
+	ErrFrozen                = "account_frozen"
+	ErrInvalidDirectoryEntry = "invalid_directory_entry"
+	ErrDirectoryNotFound     = "directory_entry_not_found"
+	ErrSecondFactorRequired  = "second_factor_required"
+	ErrTooManyAttempts       = "too_many_attempts"
+	ErrAccountLocked         = "account_locked"
+	ErrHandleUnavailable     = "handle_unavailable"
+	ErrInvalidHandle         = "invalid_handle"
+	ErrInvalidPassword       = "invalid_password"
+	ErrInvalidEmail          = "invalid_email"
+	ErrHistoryFailed         = "history_failed"
+	ErrRequestReplayed       = "request_replayed"
+	ErrBusy                  = "busy"
+	ErrHandleTooShort        = HandleTooShort
+	ErrHandleTooLong         = HandleTooLong
+	ErrHandleCharacters      = HandleCharacters
+	ErrHandleReserved        = HandleReserved
+	ErrHandleBlocked         = HandleBlocked
+)
+
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+type PendingRequest struct {
+	AttorneyToken string   `json:"attorney_token"`
+	App           string   `json:"app,omitempty"`
+	Scopes        []string `json:"scopes,omitempty"`
+}
+
+// UserResponse answers the creation of a user. The grant to the app waits
+// for the user consent at Verify, Pending is the id of the pending request.
+type UserResponse struct {
+	Handle  string   `json:"handle"`
+	Pending string   `json:"pending"`
+	Verify  string   `json:"verify"`
+	Scopes  []string `json:"scopes,omitempty"`
+}
+
+type AttorneyResponse struct {
+	Handle   string                  `json:"handle"`
+	Attorney string                  `json:"attorney"`
+	Granted  bool                    `json:"granted"`
+	Scopes   []string                `json:"scopes,omitempty"`
+	Email    string                  `json:"email,omitempty"`
+	Token    string                  `json:"token,omitempty"`
+	Expires  *AttorneyExpiryResponse `json:"expires,omitempty"`
+	Record   *AttorneyRecordResponse `json:"record,omitempty"`
+	// Directory is what the safe directory knows about the attorney
+	Directory *AppInfo `json:"directory,omitempty"`
+}
+
+type PendingResponse struct {
+	ID       string   `json:"id"`
+	Handle   string   `json:"handle"`
+	Attorney string   `json:"attorney"`
+	App      string   `json:"app,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+	Status   string   `json:"status"`
+	Verify   string   `json:"verify,omitempty"`
+	// Directory is what the safe directory knows about the attorney
+	Directory *AppInfo `json:"directory,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, ErrorResponse{Error: APIError{Code: code, Message: message}})
+}
+
+func (s *Safe) notAllowed(w http.ResponseWriter, r *http.Request, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, Translate(s.Language(r), "api.methods_only", strings.Join(methods, ", ")))
+}
+
+func (s *Safe) allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, Translate(s.Language(r), "api.method_only", method))
+		return false
+	}
+	return true
+}
+
+func (s *Safe) decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidJSON, Translate(s.Language(r), "api.invalid_json"))
+		return false
+	}
+	return true
+}
+
+// handleV1 routes the v1 API. Routes marked with * require a session
+// obtained from POST /v1/sessions for the same handle. Routes marked with +
+// require a request signed by the app (see AppRequestMessage) and routes
+// marked admin a request signed by one of SafeConfig.Admins. Routes marked
+// with ! accept an Idempotency-Key header.
+//
+// Unauthenticated callers are not told whether a handle exists and their
+// questions about handles are throttled by client ip.
+//
+//	GET    /v1/openapi.json
+//	POST   /v1/sessions
+//	DELETE /v1/sessions                            *
+//	POST   /v1/users                               !
+//	GET    /v1/users/{handle}                      *
+//	PATCH  /v1/users/{handle}                      *
+//	POST   /v1/users/{handle}/pending              !
+//	GET    /v1/users/{handle}/attorneys            *
+//	POST   /v1/users/{handle}/attorneys            *!
+//	GET    /v1/users/{handle}/attorneys/{token}    +
+//	DELETE /v1/users/{handle}/attorneys/{token}    *
+//	GET    /v1/users/{handle}/events               +
+//	GET    /v1/users/{handle}/history              *
+//	POST   /v1/users/{handle}/freeze               *
+//	POST   /v1/users/{handle}/unfreeze             *
+//	POST   /v1/admin/users/{handle}/freeze         admin
+//	POST   /v1/admin/users/{handle}/unlock         admin
+//	GET    /v1/directory
+//	PUT    /v1/admin/directory/{token}             admin
+//	DELETE /v1/admin/directory/{token}             admin
+//	GET    /v1/pending/{id}
+//	GET    /v1/attorneys/{token}/users             +
+//	POST   /v1/challenges                          +
+//	GET    /v1/challenges/{id}                     +
+//	GET    /v1/webhooks                            +
+//	POST   /v1/webhooks                            +
+//	DELETE /v1/webhooks/{id}                       +
+//	GET    /v1/webhooks/{id}/deliveries            +
+func (rest *RestAPI) handleV1(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/"), "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "openapi.json":
+		if rest.Safe.allowMethod(w, r, http.MethodGet) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(openAPISpec)
+		}
+	case path == "sessions":
+		switch r.Method {
+		case http.MethodPost:
+			rest.createSessionV1(w, r)
+		case http.MethodDelete:
+			rest.deleteSessionV1(w, r)
+		default:
+			rest.Safe.notAllowed(w, r, http.MethodPost, http.MethodDelete)
+		}
+	case path == "users":
+		if rest.Safe.allowMethod(w, r, http.MethodPost) {
+			rest.idempotent(rest.createUserV1)(w, r)
+		}
+	case len(parts) == 2 && parts[0] == "users":
+		switch r.Method {
+		case http.MethodGet:
+			rest.userV1(w, r, parts[1])
+		case http.MethodPatch:
+			rest.updateUserV1(w, r, parts[1])
+		default:
+			rest.Safe.notAllowed(w, r, http.MethodGet, http.MethodPatch)
+		}
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "pending":
+		if rest.Safe.allowMethod(w, r, http.MethodPost) {
+			rest.idempotent(func(w http.ResponseWriter, r *http.Request) {
+				rest.createPendingV1(w, r, parts[1])
+			})(w, r)
+		}
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "freeze":
+		if rest.Safe.allowMethod(w, r, http.MethodPost) {
+			rest.freezeV1(w, r, parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "unfreeze":
+		if rest.Safe.allowMethod(w, r, http.MethodPost) {
+			rest.unfreezeV1(w, r, parts[1])
+		}
+	case len(parts) == 1 && parts[0] == "directory":
+		if rest.Safe.allowMethod(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, DirectoryResponse{Entries: rest.Safe.directory.Entries()})
+		}
+	case len(parts) == 3 && parts[0] == "admin" && parts[1] == "directory":
+		switch r.Method {
+		case http.MethodPut:
+			rest.setDirectoryV1(w, r, parts[2])
+		case http.MethodDelete:
+			rest.removeDirectoryV1(w, r, parts[2])
+		default:
+			rest.Safe.notAllowed(w, r, http.MethodPut, http.MethodDelete)
+		}
+	case len(parts) == 4 && parts[0] == "admin" && parts[1] == "users" && parts[3] == "freeze":
+		if rest.Safe.allowMethod(w, r, http.MethodPost) {
+			rest.adminFreezeV1(w, r, parts[2])
+		}
+	case len(parts) == 4 && parts[0] == "admin" && parts[1] == "users" && parts[3] == "unlock":
+		if rest.Safe.allowMethod(w, r, http.MethodPost) {
+			rest.adminUnlockV1(w, r, parts[2])
+		}
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "events":
+		rest.eventsV1(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "history":
+		if rest.Safe.allowMethod(w, r, http.MethodGet) {
+			rest.historyV1(w, r, parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "attorneys":
+		switch r.Method {
+		case http.MethodGet:
+			rest.attorneysV1(w, r, parts[1])
+		case http.MethodPost:
+			rest.idempotent(func(w http.ResponseWriter, r *http.Request) {
+				rest.grantV1(w, r, parts[1])
+			})(w, r)
+		default:
+			rest.Safe.notAllowed(w, r, http.MethodGet, http.MethodPost)
+		}
+	case len(parts) == 4 && parts[0] == "users" && parts[2] == "attorneys":
+		switch r.Method {
+		case http.MethodGet:
+			rest.attorneyV1(w, r, parts[1], parts[3])
+		case http.MethodDelete:
+			rest.revokeV1(w, r, parts[1], parts[3])
+		default:
+			rest.Safe.notAllowed(w, r, http.MethodGet, http.MethodDelete)
+		}
+	case len(parts) == 3 && parts[0] == "attorneys" && parts[2] == "users":
+		rest.grantorsV1(w, r, parts[1])
+	case path == "challenges":
+		rest.createChallengeV1(w, r)
+	case len(parts) == 2 && parts[0] == "challenges":
+		rest.challengeV1(w, r, parts[1])
+	case path == "webhooks":
+		rest.webhooksV1(w, r)
+	case len(parts) == 2 && parts[0] == "webhooks":
+		rest.webhookV1(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "webhooks" && parts[2] == "deliveries":
+		rest.deliveriesV1(w, r, parts[1])
+	case len(parts) == 2 && parts[0] == "pending":
+		if rest.Safe.allowMethod(w, r, http.MethodGet) {
+			rest.pendingV1(w, r, parts[1])
+		}
+	default:
+		writeError(w, http.StatusNotFound, ErrNotFound, Translate(rest.Safe.Language(r), "api.not_found"))
+	}
+}
+
+func (rest *RestAPI) createUserV1(w http.ResponseWriter, r *http.Request) {
+	authenticated := rest.authenticated(r)
+	language := rest.Safe.Language(r)
+	var req UserRequest
+	if !rest.Safe.decodeJSON(w, r, &req) {
+		return
+	}
+	handle, err := rest.Safe.CheckHandle(req.Handle)
+	if err != nil {
+		apiErr := handleError(err, language)
+		writeError(w, http.StatusBadRequest, apiErr.Code, apiErr.Message)
+		return
+	}
+	req.Handle = handle
+	if !rest.allowProbe(w, r, authenticated) {
+		return
+	}
+	if rest.Safe.HandleTaken(req.Handle) {
+		if rest.hidesHandles(r, authenticated) {
+			writeError(w, http.StatusConflict, ErrHandleUnavailable, Translate(language, "api.handle_unavailable"))
+			return
+		}
+		if !rest.userExists(req.Handle) {
+			writeError(w, http.StatusConflict, ErrHandleUnavailable, Translate(language, "api.handle_joined"))
+			return
+		}
+		writeError(w, http.StatusConflict, ErrUserExists, Translate(language, "api.user_exists"))
+		return
+	}
+	if _, ok := attorneyToken(req.AttorneyToken); !ok {
+		writeError(w, http.StatusBadRequest, ErrInvalidAttorney, Translate(language, "api.invalid_attorney"))
+		return
+	}
+	id, verify, apiErr := rest.createUser(req, language)
+	if apiErr != nil {
+		status := http.StatusInternalServerError
+		switch apiErr.Code {
+		case ErrPasswordRequired, ErrInvalidAttorney:
+			status = http.StatusBadRequest
+		case ErrHandleUnavailable:
+			status = http.StatusConflict
+		}
+		writeError(w, status, apiErr.Code, apiErr.Message)
+		return
+	}
+	writeJSON(w, http.StatusCreated, UserResponse{
+		Handle:  req.Handle,
+		Pending: id,
+		Verify:  verify,
+		Scopes:  ParseScopes(req.Scopes),
+	})
+}
+
+func (rest *RestAPI) createPendingV1(w http.ResponseWriter, r *http.Request, handle string) {
+	authenticated := rest.authenticated(r)
+	language := rest.Safe.Language(r)
+	var req PendingRequest
+	if !rest.Safe.decodeJSON(w, r, &req) {
+		return
+	}
+	if !rest.allowProbe(w, r, authenticated) {
+		return
+	}
+	// unauthenticated callers get the same answer for unknown, frozen and
+	// active handles, and every request of theirs counts as a probe
+	hide := rest.hidesHandles(r, authenticated)
+	if !hide && !rest.userExists(handle) {
+		writeError(w, http.StatusNotFound, ErrUserNotFound, Translate(language, "api.user_not_found"))
+		return
+	}
+	id, verify, apiErr := rest.newPending(handle, req.AttorneyToken, req.App, req.Scopes, hide, language)
+	if apiErr != nil {
+		status := http.StatusBadRequest
+		switch apiErr.Code {
+		case ErrFrozen:
+			status = http.StatusForbidden
+		case ErrUserNotFound:
+			status = http.StatusNotFound
+		case ErrBusy:
+			status = http.StatusServiceUnavailable
+		}
+		writeError(w, status, apiErr.Code, apiErr.Message)
+		return
+	}
+	response := PendingResponse{
+		ID:       id,
+		Handle:   handle,
+		Attorney: req.AttorneyToken,
+		App:      req.App,
+		Scopes:   ParseScopes(req.Scopes),
+		Status:   "pending",
+		Verify:   verify,
+	}
+	if token, ok := attorneyToken(req.AttorneyToken); ok {
+		info := rest.Safe.directory.Lookup(token)
+		response.Directory = &info
+	}
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// attorneyV1 tells the attorney app whether handle granted it power of
+// attorney and what the user shared with it. Attorney tokens are public on
+// chain, so only a request signed by the attorney itself is answered.
+func (rest *RestAPI) attorneyV1(w http.ResponseWriter, r *http.Request, handle, attorney string) {
+	language := rest.Safe.Language(r)
+	token, ok := attorneyToken(attorney)
+	if !ok {
+		writeError(w, http.StatusBadRequest, ErrInvalidAttorney, Translate(language, "api.invalid_attorney"))
+		return
+	}
+	app, ok := rest.authenticateApp(w, r)
+	if !ok {
+		return
+	}
+	if !app.Equal(token) {
+		writeError(w, http.StatusForbidden, ErrForbidden, Translate(language, "api.attorney_only"))
+		return
+	}
+	if !rest.userExists(handle) {
+		writeError(w, http.StatusNotFound, ErrUserNotFound, Translate(language, "api.user_not_found"))
+		return
+	}
+	response := AttorneyResponse{Handle: handle, Attorney: attorney}
+	if granted, scopes := rest.granted(handle, attorney); granted {
+		response.Granted = true
+		response.Scopes = scopes
+		response.Email, response.Token = rest.revealed(handle, scopes)
+		response.Expires = expiryResponse(rest.Safe.users[handle].Expiry[token])
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (rest *RestAPI) pendingV1(w http.ResponseWriter, r *http.Request, id string) {
+	pending, ok := rest.Safe.Pending(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrPendingNotFound, Translate(rest.Safe.Language(r), "api.pending_not_found"))
+		return
+	}
+	info := rest.Safe.directory.Lookup(pending.Attorney)
+	writeJSON(w, http.StatusOK, PendingResponse{
+		ID:        id,
+		Handle:    pending.Handle,
+		Attorney:  pending.Attorney.Hex(),
+		App:       pending.App,
+		Scopes:    pending.Scopes,
+		Status:    "pending",
+		Directory: &info,
+	})
+}
@@ -4,9 +4,12 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
-	"html/template"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/freehandle/breeze/consensus/messages"
@@ -36,27 +39,63 @@ type GatewayConfig struct {
 type SafeConfig struct {
 	Credentials crypto.PrivateKey
 	Path        string
-	HtmlPath    string
+	// HtmlPath optionally overrides the embedded templates and static files
+	HtmlPath string
+	// DevMode parses the templates again on every request
+	DevMode     bool
 	Port        int
 	RestAPIPort int
 	ServerName  string
 	Address     string
+	Issuer      string
+	OIDCClients []OIDCClient
+	Admins      []crypto.Token
+	// DirectoryPath is an optional directory file signed by one of Admins
+	DirectoryPath string
+	// Mailer sends account notifications, they are only logged if nil
+	Mailer Mailer
+	// HandlePolicy restricts new handles, DefaultHandlePolicy if nil
+	HandlePolicy *HandlePolicy
 }
 
 type Safe struct {
-	vault      *Vault
-	actions    *SafeDatabase
-	epoch      uint64
-	gateway    Sender
-	users      map[string]*User
-	Session    *util.CookieStore
-	templates  *template.Template
-	serverName string
-	pending    map[string]*attorney.GrantPowerOfAttorney
-	address    string
+	vault   *Vault
+	actions *SafeDatabase
+	epoch   atomic.Uint64
+	gateway Sender
+	// mu guards users, the fields of each user, Session and pending, which
+	// are used by ingestion, tickers and HTTP handlers alike
+	mu          sync.RWMutex
+	users       map[string]*User
+	Session     *util.CookieStore
+	templates   *Templates
+	serverName  string
+	pending     map[string]*PendingGrant
+	address     string
+	credentials crypto.PrivateKey
+	oidc        *OIDCProvider
+	webhooks    *WebhookDispatcher
+	events      *EventHub
+	challenges  *ChallengeStore
+	idempotency *IdempotencyStore
+	appNonces   *AppNonceStore
+	grantors    *AttorneyIndex
+	admins      []crypto.Token
+	directory   *AttorneyDirectory
+	twoFactor   *TwoFactor
+	webauthn    *WebAuthn
+	throttle    *LoginThrottle
+	mailer      Mailer
+	handles     HandlePolicy
+	network     *NetworkIndex
+	flashes     *FlashStore
+	// expired pending grants are swept at most every pendingSweep
+	pendingSwept time.Time
 }
 
 func (s *Safe) CreateSession(handle string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	user, ok := s.users[handle]
 	if !ok {
 		return ""
@@ -68,10 +107,30 @@ func (s *Safe) CreateSession(handle string) string {
 		return ""
 	}
 	cookie := hex.EncodeToString(seed)
-	s.Session.Set(token, cookie, s.epoch)
+	if err := s.vault.SetSession(handle, cookie, true); err != nil {
+		log.Printf("could not record session: %v", err)
+		return ""
+	}
+	s.Session.Set(token, cookie, s.Epoch())
+	user.Sessions[cookie] = struct{}{}
+	s.events.Publish(AccountEvent{Type: EventNewSession, Handle: handle, Epoch: s.Epoch()})
 	return cookie
 }
 
+// Epoch returns the last epoch seen by the safe.
+func (s *Safe) Epoch() uint64 {
+	return s.epoch.Load()
+}
+
+// PublicURL returns the url of path on the web interface of the safe as
+// reached by users.
+func (s *Safe) PublicURL(path string) string {
+	if s.serverName != "" {
+		return fmt.Sprintf("http://%s/%s%s", s.address, s.serverName, path)
+	}
+	return fmt.Sprintf("http://%s/%s", s.address, path)
+}
+
 func (s *Safe) Email(handle string) string {
 	return s.vault.HandleToEmail(handle)
 }
@@ -84,48 +143,190 @@ func (s *Safe) CheckCredentials(handle, password string) bool {
 	return s.vault.Check(handle, password)
 }
 
+// sessionHandle returns the handle of a session cookie. The cookie must
+// also be an active session of its user, so that sessions ended through the
+// vault stay ended even if the cookie store still knows them.
+func (s *Safe) sessionHandle(session string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	token, ok := s.Session.Get(session)
+	if !ok {
+		return ""
+	}
+	for handle, user := range s.users {
+		if user.Token.Equal(token) {
+			if _, ok := user.Sessions[session]; ok {
+				return handle
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
 func (s *Safe) Handle(r *http.Request) string {
 	cookie, err := r.Cookie(cookieName)
 	if err != nil {
 		return ""
 	}
-	if token, ok := s.Session.Get(cookie.Value); ok {
-		for handle, user := range s.users {
-			if user.Token.Equal(token) {
-				return handle
+	return s.sessionHandle(cookie.Value)
+}
+
+// BearerHandle is like Handle but reads the session from the Authorization
+// header of REST requests.
+func (s *Safe) BearerHandle(r *http.Request) string {
+	session := bearer(r)
+	if session == "" {
+		return ""
+	}
+	return s.sessionHandle(session)
+}
+
+func bearer(r *http.Request) string {
+	session, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return session
+}
+
+func (s *Safe) EndSession(session string) {
+	s.mu.RLock()
+	token, ok := s.Session.Get(session)
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+	handle := s.TokenToHandle(token)
+	if err := s.endSession(handle, token, session); err != nil {
+		log.Printf("could not end session: %v", err)
+	}
+}
+
+// endSession records the end of session in the vault before forgetting it.
+func (s *Safe) endSession(handle string, token crypto.Token, session string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[handle]; ok {
+		if _, ok := user.Sessions[session]; ok {
+			if err := s.vault.SetSession(handle, session, false); err != nil {
+				return err
 			}
+			delete(user.Sessions, session)
+		}
+	}
+	s.Session.Unset(token, session)
+	return nil
+}
+
+// EndSessions ends every session of handle except keep, including the ones
+// opened before the safe restarted.
+func (s *Safe) EndSessions(handle, keep string) error {
+	s.mu.RLock()
+	user, ok := s.users[handle]
+	if !ok {
+		s.mu.RUnlock()
+		return errors.New("invalid user")
+	}
+	token := user.Token
+	sessions := make([]string, 0, len(user.Sessions))
+	for session := range user.Sessions {
+		if session != keep {
+			sessions = append(sessions, session)
+		}
+	}
+	s.mu.RUnlock()
+	var failed error
+	for _, session := range sessions {
+		if err := s.endSession(handle, token, session); err != nil {
+			failed = fmt.Errorf("could not end session: %v", err)
+		}
+	}
+	return failed
+}
+
+func (s *Safe) UpdateUser(handle, password, email string) error {
+	return s.vault.UpdateUser(handle, password, email)
+}
+
+// TokenToHandle returns the handle of the user with the given token or an
+// empty string if there is no such user in the safe.
+func (s *Safe) TokenToHandle(token crypto.Token) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for handle, user := range s.users {
+		if user.Token.Equal(token) {
+			return handle
 		}
 	}
 	return ""
 }
 
 func (s *Safe) IncorporateGrant(grant *attorney.GrantPowerOfAttorney) {
-	for _, user := range s.users {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for handle, user := range s.users {
 		if user.Token.Equal(grant.Author) {
 			user.GrantPower(grant)
+			s.saveAction(grant.Serialize())
+			s.confirmRecord(handle, user, grant)
+			s.grantors.Grant(grant.Attorney, handle, grant.Epoch)
+			s.webhooks.Notify(grant.Attorney, WebhookEvent{Type: EventGrant, Handle: handle, Epoch: grant.Epoch})
+			s.events.Publish(AccountEvent{Type: EventGrantConfirmed, Handle: handle, Attorney: grant.Attorney.Hex(), Epoch: grant.Epoch})
 			return
 		}
 	}
 }
 
 func (s *Safe) IncorporateRevoke(revoke *attorney.RevokePowerOfAttorney) {
-	for _, user := range s.users {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for handle, user := range s.users {
 		if user.Token.Equal(revoke.Author) {
 			user.RevokePower(revoke)
+			s.saveAction(revoke.Serialize())
+			s.grantors.Revoke(revoke.Attorney, handle)
+			s.webhooks.Notify(revoke.Attorney, WebhookEvent{Type: EventRevoke, Handle: handle, Epoch: revoke.Epoch})
+			s.events.Publish(AccountEvent{Type: EventRevokeConfirmed, Handle: handle, Attorney: revoke.Attorney.Hex(), Epoch: revoke.Epoch})
 			return
 		}
 	}
 }
 
 func (s *Safe) IncorporateJoin(join *attorney.JoinNetwork) {
-	for _, user := range s.users {
+	s.network.Join(join.Handle, join.Author, join.Epoch)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[join.Handle]; ok && !user.Confirmed && !user.Token.Equal(join.Author) {
+		log.Printf("handle %v joined the network by another token, the join of the local user will be rejected", join.Handle)
+	}
+	for handle, user := range s.users {
 		if user.Token.Equal(join.Author) {
 			user.Confirmed = true
+			s.saveAction(join.Serialize())
+			s.events.Publish(AccountEvent{Type: EventJoinConfirmed, Handle: handle, Epoch: join.Epoch})
+			// apps involved with the new user are those already granted or
+			// waiting for the user consent
+			notified := make(map[crypto.Token]struct{})
+			for _, attorney := range user.Attorneys {
+				notified[attorney] = struct{}{}
+			}
+			for _, pending := range s.pending {
+				if pending.Grant != nil && pending.Grant.Author.Equal(join.Author) {
+					notified[pending.Grant.Attorney] = struct{}{}
+				}
+			}
+			for attorney := range notified {
+				s.webhooks.Notify(attorney, WebhookEvent{Type: EventJoin, Handle: handle, Epoch: join.Epoch})
+			}
 			return
 		}
 	}
 }
 
+// ErrNotSent is returned when an action could not be sent to the network.
+var ErrNotSent = errors.New("could not send action to the network")
+
 func (s *Safe) Send(data []byte) bool {
 	if s.gateway == nil {
 		log.Print("no connection to send on")
@@ -145,105 +346,152 @@ func (s *Safe) Send(data []byte) bool {
 	return true
 }
 
-func (s *Safe) GrantAction(handle, grantee string) *attorney.GrantPowerOfAttorney {
-	user, ok := s.vault.handle[handle]
-	if !ok {
-		return nil
-	}
+// ErrAttorneyToken is returned for attorneys that are not the hex
+// representation of a token.
+var ErrAttorneyToken = errors.New("invalid attorney token")
+
+// attorneyToken parses the hex representation of an attorney token.
+func attorneyToken(grantee string) (crypto.Token, bool) {
 	var token crypto.Token
-	attorneyBytes, _ := hex.DecodeString(grantee)
-	if len(attorneyBytes) != crypto.TokenSize {
-		return nil
+	attorneyBytes, err := hex.DecodeString(grantee)
+	if err != nil || len(attorneyBytes) != crypto.TokenSize {
+		return token, false
 	}
 	copy(token[:], attorneyBytes)
+	return token, true
+}
+
+// GrantAction signs a grant from handle to grantee without sending it.
+func (s *Safe) GrantAction(handle, grantee string) (*attorney.GrantPowerOfAttorney, error) {
+	user, ok := s.vault.UserSecret(handle)
+	if !ok {
+		return nil, errors.New("invalid user")
+	}
+	if s.Frozen(handle) {
+		return nil, ErrAccountFrozen
+	}
+	token, ok := attorneyToken(grantee)
+	if !ok {
+		return nil, ErrAttorneyToken
+	}
 	fingerprint := crypto.EncodeHash(crypto.HashToken(token))
 	grant := attorney.GrantPowerOfAttorney{
-		Epoch:       s.epoch,
+		Epoch:       s.Epoch(),
 		Author:      user.Secret.PublicKey(),
 		Attorney:    token,
 		Fingerprint: []byte(fingerprint),
 	}
 	grant.Sign(user.Secret)
-	return &grant
+	return &grant, nil
 }
 
-func (s *Safe) GrantPower(handle, grantee, fingerprint string) error {
-	user, ok := s.vault.handle[handle]
+func (s *Safe) GrantPower(handle, grantee, fingerprint string, scopes []string, origin GrantOrigin) error {
+	user, ok := s.vault.UserSecret(handle)
 	if !ok {
 		return errors.New("invalid user")
 	}
-	var token crypto.Token
-	attorneyBytes, _ := hex.DecodeString(grantee)
-	if len(attorneyBytes) != crypto.TokenSize {
-		return errors.New("invalid attorney")
+	if s.Frozen(handle) {
+		return ErrAccountFrozen
+	}
+	token, ok := attorneyToken(grantee)
+	if !ok {
+		return ErrAttorneyToken
 	}
-	copy(token[:], attorneyBytes)
 	grant := attorney.GrantPowerOfAttorney{
-		Epoch:       s.epoch,
+		Epoch:       s.Epoch(),
 		Author:      user.Secret.PublicKey(),
 		Attorney:    token,
 		Fingerprint: []byte(fingerprint),
 	}
 	grant.Sign(user.Secret)
-	data := grant.Serialize()
-	s.Send(data)
+	if err := s.SetScopes(handle, token, scopes); err != nil {
+		return err
+	}
+	return s.SendGrant(handle, &grant, origin)
+}
+
+// SendGrant sends a grant signed by handle to the network and records it.
+// Grants that could not be sent are not recorded, so they never show up as
+// awaiting confirmation.
+func (s *Safe) SendGrant(handle string, grant *attorney.GrantPowerOfAttorney, origin GrantOrigin) error {
+	if s.Frozen(handle) {
+		return ErrAccountFrozen
+	}
+	if !s.Send(grant.Serialize()) {
+		return ErrNotSent
+	}
+	if err := s.recordGrant(handle, grant, origin); err != nil {
+		log.Printf("could not record grant: %v", err)
+	}
+	s.events.Publish(AccountEvent{Type: EventGrantSent, Handle: handle, Attorney: grant.Attorney.Hex(), Epoch: grant.Epoch})
+	return nil
+}
+
+// SetScopes records the scopes granted by handle to attorney. It must be
+// called before the grant is sent so that the scopes are in place once the
+// grant is incorporated.
+func (s *Safe) SetScopes(handle string, attorney crypto.Token, scopes []string) error {
+	scopes = ParseScopes(scopes)
+	if err := s.vault.SetScopes(handle, attorney, scopes); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[handle]; ok {
+		user.Scopes[attorney] = scopes
+	}
 	return nil
 }
 
 func (s *Safe) RevokePower(handle, grantee string) error {
-	user, ok := s.vault.handle[handle]
+	user, ok := s.vault.UserSecret(handle)
 	if !ok {
 		return errors.New("invalid user")
 	}
-	var token crypto.Token
-	attorneyBytes, _ := hex.DecodeString(grantee)
-	if len(attorneyBytes) != crypto.TokenSize {
-		return errors.New("invalid attorney")
+	token, ok := attorneyToken(grantee)
+	if !ok {
+		return ErrAttorneyToken
 	}
-	copy(token[:], attorneyBytes)
 	grant := attorney.RevokePowerOfAttorney{
-		Epoch:    s.epoch,
+		Epoch:    s.Epoch(),
 		Author:   user.Secret.PublicKey(),
 		Attorney: token,
 	}
 	grant.Sign(user.Secret)
 	data := grant.Serialize()
-	s.Send(data)
+	if !s.Send(data) {
+		return ErrNotSent
+	}
+	s.events.Publish(AccountEvent{Type: EventRevokeSent, Handle: handle, Attorney: token.Hex(), Epoch: s.Epoch()})
 	return nil
 }
 
+// SigninWithToken creates a user with a handle already normalized by
+// CheckHandle. Handles that violate the policy or are taken are refused.
 func (s *Safe) SigninWithToken(handle, password, email string) (bool, crypto.Token) {
+	if checked, err := s.CheckHandle(handle); err != nil || checked != handle || s.HandleTaken(handle) {
+		return false, crypto.ZeroToken
+	}
 	token, err := s.vault.NewUser(handle, password, email)
 	if err != nil {
 		return false, crypto.ZeroToken
 	}
-	s.users[handle] = &User{token, make([]crypto.Token, 0), false}
+	s.mu.Lock()
+	s.users[handle] = NewUser(token)
+	s.mu.Unlock()
 	join := attorney.JoinNetwork{
-		Epoch:   s.epoch,
+		Epoch:   s.Epoch(),
 		Author:  token,
 		Handle:  handle,
 		Details: "",
 	}
-	secret := s.vault.handle[handle].Secret
-	join.Sign(secret)
+	secret, _ := s.vault.UserSecret(handle)
+	join.Sign(secret.Secret)
 	data := join.Serialize()
 	return s.Send(data), token
 }
 
 func (s *Safe) Signin(handle, password, email string) bool {
-	token, err := s.vault.NewUser(handle, password, email)
-	if err != nil {
-		return false
-	}
-	s.users[handle] = &User{token, make([]crypto.Token, 0), false}
-	join := attorney.JoinNetwork{
-		Epoch:   s.epoch,
-		Author:  token,
-		Handle:  handle,
-		Details: "",
-	}
-	secret := s.vault.handle[handle].Secret
-	join.Sign(secret)
-	data := join.Serialize()
-	return s.Send(data)
+	ok, _ := s.SigninWithToken(handle, password, email)
+	return ok
 }
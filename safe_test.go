@@ -0,0 +1,193 @@
+package safe
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/handles/attorney"
+)
+
+// testGateway collects the actions sent by the safe and fails while down.
+type testGateway struct {
+	mu      sync.Mutex
+	down    bool
+	actions [][]byte
+}
+
+func (g *testGateway) Send(data []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.down {
+		return errors.New("gateway down")
+	}
+	g.actions = append(g.actions, data)
+	return nil
+}
+
+func (g *testGateway) setDown(down bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.down = down
+}
+
+// testSafe starts a safe on a temporary directory sending through gateway.
+func testSafe(t *testing.T, gateway Sender) *Safe {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	_, credentials := crypto.RandomAsymetricKey()
+	config := SafeConfig{Credentials: credentials, Path: t.TempDir(), Address: "localhost"}
+	s, err := newServerFromSendReceiver(ctx, config, "test password", gateway, make(chan error, 2))
+	if err != nil {
+		t.Fatalf("could not start safe: %v", err)
+	}
+	return s
+}
+
+// testUser signs in handle and returns a session cookie.
+func testUser(t *testing.T, s *Safe, handle string) string {
+	t.Helper()
+	if ok, _ := s.SigninWithToken(handle, "correct horse battery", handle+"@example.com"); !ok {
+		t.Fatalf("could not sign in %v", handle)
+	}
+	session := s.CreateSession(handle)
+	if session == "" {
+		t.Fatalf("could not create session for %v", handle)
+	}
+	return session
+}
+
+// sessionRequest returns a request carrying the session cookie.
+func sessionRequest(session string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: cookieName, Value: session})
+	return r
+}
+
+func TestEndSessions(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	first := testUser(t, s, "alice")
+	second := s.CreateSession("alice")
+	if s.Handle(sessionRequest(first)) != "alice" || s.Handle(sessionRequest(second)) != "alice" {
+		t.Fatal("new sessions are not valid")
+	}
+	if err := s.EndSessions("alice", second); err != nil {
+		t.Fatalf("EndSessions: %v", err)
+	}
+	if s.Handle(sessionRequest(first)) != "" {
+		t.Error("ended session is still valid")
+	}
+	if s.Handle(sessionRequest(second)) != "alice" {
+		t.Error("kept session was ended")
+	}
+	if _, ok := s.vault.HandleSessions("alice")[first]; ok {
+		t.Error("ended session is still active in the vault")
+	}
+	if _, ok := s.vault.HandleSessions("alice")[second]; !ok {
+		t.Error("kept session is not active in the vault")
+	}
+}
+
+func TestUnsentGrantIsNotRecorded(t *testing.T) {
+	gateway := &testGateway{}
+	s := testSafe(t, gateway)
+	testUser(t, s, "alice")
+	attorney, _ := crypto.RandomAsymetricKey()
+	gateway.setDown(true)
+	if err := s.GrantPower("alice", attorney.Hex(), "", nil, GrantOrigin{Method: GrantWeb}); !errors.Is(err, ErrNotSent) {
+		t.Errorf("GrantPower = %v, want ErrNotSent", err)
+	}
+	if _, ok := s.vault.Record("alice", attorney); ok {
+		t.Error("unsent grant was recorded")
+	}
+	if views := s.awaitingViews("alice", s.users["alice"], DefaultLanguage); len(views) != 0 {
+		t.Errorf("unsent grant awaits confirmation: %v", views)
+	}
+	gateway.setDown(false)
+	if err := s.GrantPower("alice", attorney.Hex(), "", nil, GrantOrigin{Method: GrantWeb}); err != nil {
+		t.Fatalf("GrantPower: %v", err)
+	}
+	if views := s.awaitingViews("alice", s.users["alice"], DefaultLanguage); len(views) != 1 {
+		t.Errorf("sent grant does not await confirmation: %v", views)
+	}
+}
+
+func TestInvalidAttorneyIsRefused(t *testing.T) {
+	gateway := &testGateway{}
+	s := testSafe(t, gateway)
+	testUser(t, s, "alice")
+	valid, _ := crypto.RandomAsymetricKey()
+	for _, grantee := range []string{"", "zz", valid.Hex()[2:], valid.Hex() + "0", valid.Hex()[:62] + "zz"} {
+		if _, err := s.GrantAction("alice", grantee); !errors.Is(err, ErrAttorneyToken) {
+			t.Errorf("GrantAction(%q) = %v, want ErrAttorneyToken", grantee, err)
+		}
+		if err := s.GrantPower("alice", grantee, "", nil, GrantOrigin{Method: GrantWeb}); !errors.Is(err, ErrAttorneyToken) {
+			t.Errorf("GrantPower(%q) = %v, want ErrAttorneyToken", grantee, err)
+		}
+		if err := s.RevokePower("alice", grantee); !errors.Is(err, ErrAttorneyToken) {
+			t.Errorf("RevokePower(%q) = %v, want ErrAttorneyToken", grantee, err)
+		}
+	}
+	gateway.mu.Lock()
+	defer gateway.mu.Unlock()
+	// only the join of alice was sent
+	if len(gateway.actions) != 1 {
+		t.Errorf("%v actions sent for invalid attorneys", len(gateway.actions)-1)
+	}
+	if _, err := s.GrantAction("alice", valid.Hex()); err != nil {
+		t.Errorf("GrantAction of a valid token: %v", err)
+	}
+}
+
+// TestIncorporateGrantConcurrently confirms records from ingestion while
+// HTTP handlers write sessions and records to the vault. Run with -race.
+func TestIncorporateGrantConcurrently(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	testUser(t, s, "alice")
+	grants := make([]*attorney.GrantPowerOfAttorney, 10)
+	for n := range grants {
+		token, _ := crypto.RandomAsymetricKey()
+		if err := s.GrantPower("alice", token.Hex(), "", nil, GrantOrigin{Method: GrantWeb}); err != nil {
+			t.Fatalf("GrantPower: %v", err)
+		}
+		grant, err := s.GrantAction("alice", token.Hex())
+		if err != nil {
+			t.Fatalf("GrantAction: %v", err)
+		}
+		record, _ := s.vault.Record("alice", token)
+		grant.Epoch = record.Epoch
+		grants[n] = grant
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for _, grant := range grants {
+			s.IncorporateGrant(grant)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for n := 0; n < len(grants); n++ {
+			if err := s.vault.SetSession("alice", randomString(), true); err != nil {
+				t.Errorf("SetSession: %v", err)
+			}
+			if err := s.vault.SetLanguage("alice", LanguagePortuguese); err != nil {
+				t.Errorf("SetLanguage: %v", err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	for _, grant := range grants {
+		if record, ok := s.vault.Record("alice", grant.Attorney); !ok || !record.Confirmed {
+			t.Errorf("record of %v not confirmed: %+v", grant.Attorney.Hex(), record)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package safe
+
+import (
+	"strings"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// Scopes limit what an attorney can learn about the user through the REST
+// API. They are chosen by the user when the power of attorney is granted.
+const (
+	ScopeEmail   = "email"
+	ScopeProfile = "profile"
+)
+
+var KnownScopes = []string{ScopeEmail, ScopeProfile}
+
+// ParseScopes keeps only known scopes, without repetition and in the order
+// of KnownScopes.
+func ParseScopes(requested []string) []string {
+	scopes := make([]string, 0)
+	for _, scope := range KnownScopes {
+		for _, r := range requested {
+			if strings.TrimSpace(strings.ToLower(r)) == scope {
+				scopes = append(scopes, scope)
+				break
+			}
+		}
+	}
+	return scopes
+}
+
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Safe) AttorneyScopes(handle string, attorney crypto.Token) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[handle]
+	if !ok {
+		return nil
+	}
+	return user.Scopes[attorney]
+}
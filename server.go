@@ -3,12 +3,12 @@ package safe
 import (
 	"context"
 	"fmt"
-	"html/template"
+	"io/fs"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/freehandle/breeze/crypto"
 	"github.com/freehandle/breeze/socket"
 	"github.com/freehandle/breeze/util"
 	"github.com/freehandle/handles"
@@ -16,7 +16,8 @@ import (
 )
 
 var templateFiles = []string{
-	"main", "grant", "revoke", "login", "signin",
+	"main", "grant", "revoke", "login", "signin", "confirm", "authorize", "challenge",
+	"twofactor", "totp", "passkeys", "settings", "flash", "history",
 }
 
 func NewLocalServer(ctx context.Context, safeCfg SafeConfig, passwd string, gateway Sender, receive chan []byte) (chan error, *Safe) {
@@ -28,8 +29,12 @@ func NewLocalServer(ctx context.Context, safeCfg SafeConfig, passwd string, gate
 	}
 
 	go func() {
+		expire := time.NewTicker(expiryInterval)
+		defer expire.Stop()
 		for {
 			select {
+			case <-expire.C:
+				safe.ExpirePowers()
 			case action, ok := <-receive:
 				if !ok {
 					return
@@ -114,7 +119,8 @@ func NewServer(ctx context.Context, safeCfg SafeConfig, cfg GatewayConfig, passw
 					fmt.Printf(attorney.ToString(join.Serialize()))
 				}
 
-				safe.epoch = block.Epoch
+				safe.epoch.Store(block.Epoch)
+				safe.ExpirePowers()
 			case <-ctx.Done():
 				return
 			}
@@ -129,55 +135,117 @@ func newServerFromSendReceiver(ctx context.Context, config SafeConfig, passwd st
 		return nil, fmt.Errorf("could not open vault: %v", err)
 	}
 	safe := &Safe{
-		vault:      vault,
-		epoch:      1,
-		gateway:    gateway,
-		users:      make(map[string]*User),
-		Session:    util.OpenCokieStore(fmt.Sprintf("%v/cookies.dat", config.Path), 0),
-		serverName: config.ServerName,
-		pending:    make(map[string]*attorney.GrantPowerOfAttorney),
-		address:    config.Address,
+		vault:       vault,
+		gateway:     gateway,
+		users:       make(map[string]*User),
+		Session:     util.OpenCokieStore(fmt.Sprintf("%v/cookies.dat", config.Path), 0),
+		serverName:  config.ServerName,
+		pending:     make(map[string]*PendingGrant),
+		address:     config.Address,
+		credentials: config.Credentials,
+		admins:      config.Admins,
+		mailer:      config.Mailer,
+	}
+	safe.epoch.Store(1)
+	if safe.mailer == nil {
+		safe.mailer = LogMailer{}
+	}
+	safe.throttle = NewLoginThrottle(safe.lockoutNotice)
+	safe.handles = DefaultHandlePolicy
+	if config.HandlePolicy != nil {
+		safe.handles = *config.HandlePolicy
 	}
 
 	if safe.serverName == "" {
 		safe.address = fmt.Sprintf("localhost:%d", config.Port)
 	}
+	issuer := config.Issuer
+	if issuer == "" {
+		issuer = fmt.Sprintf("http://%v", safe.address)
+	}
+	safe.oidc = NewOIDCProvider(issuer, config.OIDCClients)
+	relyingParty, err := NewWebAuthnConfig(issuer)
+	if err != nil {
+		return nil, err
+	}
+	safe.webauthn = NewWebAuthn(relyingParty, vault)
+	safe.webhooks = NewWebhookDispatcher(vault, config.Credentials)
+	safe.events = NewEventHub()
+	safe.challenges = NewChallengeStore()
+	safe.idempotency = NewIdempotencyStore()
+	go safe.idempotency.SweepEvery(ctx, idempotencySweep)
+	safe.appNonces = NewAppNonceStore()
+	safe.grantors = NewAttorneyIndex()
+	safe.flashes = NewFlashStore()
+	safe.directory = NewAttorneyDirectory(vault)
+	safe.twoFactor = NewTwoFactor(vault, safe.address)
+	if config.DirectoryPath != "" {
+		if err := safe.directory.LoadFile(config.DirectoryPath, config.Admins); err != nil {
+			log.Printf("could not load attorney directory: %v", err)
+		}
+	}
 
 	for handle, user := range vault.handle {
-		safe.users[handle] = &User{
-			Token:     user.Secret.PublicKey(),
-			Attorneys: make([]crypto.Token, 0),
-		}
+		safe.users[handle] = NewUser(user.Secret.PublicKey())
+		safe.users[handle].Scopes = vault.HandleScopes(handle)
+		safe.users[handle].Expiry = vault.HandleExpiry(handle)
+		safe.users[handle].Frozen = vault.IsFrozen(handle)
+		safe.users[handle].Sessions = vault.HandleSessions(handle)
 	}
 	safe.actions, err = OpenSafeDatabase(fmt.Sprintf("%v/safe.dat", config.Path), attorney.GetHashes)
 	if err != nil {
 		return nil, fmt.Errorf("could not open safe database: %v", err)
 	}
+	safe.network, err = OpenNetworkIndex(fmt.Sprintf("%v/network.dat", config.Path))
+	if err != nil {
+		return nil, fmt.Errorf("could not open network index: %v", err)
+	}
 
-	safe.templates = template.New("root")
-	files := make([]string, len(templateFiles))
-	for n, file := range templateFiles {
-		files[n] = fmt.Sprintf("%v/templates/%v.html", config.HtmlPath, file)
+	assets, err := assetsFS(config.HtmlPath)
+	if err != nil {
+		return nil, err
+	}
+	safe.templates, err = NewTemplates(assets, config.DevMode)
+	if err != nil {
+		return nil, err
+	}
+	if missing, err := MissingTranslations(assets); err == nil && len(missing) > 0 {
+		log.Printf("missing translations: %v", strings.Join(missing, ", "))
 	}
-	t, err := template.ParseFiles(files...)
+	static, err := fs.Sub(assets, "static")
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	safe.templates = t
 
 	mux := http.NewServeMux()
-	fs := http.FileServer(http.Dir(fmt.Sprintf("%v/static", config.HtmlPath)))
-	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(static))))
 	mux.HandleFunc("/", safe.UserHandler)
 	mux.HandleFunc("/login", safe.LoginHandler)
 	mux.HandleFunc("/signin", safe.SigninHandler)
 	mux.HandleFunc("/credentials", safe.CredentialsHandler)
+	mux.HandleFunc("/login/totp", safe.TwoFactorLoginHandler)
+	mux.HandleFunc("/totp", safe.TOTPHandler)
+	mux.HandleFunc("/settings", safe.SettingsHandler)
+	mux.HandleFunc("/history", safe.HistoryHandler)
+	mux.HandleFunc("/history.json", safe.HistoryHandler)
+	mux.HandleFunc("/webauthn", safe.PasskeysHandler)
+	mux.HandleFunc("/webauthn/", safe.WebAuthnAPIHandler)
 	mux.HandleFunc("/newuser", safe.NewUserHandler)
 	//mux.HandleFunc("/grant", safe.GrantHandler)
 	mux.HandleFunc("/revoke/", safe.RevokePOAHandler)
 	mux.HandleFunc("/poa", safe.PoAHandler)
 	mux.HandleFunc("/signout", safe.SignoutHandlewr)
 	mux.HandleFunc("/confirm/", safe.ConfirmHandler)
+	mux.HandleFunc("/events", safe.EventsHandler)
+	mux.HandleFunc("/freeze", safe.FreezeHandler)
+	mux.HandleFunc("/unfreeze", safe.UnfreezeHandler)
+	mux.HandleFunc("/challenge/", safe.ChallengeHandler)
+	mux.HandleFunc(WellKnownPath, safe.WellKnownHandler)
+	mux.HandleFunc("/.well-known/openid-configuration", safe.DiscoveryHandler)
+	mux.HandleFunc("/oauth/jwks", safe.JWKSHandler)
+	mux.HandleFunc("/oauth/authorize", safe.AuthorizeHandler)
+	mux.HandleFunc("/oauth/token", safe.TokenHandler)
+	mux.HandleFunc("/oauth/userinfo", safe.UserinfoHandler)
 	srv := &http.Server{
 		Addr:         fmt.Sprintf("localhost:%v", config.Port),
 		Handler:      mux,
@@ -188,6 +256,7 @@ func newServerFromSendReceiver(ctx context.Context, config SafeConfig, passwd st
 		<-ctx.Done()
 		srv.Shutdown(ctx)
 		vault.Close()
+		safe.network.Close()
 		log.Print("safe server shutdown")
 	}()
 
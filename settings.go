@@ -0,0 +1,153 @@
+package safe
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+)
+
+const minPasswordLength = 8
+
+var (
+	ErrPasswordTooShort  = fmt.Errorf("password must have at least %v characters", minPasswordLength)
+	ErrPasswordMismatch  = errors.New("passwords do not match")
+	ErrPasswordUnchanged = errors.New("new password must differ from the current one")
+	ErrEmailInvalid      = errors.New("invalid email address")
+	ErrCurrentPassword   = errors.New("current password does not match")
+	ErrSessionsNotEnded  = errors.New("password changed but other sessions could not be ended")
+)
+
+// validEmail returns the bare address of email.
+func validEmail(email string) (string, error) {
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || address.Name != "" {
+		return "", ErrEmailInvalid
+	}
+	return address.Address, nil
+}
+
+// ChangePassword sets the password of handle after the caller checked the
+// current one and the second factor. Every other session of handle but
+// keep is ended, including the ones opened before the safe restarted, and
+// the user is told by mail. ErrSessionsNotEnded is returned if the password
+// was changed but some session could not be ended.
+func (s *Safe) ChangePassword(handle, password, keep string) error {
+	if len(password) < minPasswordLength {
+		return ErrPasswordTooShort
+	}
+	if s.CheckCredentials(handle, password) {
+		return ErrPasswordUnchanged
+	}
+	if err := s.UpdateUser(handle, password, ""); err != nil {
+		return err
+	}
+	if err := s.EndSessions(handle, keep); err != nil {
+		log.Printf("could not end sessions of %v: %v", handle, err)
+		s.notify(handle, "safe password changed",
+			fmt.Sprintf("The password of %v was changed but other sessions could not be logged out.\n\n"+
+				"If it was not you, contact the administrator of the safe.", handle))
+		return ErrSessionsNotEnded
+	}
+	s.notify(handle, "safe password changed",
+		fmt.Sprintf("The password of %v was changed and every other session was logged out.\n\n"+
+			"If it was not you, contact the administrator of the safe.", handle))
+	return nil
+}
+
+// ChangeEmail sets the email of handle after the caller checked the
+// password and the second factor. The previous address is told of the
+// change so that a hijacked account does not go unnoticed.
+func (s *Safe) ChangeEmail(handle, email string) error {
+	email, err := validEmail(email)
+	if err != nil {
+		return err
+	}
+	previous := s.Email(handle)
+	if email == previous {
+		return nil
+	}
+	if err := s.UpdateUser(handle, "", email); err != nil {
+		return err
+	}
+	s.mail(previous, "safe email changed",
+		fmt.Sprintf("The email of %v was changed from this address to %v.\n\n"+
+			"If it was not you, contact the administrator of the safe.", handle, email))
+	return nil
+}
+
+type SettingsView struct {
+	Handle        string
+	Email         string
+	TwoFactor     bool
+	PasswordError string
+	EmailError    string
+	Message       string
+	Language      string
+	Languages     []string
+}
+
+// SettingsHandler lets the user change the password, the email and the
+// language of the pages. The password and the email require the current
+// password and, if enabled, a second factor code.
+func (s *Safe) SettingsHandler(w http.ResponseWriter, r *http.Request) {
+	handle := s.Handle(r)
+	if handle == "" {
+		http.Redirect(w, r, fmt.Sprintf("%v/login?next=/settings", s.serverName), http.StatusSeeOther)
+		return
+	}
+	view := SettingsView{Handle: handle, TwoFactor: s.TwoFactorEnabled(handle), Languages: Languages}
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			return
+		}
+		if r.FormValue("action") == "language" {
+			if err := s.SetLanguage(handle, r.FormValue("language")); err != nil {
+				log.Printf("could not set language of %v: %v", handle, err)
+			}
+			http.Redirect(w, r, fmt.Sprintf("%v/settings", s.serverName), http.StatusSeeOther)
+			return
+		}
+		language := s.Language(r)
+		var err error
+		if err = s.Authenticate(handle, r.FormValue("current"), clientIP(r)); err != nil {
+			if err == ErrInvalidCredentials {
+				err = ErrCurrentPassword
+			}
+		} else {
+			err = s.SecondFactor(handle, r.FormValue("totp"), clientIP(r))
+		}
+		switch r.FormValue("action") {
+		case "password":
+			if err == nil && r.FormValue("password") != r.FormValue("repassword") {
+				err = ErrPasswordMismatch
+			}
+			if err == nil {
+				var keep string
+				if cookie, cookieErr := r.Cookie(cookieName); cookieErr == nil {
+					keep = cookie.Value
+				}
+				err = s.ChangePassword(handle, r.FormValue("password"), keep)
+			}
+			if err != nil {
+				view.PasswordError = translateError(language, err)
+			} else {
+				view.Message = Translate(language, "settings.password_changed")
+			}
+		case "email":
+			if err == nil {
+				err = s.ChangeEmail(handle, r.FormValue("email"))
+			}
+			if err != nil {
+				view.EmailError = translateError(language, err)
+			} else {
+				view.Message = Translate(language, "settings.email_changed")
+			}
+		}
+	}
+	view.Email = s.Email(handle)
+	view.Language = s.vault.Language(handle)
+	s.render(w, r, "settings.html", view)
+}
@@ -0,0 +1,24 @@
+package safe
+
+import "testing"
+
+func TestChangePasswordEndsOtherSessions(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	keep := testUser(t, s, "alice")
+	other := s.CreateSession("alice")
+	if err := s.ChangePassword("alice", "another horse battery", keep); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	if s.Handle(sessionRequest(other)) != "" {
+		t.Error("other session survived the password change")
+	}
+	if _, ok := s.vault.HandleSessions("alice")[other]; ok {
+		t.Error("other session is still active in the vault")
+	}
+	if s.Handle(sessionRequest(keep)) != "alice" {
+		t.Error("session changing the password was ended")
+	}
+	if !s.CheckCredentials("alice", "another horse battery") {
+		t.Error("password was not changed")
+	}
+}
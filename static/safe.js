@@ -0,0 +1,28 @@
+// live updates of the main page from the account event stream
+document.addEventListener("DOMContentLoaded", function () {
+  const bulk = document.getElementById("mainbulk");
+  if (!bulk || !window.EventSource) {
+    return;
+  }
+  const refresh = function () {
+    fetch("./")
+      .then(function (response) { return response.text(); })
+      .then(function (html) {
+        const page = new DOMParser().parseFromString(html, "text/html");
+        const updated = page.getElementById("mainbulk");
+        if (updated) {
+          bulk.innerHTML = updated.innerHTML;
+        }
+      });
+  };
+  const events = new EventSource("./events");
+  ["join_confirmed", "grant_sent", "grant_confirmed", "revoke_sent", "revoke_confirmed"].forEach(function (type) {
+    events.addEventListener(type, refresh);
+  });
+  events.addEventListener("new_session", function (event) {
+    const notice = document.getElementById("notice");
+    if (notice) {
+      notice.textContent = "new session opened at " + new Date(JSON.parse(event.data).time).toLocaleString();
+    }
+  });
+});
@@ -0,0 +1,105 @@
+// passkey registration and login against the /webauthn/ ceremonies
+(function () {
+  const decode = function (value) {
+    const base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+    const padded = base64 + "===".slice((base64.length + 3) % 4);
+    return Uint8Array.from(atob(padded), function (c) { return c.charCodeAt(0); }).buffer;
+  };
+  const encode = function (buffer) {
+    let binary = "";
+    new Uint8Array(buffer).forEach(function (b) { binary += String.fromCharCode(b); });
+    return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+  };
+  const post = function (path, body) {
+    return fetch(path, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      credentials: "same-origin",
+      body: JSON.stringify(body),
+    }).then(function (response) {
+      if (response.status === 204) {
+        return {};
+      }
+      return response.json().then(function (data) {
+        if (!response.ok) {
+          throw new Error(data.error ? data.error.message : response.statusText);
+        }
+        return data;
+      });
+    });
+  };
+  const report = function (error) {
+    const message = document.getElementById("webauthn-error");
+    if (message) {
+      message.textContent = error.message;
+    }
+  };
+  const descriptors = function (list) {
+    return (list || []).map(function (credential) {
+      return { type: credential.type, id: decode(credential.id) };
+    });
+  };
+
+  const register = function (form) {
+    post("/webauthn/register/begin", {})
+      .then(function (options) {
+        options.challenge = decode(options.challenge);
+        options.user.id = decode(options.user.id);
+        options.excludeCredentials = descriptors(options.excludeCredentials);
+        return navigator.credentials.create({ publicKey: options });
+      })
+      .then(function (credential) {
+        return post("/webauthn/register/finish", {
+          name: form.elements.name.value,
+          second_factor: form.elements.second_factor.checked,
+          totp: form.elements.totp ? form.elements.totp.value : "",
+          clientDataJSON: encode(credential.response.clientDataJSON),
+          attestationObject: encode(credential.response.attestationObject),
+        });
+      })
+      .then(function () { window.location.reload(); })
+      .catch(report);
+  };
+
+  const login = function (ticket, next) {
+    post("/webauthn/login/begin", { ticket: ticket })
+      .then(function (options) {
+        options.challenge = decode(options.challenge);
+        options.allowCredentials = descriptors(options.allowCredentials);
+        return navigator.credentials.get({ publicKey: options });
+      })
+      .then(function (assertion) {
+        return post("/webauthn/login/finish", {
+          ticket: ticket,
+          next: next,
+          id: encode(assertion.rawId),
+          clientDataJSON: encode(assertion.response.clientDataJSON),
+          authenticatorData: encode(assertion.response.authenticatorData),
+          signature: encode(assertion.response.signature),
+        });
+      })
+      .then(function (response) { window.location.href = response.redirect; })
+      .catch(report);
+  };
+
+  document.addEventListener("DOMContentLoaded", function () {
+    if (!window.PublicKeyCredential) {
+      document.querySelectorAll(".webauthn").forEach(function (element) { element.hidden = true; });
+      return;
+    }
+    const form = document.getElementById("webauthn-register");
+    if (form) {
+      form.addEventListener("submit", function (event) {
+        event.preventDefault();
+        register(form);
+      });
+    }
+    const button = document.getElementById("webauthn-login");
+    if (button) {
+      button.addEventListener("click", function (event) {
+        event.preventDefault();
+        login(button.dataset.ticket || "", button.dataset.next || "");
+      });
+    }
+  });
+})();
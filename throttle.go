@@ -0,0 +1,265 @@
+package safe
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Login throttling policy. Failures beyond the free ones block the next
+// attempt for a delay doubling on every failure. A handle is locked after
+// lockoutFailures consecutive failures until lockoutDuration passes or an
+// admin unlocks it.
+const (
+	handleFreeFailures = 3
+	ipFreeFailures     = 10
+	backoffBase        = time.Second
+	backoffMax         = 15 * time.Minute
+	lockoutFailures    = 10
+	lockoutDuration    = time.Hour
+	throttleForget     = 24 * time.Hour
+	// forgotten attempts are swept every throttleSweep, or every
+	// throttleSweepFull while either map holds more than maxThrottleEntries
+	throttleSweep      = 10 * time.Minute
+	throttleSweepFull  = time.Second
+	maxThrottleEntries = 100000
+)
+
+var ErrInvalidCredentials = errors.New("invalid handle or password")
+
+// ThrottleError is returned while attempts are blocked.
+type ThrottleError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *ThrottleError) Error() string {
+	wait := e.RetryAfter.Round(time.Second)
+	if e.Locked {
+		return fmt.Sprintf("account locked after too many failed attempts, try again in %v", wait)
+	}
+	return fmt.Sprintf("too many failed attempts, try again in %v", wait)
+}
+
+type attempts struct {
+	failures     int
+	last         time.Time
+	blockedUntil time.Time
+	locked       bool
+}
+
+func backoff(failures, free int) time.Duration {
+	exponent := failures - free - 1
+	if exponent < 0 {
+		return 0
+	}
+	if exponent > 30 {
+		return backoffMax
+	}
+	delay := time.Duration(math.Pow(2, float64(exponent))) * backoffBase
+	if delay > backoffMax {
+		return backoffMax
+	}
+	return delay
+}
+
+// LoginThrottle counts the failed attempts by handle and by client ip.
+// Handles are counted whether they exist or not so that lockouts do not
+// reveal which handles exist.
+type LoginThrottle struct {
+	mu      sync.Mutex
+	handles map[string]*attempts
+	ips     map[string]*attempts
+	swept   time.Time
+	// onLock is called without the lock when a handle is locked
+	onLock func(handle string, until time.Time)
+}
+
+func NewLoginThrottle(onLock func(handle string, until time.Time)) *LoginThrottle {
+	return &LoginThrottle{
+		handles: make(map[string]*attempts),
+		ips:     make(map[string]*attempts),
+		onLock:  onLock,
+	}
+}
+
+func (a *attempts) forgotten(now time.Time) bool {
+	return now.Sub(a.last) > throttleForget && now.After(a.blockedUntil)
+}
+
+// get returns the attempts of key, forgetting them after a quiet period.
+func get(entries map[string]*attempts, key string, now time.Time) *attempts {
+	entry, ok := entries[key]
+	if !ok || entry.forgotten(now) {
+		entry = &attempts{}
+		entries[key] = entry
+	}
+	return entry
+}
+
+// blocked returns the attempts of key if it is blocked, without keeping
+// anything for keys that never failed.
+func blocked(entries map[string]*attempts, key string, now time.Time) (*attempts, bool) {
+	entry, ok := entries[key]
+	if !ok || !now.Before(entry.blockedUntil) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// sweep drops forgotten attempts. If a map is still over
+// maxThrottleEntries, attempts that do not block anything are dropped as
+// well, so that floods of distinct handles or addresses cannot grow the
+// maps without bound.
+func (t *LoginThrottle) sweep(now time.Time) {
+	full := len(t.handles) > maxThrottleEntries || len(t.ips) > maxThrottleEntries
+	if elapsed := now.Sub(t.swept); elapsed < throttleSweepFull || (elapsed < throttleSweep && !full) {
+		return
+	}
+	t.swept = now
+	for _, entries := range []map[string]*attempts{t.handles, t.ips} {
+		for key, entry := range entries {
+			if entry.forgotten(now) {
+				delete(entries, key)
+			}
+		}
+		if len(entries) <= maxThrottleEntries {
+			continue
+		}
+		for key, entry := range entries {
+			if !now.Before(entry.blockedUntil) {
+				delete(entries, key)
+			}
+		}
+	}
+}
+
+// Check returns a ThrottleError if handle or ip are blocked.
+func (t *LoginThrottle) Check(handle, ip string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if entry, ok := blocked(t.handles, handle, now); ok {
+		return &ThrottleError{RetryAfter: entry.blockedUntil.Sub(now), Locked: entry.locked}
+	}
+	if entry, ok := blocked(t.ips, ip, now); ok {
+		return &ThrottleError{RetryAfter: entry.blockedUntil.Sub(now)}
+	}
+	return nil
+}
+
+// Fail records a failed attempt on handle from ip.
+func (t *LoginThrottle) Fail(handle, ip string) {
+	t.mu.Lock()
+	now := time.Now()
+	t.sweep(now)
+	entry := get(t.handles, handle, now)
+	entry.failures++
+	entry.last = now
+	entry.blockedUntil = now.Add(backoff(entry.failures, handleFreeFailures))
+	lock := entry.failures >= lockoutFailures && !entry.locked
+	if lock {
+		entry.locked = true
+		entry.blockedUntil = now.Add(lockoutDuration)
+	}
+	until := entry.blockedUntil
+	address := get(t.ips, ip, now)
+	address.failures++
+	address.last = now
+	address.blockedUntil = now.Add(backoff(address.failures, ipFreeFailures))
+	t.mu.Unlock()
+	if lock && t.onLock != nil {
+		t.onLock(handle, until)
+	}
+}
+
+// CheckIP returns a ThrottleError if ip is blocked.
+func (t *LoginThrottle) CheckIP(ip string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if entry, ok := blocked(t.ips, ip, now); ok {
+		return &ThrottleError{RetryAfter: entry.blockedUntil.Sub(now)}
+	}
+	return nil
+}
+
+// Probe records an unauthenticated question about the existence of a
+// handle from ip. Probes count as failures of the ip only, so they never
+// lock the handle out.
+func (t *LoginThrottle) Probe(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.sweep(now)
+	address := get(t.ips, ip, now)
+	address.failures++
+	address.last = now
+	address.blockedUntil = now.Add(backoff(address.failures, ipFreeFailures))
+}
+
+// Succeed clears the failures of handle. The failures of the ip are kept
+// so that logging into an account of the attacker does not reset them.
+func (t *LoginThrottle) Succeed(handle string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.handles, handle)
+}
+
+// Unlock clears the failures and lockout of handle.
+func (t *LoginThrottle) Unlock(handle string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.handles[handle]
+	delete(t.handles, handle)
+	return ok && entry.locked
+}
+
+// clientIP is the address of the client of r. The safe listens on
+// localhost behind a reverse proxy, so for loopback connections the last
+// address added to X-Forwarded-For by the proxy is used.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			addresses := strings.Split(forwarded, ",")
+			return strings.TrimSpace(addresses[len(addresses)-1])
+		}
+	}
+	return host
+}
+
+// Authenticate checks the password of handle subject to throttling. It
+// returns ErrInvalidCredentials or a *ThrottleError on failure.
+func (s *Safe) Authenticate(handle, password, ip string) error {
+	if err := s.throttle.Check(handle, ip); err != nil {
+		return err
+	}
+	if !s.CheckCredentials(handle, password) {
+		s.throttle.Fail(handle, ip)
+		return ErrInvalidCredentials
+	}
+	s.throttle.Succeed(handle)
+	return nil
+}
+
+// lockoutNotice tells the user that the account was locked. Unknown handles
+// are locked as well but nobody is notified.
+func (s *Safe) lockoutNotice(handle string, until time.Time) {
+	if _, ok := s.vault.UserSecret(handle); !ok {
+		return
+	}
+	s.events.Publish(AccountEvent{Type: EventAccountLocked, Handle: handle})
+	s.notify(handle, "safe account locked",
+		fmt.Sprintf("Too many failed attempts to log in as %v. The account is locked until %v.\n\n"+
+			"If it was not you, someone is trying to guess your password. Consider changing it and enabling two-factor authentication.",
+			handle, until.Format(time.RFC1123)))
+}
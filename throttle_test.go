@@ -0,0 +1,60 @@
+package safe
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestThrottleCheckKeepsNothing(t *testing.T) {
+	throttle := NewLoginThrottle(nil)
+	for n := 0; n < 100; n++ {
+		if err := throttle.Check(fmt.Sprintf("handle%v", n), fmt.Sprintf("10.0.0.%v", n)); err != nil {
+			t.Fatalf("Check: %v", err)
+		}
+	}
+	if len(throttle.handles) != 0 || len(throttle.ips) != 0 {
+		t.Errorf("checks kept %v handles and %v ips", len(throttle.handles), len(throttle.ips))
+	}
+}
+
+func TestThrottleSweep(t *testing.T) {
+	throttle := NewLoginThrottle(nil)
+	throttle.Fail("alice", "10.0.0.1")
+	throttle.Fail("bob", "10.0.0.2")
+	// alice went quiet long ago, bob is still blocked
+	old := time.Now().Add(-2 * throttleForget)
+	throttle.handles["alice"].last = old
+	throttle.handles["alice"].blockedUntil = old
+	throttle.ips["10.0.0.1"].last = old
+	throttle.ips["10.0.0.1"].blockedUntil = old
+	throttle.handles["bob"].blockedUntil = time.Now().Add(time.Hour)
+
+	throttle.sweep(time.Now().Add(throttleSweep))
+	if _, ok := throttle.handles["alice"]; ok {
+		t.Error("forgotten handle was not swept")
+	}
+	if _, ok := throttle.ips["10.0.0.1"]; ok {
+		t.Error("forgotten ip was not swept")
+	}
+	var throttled *ThrottleError
+	if err := throttle.Check("bob", "10.0.0.3"); !errors.As(err, &throttled) {
+		t.Errorf("blocked handle was swept: %v", err)
+	}
+}
+
+func TestThrottleCap(t *testing.T) {
+	throttle := NewLoginThrottle(nil)
+	for n := 0; n <= maxThrottleEntries; n++ {
+		throttle.handles[fmt.Sprintf("handle%v", n)] = &attempts{failures: 1, last: time.Now()}
+	}
+	throttle.handles["locked"] = &attempts{failures: lockoutFailures, last: time.Now(), blockedUntil: time.Now().Add(time.Hour), locked: true}
+	throttle.Fail("alice", "10.0.0.1")
+	if len(throttle.handles) > maxThrottleEntries {
+		t.Errorf("%v handles kept, want at most %v", len(throttle.handles), maxThrottleEntries)
+	}
+	if _, ok := throttle.handles["locked"]; !ok {
+		t.Error("locked handle was dropped")
+	}
+}
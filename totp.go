@@ -0,0 +1,452 @@
+package safe
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// TOTP as in RFC 6238 with the parameters every authenticator app supports:
+// HMAC-SHA1, 6 digits and 30 second steps.
+const (
+	totpPeriod      = 30
+	totpDigits      = 6
+	totpSecretSize  = 20
+	totpSkew        = 1
+	recoveryCount   = 10
+	loginTicketTTL  = 5 * time.Minute
+	loginTicketSize = 32
+)
+
+var ErrSecondFactor = errors.New("invalid or missing second factor code")
+
+var (
+	ErrTwoFactorEnabled  = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorDisabled = errors.New("two-factor authentication not enabled")
+	ErrNoEnrollment      = errors.New("no pending enrollment")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func totpStep(t time.Time) uint64 {
+	return uint64(t.Unix()) / totpPeriod
+}
+
+// totpCode computes the HOTP value of secret at step (RFC 4226).
+func totpCode(secret []byte, step uint64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], step)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// ProvisioningURI is the otpauth uri to be shown as a QR code or opened by
+// an authenticator app.
+func ProvisioningURI(issuer, handle string, secret []byte) string {
+	values := url.Values{
+		"secret":    {totpEncoding.EncodeToString(secret)},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	label := url.PathEscape(issuer + ":" + handle)
+	return fmt.Sprintf("otpauth://totp/%v?%v", label, values.Encode())
+}
+
+// normalizeCode removes the spaces and dashes users type in codes.
+func normalizeCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
+
+func newRecoveryCodes() ([]string, []crypto.Hash) {
+	codes := make([]string, recoveryCount)
+	hashes := make([]crypto.Hash, recoveryCount)
+	for n := range codes {
+		random := make([]byte, 7)
+		rand.Read(random)
+		code := strings.ToLower(totpEncoding.EncodeToString(random))[:10]
+		codes[n] = code[:5] + "-" + code[5:]
+		hashes[n] = crypto.Hasher([]byte(code))
+	}
+	return codes, hashes
+}
+
+type loginTicket struct {
+	handle  string
+	next    string
+	expires time.Time
+}
+
+// TwoFactor keeps the TOTP secrets of the users in the vault, the last
+// accepted step of each user to prevent replays and the logins waiting for
+// the second factor.
+type TwoFactor struct {
+	mu       sync.Mutex
+	vault    *Vault
+	issuer   string
+	lastStep map[string]uint64
+	tickets  map[string]loginTicket
+}
+
+func NewTwoFactor(vault *Vault, issuer string) *TwoFactor {
+	return &TwoFactor{
+		vault:    vault,
+		issuer:   issuer,
+		lastStep: make(map[string]uint64),
+		tickets:  make(map[string]loginTicket),
+	}
+}
+
+func (t *TwoFactor) Enabled(handle string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	totp, ok := t.vault.TOTP(handle)
+	return ok && totp.Enabled
+}
+
+// Enroll creates a new secret for handle. It is only enabled once a code is
+// confirmed. An enabled secret is not replaced.
+func (t *TwoFactor) Enroll(handle string) ([]byte, string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if totp, ok := t.vault.TOTP(handle); ok && totp.Enabled {
+		return nil, "", ErrTwoFactorEnabled
+	}
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	if err := t.vault.SetTOTP(TOTPSecret{Handle: handle, Secret: secret}); err != nil {
+		return nil, "", err
+	}
+	return secret, ProvisioningURI(t.issuer, handle, secret), nil
+}
+
+// Pending returns the secret of an enrollment not yet confirmed.
+func (t *TwoFactor) Pending(handle string) ([]byte, string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	totp, ok := t.vault.TOTP(handle)
+	if !ok || totp.Enabled {
+		return nil, "", false
+	}
+	return totp.Secret, ProvisioningURI(t.issuer, handle, totp.Secret), true
+}
+
+// validCode checks code against the secret of handle and records the step
+// so that the same code is not accepted twice. It must be called with the
+// lock held.
+func (t *TwoFactor) validCode(handle string, secret []byte, code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	now := totpStep(time.Now())
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= t.lastStep[handle] {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			t.lastStep[handle] = step
+			return true
+		}
+	}
+	return false
+}
+
+// Confirm enables the pending enrollment of handle if code matches and
+// returns the recovery codes, which are only kept hashed.
+func (t *TwoFactor) Confirm(handle, code string) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	totp, ok := t.vault.TOTP(handle)
+	if !ok || totp.Enabled {
+		return nil, ErrNoEnrollment
+	}
+	if !t.validCode(handle, totp.Secret, normalizeCode(code)) {
+		return nil, ErrSecondFactor
+	}
+	codes, hashes := newRecoveryCodes()
+	totp.Enabled = true
+	totp.Recovery = hashes
+	if err := t.vault.SetTOTP(totp); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify returns true if handle has no second factor enabled or code is a
+// valid TOTP code or an unused recovery code, which is then consumed.
+func (t *TwoFactor) Verify(handle, code string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	totp, ok := t.vault.TOTP(handle)
+	if !ok || !totp.Enabled {
+		return true
+	}
+	code = normalizeCode(code)
+	if t.validCode(handle, totp.Secret, code) {
+		return true
+	}
+	hashed := crypto.Hasher([]byte(code))
+	for n, recovery := range totp.Recovery {
+		if recovery.Equal(hashed) {
+			remaining := make([]crypto.Hash, 0, len(totp.Recovery)-1)
+			remaining = append(remaining, totp.Recovery[:n]...)
+			totp.Recovery = append(remaining, totp.Recovery[n+1:]...)
+			if err := t.vault.SetTOTP(totp); err != nil {
+				log.Printf("could not consume recovery code: %v", err)
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// RecoveryLeft returns the number of unused recovery codes of handle.
+func (t *TwoFactor) RecoveryLeft(handle string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	totp, _ := t.vault.TOTP(handle)
+	return len(totp.Recovery)
+}
+
+// NewRecoveryCodes replaces the recovery codes of handle.
+func (t *TwoFactor) NewRecoveryCodes(handle string) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	totp, ok := t.vault.TOTP(handle)
+	if !ok || !totp.Enabled {
+		return nil, ErrTwoFactorDisabled
+	}
+	codes, hashes := newRecoveryCodes()
+	totp.Recovery = hashes
+	if err := t.vault.SetTOTP(totp); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable removes the second factor of handle.
+func (t *TwoFactor) Disable(handle string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.vault.TOTP(handle); !ok {
+		return nil
+	}
+	delete(t.lastStep, handle)
+	return t.vault.SetTOTP(TOTPSecret{Handle: handle})
+}
+
+// NewTicket holds a login that passed the password check until the second
+// factor is given.
+func (t *TwoFactor) NewTicket(handle, next string) string {
+	random := make([]byte, loginTicketSize)
+	rand.Read(random)
+	ticket := totpEncoding.EncodeToString(random)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for key, pending := range t.tickets {
+		if now.After(pending.expires) {
+			delete(t.tickets, key)
+		}
+	}
+	t.tickets[ticket] = loginTicket{handle: handle, next: next, expires: now.Add(loginTicketTTL)}
+	return ticket
+}
+
+// Ticket returns the login held by ticket, if not expired.
+func (t *TwoFactor) Ticket(ticket string) (loginTicket, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending, ok := t.tickets[ticket]
+	if !ok || time.Now().After(pending.expires) {
+		delete(t.tickets, ticket)
+		return loginTicket{}, false
+	}
+	return pending, true
+}
+
+func (t *TwoFactor) EndTicket(ticket string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.tickets, ticket)
+}
+
+// SecondFactor checks the code required for sensitive actions of handle
+// from ip. Codes are throttled as passwords, a session is not a free pass to
+// guess them. It returns ErrSecondFactor or a *ThrottleError on failure.
+func (s *Safe) SecondFactor(handle, code, ip string) error {
+	if !s.twoFactor.Enabled(handle) {
+		return nil
+	}
+	if err := s.throttle.Check(handle, ip); err != nil {
+		return err
+	}
+	if !s.twoFactor.Verify(handle, code) {
+		s.throttle.Fail(handle, ip)
+		return ErrSecondFactor
+	}
+	s.throttle.Succeed(handle)
+	return nil
+}
+
+func (s *Safe) TwoFactorEnabled(handle string) bool {
+	return s.twoFactor.Enabled(handle)
+}
+
+type TwoFactorLoginView struct {
+	Ticket      string
+	TOTP        bool
+	SecurityKey bool
+	Error       string
+}
+
+// TwoFactorLoginHandler completes a login held by CredentialsHandler once
+// the second factor is given.
+func (s *Safe) TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		return
+	}
+	ticket := r.FormValue("ticket")
+	pending, ok := s.twoFactor.Ticket(ticket)
+	if !ok {
+		http.Redirect(w, r, fmt.Sprintf("%v/login", s.serverName), http.StatusSeeOther)
+		return
+	}
+	view := TwoFactorLoginView{
+		Ticket:      ticket,
+		TOTP:        s.TwoFactorEnabled(pending.handle),
+		SecurityKey: s.HasSecurityKey(pending.handle),
+	}
+	// codes are throttled as passwords, a ticket is not a free pass to
+	// guess them
+	ip := clientIP(r)
+	language := s.Language(r)
+	if err := s.throttle.Check(pending.handle, ip); err != nil {
+		view.Error = translateError(language, err)
+	} else if !s.TwoFactorEnabled(pending.handle) || !s.twoFactor.Verify(pending.handle, r.FormValue("code")) {
+		// users with only a security key must use it, Verify accepts any
+		// code from users without TOTP
+		s.throttle.Fail(pending.handle, ip)
+		view.Error = Translate(language, "twofactor.invalid_code")
+	}
+	if view.Error != "" {
+		s.render(w, r, "twofactor.html", view)
+		return
+	}
+	s.throttle.Succeed(pending.handle)
+	s.twoFactor.EndTicket(ticket)
+	s.startSession(w, r, pending.handle, pending.next)
+}
+
+type TOTPView struct {
+	Handle       string
+	Enabled      bool
+	Enrolling    bool
+	Secret       string
+	URI          template.URL
+	Recovery     []string
+	RecoveryLeft int
+	Error        string
+	CSRF         string
+}
+
+// groupSecret splits the base32 secret in groups of four to be typed in.
+func groupSecret(secret []byte) string {
+	encoded := totpEncoding.EncodeToString(secret)
+	groups := make([]string, 0, len(encoded)/4+1)
+	for len(encoded) > 4 {
+		groups = append(groups, encoded[:4])
+		encoded = encoded[4:]
+	}
+	return strings.Join(append(groups, encoded), " ")
+}
+
+// TOTPHandler manages the enrollment of the second factor. POST actions
+// are enroll, confirm, recovery and disable. Replacing the recovery codes
+// and disabling require a current code.
+func (s *Safe) TOTPHandler(w http.ResponseWriter, r *http.Request) {
+	handle := s.Handle(r)
+	if handle == "" {
+		http.Redirect(w, r, fmt.Sprintf("%v/login?next=/totp", s.serverName), http.StatusSeeOther)
+		return
+	}
+	view := TOTPView{Handle: handle, CSRF: s.csrfToken(r)}
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			return
+		}
+		if !s.checkCSRF(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		code := r.FormValue("code")
+		language := s.Language(r)
+		switch r.FormValue("action") {
+		case "enroll":
+			if _, _, err := s.twoFactor.Enroll(handle); err != nil {
+				view.Error = translateError(language, err)
+			}
+		case "confirm":
+			codes, err := s.twoFactor.Confirm(handle, code)
+			if err != nil {
+				view.Error = translateError(language, err)
+			}
+			view.Recovery = codes
+		case "recovery":
+			if !s.twoFactor.Enabled(handle) {
+				view.Error = translateError(language, ErrSecondFactor)
+			} else if err := s.SecondFactor(handle, code, clientIP(r)); err != nil {
+				view.Error = translateError(language, err)
+			} else if codes, err := s.twoFactor.NewRecoveryCodes(handle); err != nil {
+				view.Error = translateError(language, err)
+			} else {
+				view.Recovery = codes
+			}
+		case "disable":
+			if err := s.Authenticate(handle, r.FormValue("password"), clientIP(r)); err != nil {
+				view.Error = translateError(language, err)
+			} else if err := s.SecondFactor(handle, code, clientIP(r)); err != nil {
+				view.Error = translateError(language, err)
+			} else if err := s.twoFactor.Disable(handle); err != nil {
+				view.Error = translateError(language, err)
+			}
+		}
+	}
+	view.Enabled = s.twoFactor.Enabled(handle)
+	if secret, uri, ok := s.twoFactor.Pending(handle); ok {
+		view.Enrolling = true
+		view.Secret = groupSecret(secret)
+		// otpauth is not a scheme html/template trusts
+		view.URI = template.URL(uri)
+	}
+	if view.Enabled {
+		view.RecoveryLeft = s.twoFactor.RecoveryLeft(handle)
+	}
+	s.render(w, r, "totp.html", view)
+}
@@ -0,0 +1,161 @@
+package safe
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// testTOTP enables the second factor of handle and returns its secret. The
+// step of the confirmation code is spent.
+func testTOTP(t *testing.T, s *Safe, handle string) []byte {
+	t.Helper()
+	secret, _, err := s.twoFactor.Enroll(handle)
+	if err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+	if _, err := s.twoFactor.Confirm(handle, totpCode(secret, totpStep(time.Now()))); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	return secret
+}
+
+// awayFromStepEdge waits for a new step if the current one is about to end
+// so that the steps computed by a test are those seen by the safe.
+func awayFromStepEdge() {
+	if time.Now().Unix()%totpPeriod >= totpPeriod-2 {
+		time.Sleep(3 * time.Second)
+	}
+}
+
+// TestTOTPCode checks the SHA1 vectors of RFC 6238, truncated to six digits.
+func TestTOTPCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, code := range vectors {
+		if got := totpCode(secret, totpStep(time.Unix(unix, 0))); got != code {
+			t.Errorf("code at %v = %v, want %v", unix, got, code)
+		}
+	}
+}
+
+func TestTOTPSkew(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	for _, handle := range []string{"alice", "bob", "carol", "dave"} {
+		testUser(t, s, handle)
+	}
+	awayFromStepEdge()
+	now := totpStep(time.Now())
+	for handle, step := range map[string]uint64{"alice": now - 1, "bob": now + 1} {
+		secret, _, _ := s.twoFactor.Enroll(handle)
+		if _, err := s.twoFactor.Confirm(handle, totpCode(secret, step)); err != nil {
+			t.Errorf("code one step off refused: %v", err)
+		}
+	}
+	for handle, step := range map[string]uint64{"carol": now - 2, "dave": now + 2} {
+		secret, _, _ := s.twoFactor.Enroll(handle)
+		if _, err := s.twoFactor.Confirm(handle, totpCode(secret, step)); err == nil {
+			t.Errorf("code two steps off accepted")
+		}
+	}
+}
+
+func TestTOTPReplay(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	testUser(t, s, "alice")
+	awayFromStepEdge()
+	secret := testTOTP(t, s, "alice")
+	now := totpStep(time.Now())
+	if s.twoFactor.Verify("alice", totpCode(secret, now)) {
+		t.Errorf("confirmation code accepted again")
+	}
+	if s.twoFactor.Verify("alice", totpCode(secret, now-1)) {
+		t.Errorf("code of a step before the last accepted one accepted")
+	}
+	next := totpCode(secret, now+1)
+	if !s.twoFactor.Verify("alice", next) {
+		t.Fatalf("code of the next step refused")
+	}
+	if s.twoFactor.Verify("alice", next) {
+		t.Errorf("code accepted twice")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	testUser(t, s, "alice")
+	secret, _, _ := s.twoFactor.Enroll("alice")
+	codes, err := s.twoFactor.Confirm("alice", totpCode(secret, totpStep(time.Now())))
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	if len(codes) != recoveryCount || s.twoFactor.RecoveryLeft("alice") != recoveryCount {
+		t.Fatalf("%v recovery codes, %v left", len(codes), s.twoFactor.RecoveryLeft("alice"))
+	}
+	if !s.twoFactor.Verify("alice", codes[0]) {
+		t.Fatalf("recovery code refused")
+	}
+	if s.twoFactor.Verify("alice", codes[0]) {
+		t.Errorf("recovery code accepted twice")
+	}
+	if left := s.twoFactor.RecoveryLeft("alice"); left != recoveryCount-1 {
+		t.Errorf("%v recovery codes left, want %v", left, recoveryCount-1)
+	}
+	renewed, err := s.twoFactor.NewRecoveryCodes("alice")
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes: %v", err)
+	}
+	if s.twoFactor.Verify("alice", codes[1]) {
+		t.Errorf("replaced recovery code accepted")
+	}
+	if !s.twoFactor.Verify("alice", renewed[0]) {
+		t.Errorf("new recovery code refused")
+	}
+}
+
+func TestSecondFactorThrottled(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	session := testUser(t, s, "alice")
+	awayFromStepEdge()
+	secret := testTOTP(t, s, "alice")
+	for n := 0; n <= handleFreeFailures; n++ {
+		if err := s.SecondFactor("alice", "000000", "10.0.0.1"); err == nil {
+			t.Fatalf("wrong code accepted")
+		}
+	}
+	var throttled *ThrottleError
+	if err := s.SecondFactor("alice", totpCode(secret, totpStep(time.Now())+1), "10.0.0.1"); !errors.As(err, &throttled) {
+		t.Fatalf("code checked while blocked: %v", err)
+	}
+	if w := restCall(t, s, http.MethodPost, "/v1/users/alice/freeze", session, nil); w.Code != http.StatusAccepted {
+		t.Fatalf("POST freeze = %v", w.Code)
+	}
+	session = s.CreateSession("alice")
+	if w := restCall(t, s, http.MethodPost, "/v1/users/alice/unfreeze", session, UnfreezeRequest{Password: "correct horse battery"}); w.Code != http.StatusTooManyRequests {
+		t.Errorf("REST step up while blocked = %v, want 429", w.Code)
+	}
+}
+
+func TestTOTPHandlerChecksCSRF(t *testing.T) {
+	s := testSafe(t, &testGateway{})
+	session := testUser(t, s, "alice")
+	if w := formPost(s.TOTPHandler, "/totp", session, url.Values{"action": {"enroll"}}); w.Code != http.StatusForbidden {
+		t.Errorf("enroll without CSRF token = %v", w.Code)
+	}
+	if _, _, ok := s.twoFactor.Pending("alice"); ok {
+		t.Fatalf("enrolled without CSRF token")
+	}
+	formPost(s.TOTPHandler, "/totp", session, url.Values{"action": {"enroll"}, csrfField: {s.csrfToken(sessionRequest(session))}})
+	if _, _, ok := s.twoFactor.Pending("alice"); !ok {
+		t.Errorf("not enrolled with CSRF token")
+	}
+}
@@ -9,6 +9,33 @@ type User struct {
 	Token     crypto.Token
 	Attorneys []crypto.Token
 	Confirmed bool
+	Scopes    map[crypto.Token][]string
+	Expiry    map[crypto.Token]Expiry
+	Frozen    bool
+	Records   map[crypto.Token]AttorneyRecord
+	// Sessions are the active session cookies, restored from the vault on
+	// start. A cookie is only valid while it is here.
+	Sessions map[string]struct{}
+}
+
+func NewUser(token crypto.Token) *User {
+	return &User{
+		Token:     token,
+		Attorneys: make([]crypto.Token, 0),
+		Scopes:    make(map[crypto.Token][]string),
+		Expiry:    make(map[crypto.Token]Expiry),
+		Records:   make(map[crypto.Token]AttorneyRecord),
+		Sessions:  make(map[string]struct{}),
+	}
+}
+
+func (a *User) IsAttorney(token crypto.Token) bool {
+	for _, grantee := range a.Attorneys {
+		if grantee.Equal(token) {
+			return true
+		}
+	}
+	return false
 }
 
 func (a *User) GrantPower(grant *attorney.GrantPowerOfAttorney) {
@@ -24,6 +51,9 @@ func (a *User) RevokePower(revoke *attorney.RevokePowerOfAttorney) {
 	for n, grantee := range a.Attorneys {
 		if grantee.Equal(revoke.Attorney) {
 			a.Attorneys = append(a.Attorneys[:n], a.Attorneys[n+1:]...)
+			delete(a.Scopes, revoke.Attorney)
+			delete(a.Expiry, revoke.Attorney)
+			delete(a.Records, revoke.Attorney)
 			return
 		}
 	}
@@ -0,0 +1,272 @@
+package safe
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+)
+
+// authenticator data flags
+const (
+	flagUserPresent  byte = 0x01
+	flagUserVerified byte = 0x04
+	flagAttested     byte = 0x40
+)
+
+// COSE algorithms accepted for credentials.
+const (
+	coseES256 int64 = -7
+	coseEdDSA int64 = -8
+	coseRS256 int64 = -257
+)
+
+var b64url = base64.RawURLEncoding
+
+// WebAuthnConfig identifies the relying party. Origin is the scheme and
+// host the browser reports and RPID the host credentials are scoped to.
+type WebAuthnConfig struct {
+	RPID   string
+	RPName string
+	Origin string
+}
+
+// NewWebAuthnConfig derives the relying party from the public url of the
+// safe.
+func NewWebAuthnConfig(publicURL string) (WebAuthnConfig, error) {
+	parsed, err := url.Parse(publicURL)
+	if err != nil || parsed.Host == "" {
+		return WebAuthnConfig{}, fmt.Errorf("invalid public url %v", publicURL)
+	}
+	return WebAuthnConfig{
+		RPID:   parsed.Hostname(),
+		RPName: "safe",
+		Origin: parsed.Scheme + "://" + parsed.Host,
+	}, nil
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// parseAuthenticatorData parses the authenticator data of section 6.1 of
+// the WebAuthn specification. Extensions are ignored.
+func parseAuthenticatorData(data []byte) (authenticatorData, error) {
+	var auth authenticatorData
+	if len(data) < 37 {
+		return auth, errors.New("authenticator data too short")
+	}
+	auth.RPIDHash = data[:32]
+	auth.Flags = data[32]
+	auth.SignCount = binary.BigEndian.Uint32(data[33:37])
+	if auth.Flags&flagAttested == 0 {
+		return auth, nil
+	}
+	rest := data[37:]
+	// aaguid and credential id length
+	if len(rest) < 18 {
+		return auth, errors.New("attested credential data too short")
+	}
+	length := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < length {
+		return auth, errors.New("invalid credential id length")
+	}
+	auth.CredentialID = rest[:length]
+	rest = rest[length:]
+	key, remaining, err := decodeCBOR(rest)
+	if err != nil {
+		return auth, fmt.Errorf("invalid credential public key: %v", err)
+	}
+	if _, ok := key.(map[any]any); !ok {
+		return auth, errors.New("invalid credential public key")
+	}
+	auth.PublicKey = rest[:len(rest)-len(remaining)]
+	return auth, nil
+}
+
+func (c WebAuthnConfig) verifyClientData(raw []byte, kind string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return errors.New("invalid client data")
+	}
+	if data.Type != kind {
+		return fmt.Errorf("client data type is not %v", kind)
+	}
+	received, err := b64url.DecodeString(data.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return errors.New("challenge does not match")
+	}
+	if data.Origin != c.Origin {
+		return fmt.Errorf("unexpected origin %v", data.Origin)
+	}
+	return nil
+}
+
+func (c WebAuthnConfig) verifyAuthenticatorData(auth authenticatorData, requireUV bool) error {
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if subtle.ConstantTimeCompare(auth.RPIDHash, rpIDHash[:]) != 1 {
+		return errors.New("relying party id does not match")
+	}
+	if auth.Flags&flagUserPresent == 0 {
+		return errors.New("user not present")
+	}
+	if requireUV && auth.Flags&flagUserVerified == 0 {
+		return errors.New("user not verified")
+	}
+	return nil
+}
+
+// VerifyRegistration checks the response of navigator.credentials.create.
+// Credentials are requested with attestation none, so the attestation
+// statement is not verified whatever its format.
+func (c WebAuthnConfig) VerifyRegistration(clientDataJSON, attestationObject, challenge []byte) (authenticatorData, error) {
+	var auth authenticatorData
+	if err := c.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return auth, err
+	}
+	decoded, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return auth, fmt.Errorf("invalid attestation object: %v", err)
+	}
+	object, ok := decoded.(map[any]any)
+	if !ok {
+		return auth, errors.New("invalid attestation object")
+	}
+	raw, ok := object["authData"].([]byte)
+	if !ok {
+		return auth, errors.New("missing authenticator data")
+	}
+	if auth, err = parseAuthenticatorData(raw); err != nil {
+		return auth, err
+	}
+	if err := c.verifyAuthenticatorData(auth, false); err != nil {
+		return auth, err
+	}
+	if auth.CredentialID == nil {
+		return auth, errors.New("missing attested credential")
+	}
+	if _, err := coseAlgorithm(auth.PublicKey); err != nil {
+		return auth, err
+	}
+	return auth, nil
+}
+
+// VerifyAssertion checks the response of navigator.credentials.get for
+// credential and returns the new signature counter.
+func (c WebAuthnConfig) VerifyAssertion(credential WebAuthnCredential, clientDataJSON, authData, signature, challenge []byte, requireUV bool) (uint32, error) {
+	if err := c.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	auth, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.verifyAuthenticatorData(auth, requireUV); err != nil {
+		return 0, err
+	}
+	hash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), hash[:]...)
+	if err := verifyCOSE(credential.PublicKey, signed, signature); err != nil {
+		return 0, err
+	}
+	// counters that do not increase signal a cloned authenticator, unless
+	// the authenticator does not implement them
+	if (auth.SignCount != 0 || credential.SignCount != 0) && auth.SignCount <= credential.SignCount {
+		return 0, errors.New("signature counter did not increase")
+	}
+	return auth.SignCount, nil
+}
+
+func coseKey(key []byte) (map[any]any, error) {
+	decoded, _, err := decodeCBOR(key)
+	if err != nil {
+		return nil, err
+	}
+	parsed, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, errors.New("invalid cose key")
+	}
+	return parsed, nil
+}
+
+func coseAlgorithm(key []byte) (int64, error) {
+	parsed, err := coseKey(key)
+	if err != nil {
+		return 0, err
+	}
+	alg, _ := parsed[int64(3)].(int64)
+	switch alg {
+	case coseES256, coseEdDSA, coseRS256:
+		return alg, nil
+	}
+	return 0, fmt.Errorf("unsupported credential algorithm %v", alg)
+}
+
+// verifyCOSE verifies signature of data by the COSE encoded key.
+func verifyCOSE(key, data, signature []byte) error {
+	parsed, err := coseKey(key)
+	if err != nil {
+		return err
+	}
+	alg, _ := parsed[int64(3)].(int64)
+	switch alg {
+	case coseES256:
+		x, _ := parsed[int64(-2)].([]byte)
+		y, _ := parsed[int64(-3)].([]byte)
+		if crv, _ := parsed[int64(-1)].(int64); crv != 1 || len(x) != 32 || len(y) != 32 {
+			return errors.New("invalid P-256 key")
+		}
+		public := ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return errors.New("invalid P-256 key")
+		}
+		hash := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(&public, hash[:], signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case coseEdDSA:
+		x, _ := parsed[int64(-2)].([]byte)
+		if crv, _ := parsed[int64(-1)].(int64); crv != 6 || len(x) != ed25519.PublicKeySize {
+			return errors.New("invalid Ed25519 key")
+		}
+		if !ed25519.Verify(ed25519.PublicKey(x), data, signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case coseRS256:
+		n, _ := parsed[int64(-1)].([]byte)
+		e, _ := parsed[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return errors.New("invalid RSA key")
+		}
+		public := rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		hash := sha256.Sum256(data)
+		if err := rsa.VerifyPKCS1v15(&public, crypto.SHA256, hash[:], signature); err != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported credential algorithm %v", alg)
+}
@@ -0,0 +1,284 @@
+package safe
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+)
+
+// cborPair is an entry of a cbor map. Maps are kept as slices so that the
+// encoding is deterministic.
+type cborPair struct {
+	key   any
+	value any
+}
+
+func cborHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg < 1<<8:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg < 1<<16:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	case arg < 1<<32:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, arg)
+}
+
+// encodeCBOR encodes the items produced by the software authenticator.
+func encodeCBOR(item any) []byte {
+	switch v := item.(type) {
+	case int:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []cborPair:
+		data := cborHead(5, uint64(len(v)))
+		for _, pair := range v {
+			data = append(data, encodeCBOR(pair.key)...)
+			data = append(data, encodeCBOR(pair.value)...)
+		}
+		return data
+	}
+	panic("unsupported cbor item")
+}
+
+// softAuthenticator is a software authenticator that answers create and
+// get ceremonies like a browser with a security key would.
+type softAuthenticator struct {
+	rpID    string
+	origin  string
+	id      []byte
+	p256    *ecdsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+	count   uint32
+	flags   byte
+}
+
+func newSoftAuthenticator(t *testing.T, config WebAuthnConfig, alg int64) *softAuthenticator {
+	t.Helper()
+	a := &softAuthenticator{
+		rpID:   config.RPID,
+		origin: config.Origin,
+		id:     make([]byte, 16),
+		flags:  flagUserPresent | flagUserVerified,
+	}
+	rand.Read(a.id)
+	var err error
+	switch alg {
+	case coseES256:
+		a.p256, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case coseEdDSA:
+		_, a.ed25519, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unsupported algorithm %v", alg)
+	}
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	return a
+}
+
+func (a *softAuthenticator) publicKey() []byte {
+	if a.p256 != nil {
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		a.p256.X.FillBytes(x)
+		a.p256.Y.FillBytes(y)
+		return encodeCBOR([]cborPair{{1, 2}, {3, int(coseES256)}, {-1, 1}, {-2, x}, {-3, y}})
+	}
+	return encodeCBOR([]cborPair{{1, 1}, {3, int(coseEdDSA)}, {-1, 6}, {-2, []byte(a.ed25519.Public().(ed25519.PublicKey))}})
+}
+
+func (a *softAuthenticator) clientData(kind string, challenge []byte) []byte {
+	data, _ := json.Marshal(clientData{Type: kind, Challenge: b64url.EncodeToString(challenge), Origin: a.origin})
+	return data
+}
+
+func (a *softAuthenticator) authData(flags byte, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.count)
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+		data = append(data, a.id...)
+		data = append(data, a.publicKey()...)
+	}
+	return data
+}
+
+// create answers navigator.credentials.create with attestation none.
+func (a *softAuthenticator) create(challenge []byte) (clientDataJSON, attestationObject []byte) {
+	object := []cborPair{
+		{"fmt", "none"},
+		{"attStmt", []cborPair{}},
+		{"authData", a.authData(a.flags|flagAttested, true)},
+	}
+	return a.clientData("webauthn.create", challenge), encodeCBOR(object)
+}
+
+// get answers navigator.credentials.get, increasing the signature counter.
+func (a *softAuthenticator) get(challenge []byte) (clientDataJSON, authData, signature []byte) {
+	a.count++
+	clientDataJSON = a.clientData("webauthn.get", challenge)
+	authData = a.authData(a.flags, false)
+	hash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), hash[:]...)
+	if a.p256 != nil {
+		digest := sha256.Sum256(signed)
+		signature, _ = ecdsa.SignASN1(rand.Reader, a.p256, digest[:])
+	} else {
+		signature = ed25519.Sign(a.ed25519, signed)
+	}
+	return clientDataJSON, authData, signature
+}
+
+func testWebAuthnConfig(t *testing.T) WebAuthnConfig {
+	t.Helper()
+	config, err := NewWebAuthnConfig("https://safe.example.com")
+	if err != nil {
+		t.Fatalf("NewWebAuthnConfig: %v", err)
+	}
+	return config
+}
+
+func randomChallenge() []byte {
+	challenge := make([]byte, 32)
+	rand.Read(challenge)
+	return challenge
+}
+
+// register runs a registration ceremony and returns the stored credential.
+func register(t *testing.T, config WebAuthnConfig, a *softAuthenticator) WebAuthnCredential {
+	t.Helper()
+	challenge := randomChallenge()
+	clientDataJSON, attestation := a.create(challenge)
+	auth, err := config.VerifyRegistration(clientDataJSON, attestation, challenge)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	return WebAuthnCredential{ID: auth.CredentialID, PublicKey: auth.PublicKey, SignCount: auth.SignCount, Active: true}
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	config := testWebAuthnConfig(t)
+	for _, alg := range []int64{coseES256, coseEdDSA} {
+		a := newSoftAuthenticator(t, config, alg)
+		credential := register(t, config, a)
+		if string(credential.ID) != string(a.id) {
+			t.Errorf("alg %v: credential id = %x, want %x", alg, credential.ID, a.id)
+		}
+		if got, err := coseAlgorithm(credential.PublicKey); err != nil || got != alg {
+			t.Errorf("alg %v: credential algorithm = %v, %v", alg, got, err)
+		}
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	config := testWebAuthnConfig(t)
+	challenge := randomChallenge()
+	cases := map[string]func(a *softAuthenticator) ([]byte, []byte){
+		"other challenge": func(a *softAuthenticator) ([]byte, []byte) {
+			return a.create(randomChallenge())
+		},
+		"other origin": func(a *softAuthenticator) ([]byte, []byte) {
+			a.origin = "https://evil.example.com"
+			return a.create(challenge)
+		},
+		"other relying party": func(a *softAuthenticator) ([]byte, []byte) {
+			a.rpID = "evil.example.com"
+			return a.create(challenge)
+		},
+		"user not present": func(a *softAuthenticator) ([]byte, []byte) {
+			a.flags = 0
+			return a.create(challenge)
+		},
+		"assertion type": func(a *softAuthenticator) ([]byte, []byte) {
+			_, attestation := a.create(challenge)
+			return a.clientData("webauthn.get", challenge), attestation
+		},
+	}
+	for name, ceremony := range cases {
+		clientDataJSON, attestation := ceremony(newSoftAuthenticator(t, config, coseES256))
+		if _, err := config.VerifyRegistration(clientDataJSON, attestation, challenge); err == nil {
+			t.Errorf("%v: registration accepted", name)
+		}
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	config := testWebAuthnConfig(t)
+	for _, alg := range []int64{coseES256, coseEdDSA} {
+		a := newSoftAuthenticator(t, config, alg)
+		credential := register(t, config, a)
+		for round := 1; round <= 2; round++ {
+			challenge := randomChallenge()
+			clientDataJSON, authData, signature := a.get(challenge)
+			count, err := config.VerifyAssertion(credential, clientDataJSON, authData, signature, challenge, true)
+			if err != nil {
+				t.Fatalf("alg %v round %v: VerifyAssertion: %v", alg, round, err)
+			}
+			if count != a.count {
+				t.Errorf("alg %v round %v: counter = %v, want %v", alg, round, count, a.count)
+			}
+			credential.SignCount = count
+		}
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	config := testWebAuthnConfig(t)
+	a := newSoftAuthenticator(t, config, coseES256)
+	credential := register(t, config, a)
+	other := newSoftAuthenticator(t, config, coseES256)
+	challenge := randomChallenge()
+
+	clientDataJSON, authData, signature := a.get(challenge)
+	if _, err := config.VerifyAssertion(credential, clientDataJSON, authData, signature, randomChallenge(), false); err == nil {
+		t.Error("assertion for another challenge accepted")
+	}
+	tampered := append([]byte{}, signature...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := config.VerifyAssertion(credential, clientDataJSON, authData, tampered, challenge, false); err == nil {
+		t.Error("tampered signature accepted")
+	}
+	clientDataJSON, authData, signature = other.get(challenge)
+	if _, err := config.VerifyAssertion(credential, clientDataJSON, authData, signature, challenge, false); err == nil {
+		t.Error("assertion signed by another authenticator accepted")
+	}
+
+	clientDataJSON, authData, signature = a.get(challenge)
+	count, err := config.VerifyAssertion(credential, clientDataJSON, authData, signature, challenge, false)
+	if err != nil {
+		t.Fatalf("VerifyAssertion: %v", err)
+	}
+	credential.SignCount = count
+	// a clone answers with a counter that did not move
+	a.count--
+	clientDataJSON, authData, signature = a.get(challenge)
+	if _, err := config.VerifyAssertion(credential, clientDataJSON, authData, signature, challenge, false); err == nil {
+		t.Error("assertion with a stale counter accepted")
+	}
+
+	a.flags = flagUserPresent
+	clientDataJSON, authData, signature = a.get(challenge)
+	if _, err := config.VerifyAssertion(credential, clientDataJSON, authData, signature, challenge, true); err == nil {
+		t.Error("assertion without user verification accepted when required")
+	}
+	if _, err := config.VerifyAssertion(credential, clientDataJSON, authData, signature, challenge, false); err != nil {
+		t.Errorf("assertion without user verification refused when not required: %v", err)
+	}
+}
@@ -0,0 +1,298 @@
+package safe
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// Event types delivered to webhooks.
+const (
+	EventGrant  = "grant"
+	EventRevoke = "revoke"
+	EventJoin   = "join"
+)
+
+// Webhook deliveries are signed by the safe credentials. The signature
+// covers the raw body.
+const (
+	HeaderSafeToken     = "X-Safe-Token"
+	HeaderSafeSignature = "X-Safe-Signature"
+	HeaderEvent         = "X-Safe-Event"
+	HeaderDelivery      = "X-Safe-Delivery"
+)
+
+// maxDeliveries is the number of delivery attempts kept per webhook.
+const maxDeliveries = 100
+
+var webhookRetries = []time.Duration{
+	time.Second, 5 * time.Second, 30 * time.Second, 2 * time.Minute, 10 * time.Minute,
+}
+
+type WebhookEvent struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	Handle   string    `json:"handle"`
+	Attorney string    `json:"attorney"`
+	Epoch    uint64    `json:"epoch"`
+	Time     time.Time `json:"time"`
+}
+
+type WebhookDelivery struct {
+	Event     string    `json:"event"`
+	Type      string    `json:"type"`
+	Attempt   int       `json:"attempt"`
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Delivered bool      `json:"delivered"`
+	Time      time.Time `json:"time"`
+}
+
+// ErrWebhookAddress is returned for webhook urls that reach the safe's own
+// network instead of a public address.
+var ErrWebhookAddress = errors.New("webhook url must resolve to public addresses")
+
+var (
+	ErrWebhookURL  = errors.New("invalid webhook url")
+	ErrWebhookHost = errors.New("could not resolve webhook host")
+)
+
+// sharedAddresses is the carrier grade NAT range, private in practice but not
+// reported as such by net.IP.IsPrivate.
+var sharedAddresses = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicAddress tells whether webhooks may be delivered to ip. Loopback,
+// private (RFC 1918 and unique local), link-local (cloud metadata endpoints
+// live there), multicast and unspecified addresses are refused.
+func publicAddress(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		if ip[0] == 0 || sharedAddresses.Contains(ip) {
+			return false
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified())
+}
+
+type WebhookDispatcher struct {
+	mu          sync.Mutex
+	vault       *Vault
+	credentials crypto.PrivateKey
+	client      *http.Client
+	deliveries  map[string][]WebhookDelivery
+	// allowed filters the addresses webhooks are registered with and
+	// delivered to. Tests relax it to reach their local servers.
+	allowed func(net.IP) bool
+}
+
+func NewWebhookDispatcher(vault *Vault, credentials crypto.PrivateKey) *WebhookDispatcher {
+	d := &WebhookDispatcher{
+		vault:       vault,
+		credentials: credentials,
+		deliveries:  make(map[string][]WebhookDelivery),
+		allowed:     publicAddress,
+	}
+	// the address is checked again when connecting since the name may
+	// resolve differently than at registration. Proxies would hide the
+	// address and redirects could point anywhere, so neither is followed.
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: d.control}
+	d.client = &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 5 * time.Second},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return d
+}
+
+// control refuses connections to addresses webhooks may not reach.
+func (d *WebhookDispatcher) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !d.allowed(ip) {
+		return ErrWebhookAddress
+	}
+	return nil
+}
+
+// checkHost resolves host and tells whether all of its addresses are allowed.
+func (d *WebhookDispatcher) checkHost(host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !d.allowed(ip) {
+			return ErrWebhookAddress
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addresses) == 0 {
+		return fmt.Errorf("%w %v", ErrWebhookHost, host)
+	}
+	for _, address := range addresses {
+		if !d.allowed(address.IP) {
+			return ErrWebhookAddress
+		}
+	}
+	return nil
+}
+
+// VerifyWebhook checks the signature of a webhook body against the token of
+// the safe. Apps should also compare the token with the one they trust.
+func VerifyWebhook(body []byte, signatureHex string, safe crypto.Token) bool {
+	signatureBytes, _ := hex.DecodeString(signatureHex)
+	var signature crypto.Signature
+	if len(signatureBytes) != len(signature) {
+		return false
+	}
+	copy(signature[:], signatureBytes)
+	return safe.Verify(body, signature)
+}
+
+// Register adds a webhook of attorney. The host of target must resolve only
+// to addresses the dispatcher may deliver to.
+func (d *WebhookDispatcher) Register(attorney crypto.Token, target string) (Webhook, error) {
+	parsed, err := url.Parse(target)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return Webhook{}, ErrWebhookURL
+	}
+	if err := d.checkHost(parsed.Hostname()); err != nil {
+		return Webhook{}, err
+	}
+	hook := Webhook{ID: randomString(), Attorney: attorney, URL: target, Active: true}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.vault.SaveWebhook(hook); err != nil {
+		return Webhook{}, err
+	}
+	return hook, nil
+}
+
+func (d *WebhookDispatcher) Remove(attorney crypto.Token, id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hook, ok := d.vault.Webhook(id)
+	if !ok || !hook.Attorney.Equal(attorney) {
+		return false
+	}
+	hook.Active = false
+	if err := d.vault.SaveWebhook(hook); err != nil {
+		log.Printf("could not remove webhook: %v", err)
+		return false
+	}
+	delete(d.deliveries, id)
+	return true
+}
+
+// Hooks returns the webhooks registered by attorney.
+func (d *WebhookDispatcher) Hooks(attorney crypto.Token) []Webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hooks := make([]Webhook, 0)
+	for _, hook := range d.vault.Webhooks() {
+		if hook.Attorney.Equal(attorney) {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+// Deliveries returns the delivery log of a webhook of attorney.
+func (d *WebhookDispatcher) Deliveries(attorney crypto.Token, id string) ([]WebhookDelivery, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hook, ok := d.vault.Webhook(id)
+	if !ok || !hook.Attorney.Equal(attorney) {
+		return nil, false
+	}
+	return append([]WebhookDelivery{}, d.deliveries[id]...), true
+}
+
+// Notify delivers the event to every webhook of attorney.
+func (d *WebhookDispatcher) Notify(attorney crypto.Token, event WebhookEvent) {
+	event.ID = randomString()
+	event.Attorney = attorney.Hex()
+	event.Time = time.Now()
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("could not encode webhook event: %v", err)
+		return
+	}
+	for _, hook := range d.Hooks(attorney) {
+		go d.deliver(hook, event, body)
+	}
+}
+
+func (d *WebhookDispatcher) active(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.vault.Webhook(id)
+	return ok
+}
+
+func (d *WebhookDispatcher) log(id string, delivery WebhookDelivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.vault.Webhook(id); !ok {
+		return
+	}
+	deliveries := append(d.deliveries[id], delivery)
+	if len(deliveries) > maxDeliveries {
+		deliveries = deliveries[len(deliveries)-maxDeliveries:]
+	}
+	d.deliveries[id] = deliveries
+}
+
+func (d *WebhookDispatcher) deliver(hook Webhook, event WebhookEvent, body []byte) {
+	signature := d.credentials.Sign(body)
+	for attempt := 0; attempt <= len(webhookRetries); attempt++ {
+		if attempt > 0 {
+			time.Sleep(webhookRetries[attempt-1])
+		}
+		delivery := WebhookDelivery{Event: event.ID, Type: event.Type, Attempt: attempt + 1, Time: time.Now()}
+		req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+		if err != nil {
+			delivery.Error = err.Error()
+			d.log(hook.ID, delivery)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(HeaderEvent, event.Type)
+		req.Header.Set(HeaderDelivery, event.ID)
+		req.Header.Set(HeaderSafeToken, d.credentials.PublicKey().Hex())
+		req.Header.Set(HeaderSafeSignature, hex.EncodeToString(signature[:]))
+		resp, err := d.client.Do(req)
+		if err != nil {
+			delivery.Error = err.Error()
+		} else {
+			resp.Body.Close()
+			delivery.Status = resp.StatusCode
+			delivery.Delivered = resp.StatusCode >= 200 && resp.StatusCode < 300
+		}
+		d.log(hook.ID, delivery)
+		if delivery.Delivered {
+			return
+		}
+		if !d.active(hook.ID) {
+			return
+		}
+	}
+	log.Printf("webhook %v: giving up event %v after %v attempts", hook.ID, event.ID, len(webhookRetries)+1)
+}
@@ -0,0 +1,160 @@
+package safe
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func testVault(t *testing.T) *Vault {
+	t.Helper()
+	vault, err := OpenVaultFromPassword([]byte("test password"), t.TempDir())
+	if err != nil {
+		t.Fatalf("could not open vault: %v", err)
+	}
+	return vault
+}
+
+func TestPublicAddress(t *testing.T) {
+	cases := map[string]bool{
+		"8.8.8.8":         true,
+		"2001:4860::8888": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.0.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::":              false,
+		"fd00:ec2::254":   false,
+		"fe80::1":         false,
+		"::ffff:10.0.0.1": false,
+		"224.0.0.1":       false,
+	}
+	for address, public := range cases {
+		if got := publicAddress(net.ParseIP(address)); got != public {
+			t.Errorf("publicAddress(%v) = %v, want %v", address, got, public)
+		}
+	}
+}
+
+func TestRegisterRejectsInternalAddresses(t *testing.T) {
+	_, credentials := crypto.RandomAsymetricKey()
+	d := NewWebhookDispatcher(testVault(t), credentials)
+	app, _ := crypto.RandomAsymetricKey()
+	for _, target := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://[::1]/hook",
+		"https://10.0.0.1/hook",
+		"http://192.168.1.10/hook",
+		"http://169.254.169.254/latest/meta-data/",
+	} {
+		if _, err := d.Register(app, target); !errors.Is(err, ErrWebhookAddress) {
+			t.Errorf("Register(%v) = %v, want ErrWebhookAddress", target, err)
+		}
+	}
+	for _, target := range []string{"ftp://example.com/", "http:///hook"} {
+		if _, err := d.Register(app, target); err == nil {
+			t.Errorf("Register(%v) accepted an invalid url", target)
+		}
+	}
+	if hooks := d.Hooks(app); len(hooks) != 0 {
+		t.Errorf("rejected webhooks were registered: %v", hooks)
+	}
+}
+
+func TestDeliverSignedEvent(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	_, credentials := crypto.RandomAsymetricKey()
+	d := NewWebhookDispatcher(testVault(t), credentials)
+	d.allowed = func(ip net.IP) bool { return ip.IsLoopback() }
+	app, _ := crypto.RandomAsymetricKey()
+	hook, err := d.Register(app, server.URL+"/hook")
+	if err != nil {
+		t.Fatalf("could not register webhook: %v", err)
+	}
+	d.Notify(app, WebhookEvent{Type: EventGrant, Handle: "alice", Epoch: 10})
+
+	select {
+	case r := <-received:
+		body := <-bodies
+		if r.Header.Get(HeaderEvent) != EventGrant {
+			t.Errorf("event header = %q, want %q", r.Header.Get(HeaderEvent), EventGrant)
+		}
+		if !VerifyWebhook(body, r.Header.Get(HeaderSafeSignature), credentials.PublicKey()) {
+			t.Error("webhook signature does not verify")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+	// the delivery is logged after the response is read
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, _ := d.Deliveries(app, hook.ID)
+		if len(deliveries) == 1 && deliveries[0].Delivered {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("delivery not logged: %v", deliveries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDeliveryRechecksAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback address")
+	}))
+	defer server.Close()
+
+	_, credentials := crypto.RandomAsymetricKey()
+	d := NewWebhookDispatcher(testVault(t), credentials)
+	// a name that resolved to a public address at registration may point
+	// to the local network by the time an event is delivered
+	resp, err := d.client.Post(server.URL, "application/json", nil)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("delivery to a loopback address succeeded")
+	}
+	if !errors.Is(err, ErrWebhookAddress) {
+		t.Errorf("delivery error = %v, want ErrWebhookAddress", err)
+	}
+}
+
+func TestDeliveryDoesNotFollowRedirects(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("redirect was followed")
+	}))
+	defer target.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusFound))
+	defer redirect.Close()
+
+	_, credentials := crypto.RandomAsymetricKey()
+	d := NewWebhookDispatcher(testVault(t), credentials)
+	d.allowed = func(ip net.IP) bool { return ip.IsLoopback() }
+	resp, err := d.client.Post(redirect.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("could not post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("status = %v, want %v", resp.StatusCode, http.StatusFound)
+	}
+}